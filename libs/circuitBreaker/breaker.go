@@ -0,0 +1,351 @@
+package circuitBreaker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"sarasa/libs/errorHandling"
+)
+
+// State of a Breaker.
+type State int
+
+const (
+	// Closed lets every call through.
+	Closed State = iota
+	// Open rejects every call until OpenTimeout has passed.
+	Open
+	// HalfOpen lets HalfOpenMaxCalls probes through: a success closes the
+	// breaker, a failure opens it again.
+	HalfOpen
+)
+
+func (state State) String() string {
+	switch state {
+	case Open:
+		return "open"
+	case HalfOpen:
+		return "half_open"
+	}
+
+	return "closed"
+}
+
+// ErrOpen is returned, wrapped in an *OpenError, by calls rejected by an
+// open breaker.
+var ErrOpen = errors.New("circuit open")
+
+// OpenError tells which breaker rejected the call and when it will let a
+// probe through.
+type OpenError struct {
+	Name    string
+	RetryAt time.Time
+}
+
+func (e *OpenError) Error() string {
+	return fmt.Sprintf("%s: %s until %s", e.Name, ErrOpen, e.RetryAt.UTC().Format(time.RFC3339))
+}
+
+func (e *OpenError) Unwrap() error {
+	return ErrOpen
+}
+
+func init() {
+	// Retrying before the breaker lets a probe through is pointless.
+	errorHandling.RegisterClassifier(func(err error) (errorHandling.Category, bool) {
+		if errors.Is(err, ErrOpen) {
+			return errorHandling.Permanent, true
+		}
+
+		return errorHandling.Unknown, false
+	})
+}
+
+// Settings of a Breaker. Zero values get the defaults below.
+type Settings struct {
+	// FailureThreshold consecutive failures open the breaker, 5 by default.
+	FailureThreshold int
+	// OpenTimeout is how long the breaker stays open before probing, 30
+	// seconds by default.
+	OpenTimeout time.Duration
+	// HalfOpenMaxCalls probes are let through while half open, 1 by default.
+	HalfOpenMaxCalls int
+	// IsFailure tells which errors count as failures, IsFailure by default.
+	IsFailure func(err error) bool
+	// OnStateChange is called on every transition of this breaker, on top of
+	// the observer set with SetObserver.
+	OnStateChange func(name string, from, to State)
+}
+
+// Observer is called on every transition of every breaker, e.g. to send a
+// metric.
+type Observer func(name string, from, to State)
+
+var observerMu sync.RWMutex
+var observer Observer
+
+func SetObserver(o Observer) {
+	observerMu.Lock()
+	defer observerMu.Unlock()
+
+	observer = o
+}
+
+// IsFailure counts every error but permanent and invalid input ones, which
+// say nothing about the dependency's health, e.g. a 404 or a bad query.
+func IsFailure(err error) bool {
+	switch errorHandling.CategoryOf(err) {
+	case errorHandling.Permanent, errorHandling.InvalidInput:
+		return false
+	}
+
+	return true
+}
+
+func (settings Settings) withDefaults() Settings {
+	if settings.FailureThreshold <= 0 {
+		settings.FailureThreshold = 5
+	}
+
+	if settings.OpenTimeout <= 0 {
+		settings.OpenTimeout = 30 * time.Second
+	}
+
+	if settings.HalfOpenMaxCalls <= 0 {
+		settings.HalfOpenMaxCalls = 1
+	}
+
+	if settings.IsFailure == nil {
+		settings.IsFailure = IsFailure
+	}
+
+	return settings
+}
+
+// Breaker stops calling a dependency after repeated failures, giving it
+// time to recover and failing fast meanwhile.
+type Breaker struct {
+	name     string
+	settings Settings
+
+	mu       sync.Mutex
+	state    State
+	failures int
+	openedAt time.Time
+	probes   int
+	lastErr  error
+}
+
+func New(name string, settings Settings) *Breaker {
+	return &Breaker{name: name, settings: settings.withDefaults()}
+}
+
+func (breaker *Breaker) Name() string {
+	return breaker.name
+}
+
+// State returns the current state, moving from open to half open when the
+// timeout has passed.
+func (breaker *Breaker) State() State {
+	breaker.mu.Lock()
+	defer breaker.mu.Unlock()
+
+	return breaker.currentState(time.Now())
+}
+
+// Allow reserves a call. It returns an *OpenError when the breaker rejects
+// it; otherwise done must be called with the call's result.
+func (breaker *Breaker) Allow() (done func(err error), err error) {
+	breaker.mu.Lock()
+	defer breaker.mu.Unlock()
+
+	now := time.Now()
+
+	switch breaker.currentState(now) {
+	case Open:
+		return nil, &OpenError{Name: breaker.name, RetryAt: breaker.openedAt.Add(breaker.settings.OpenTimeout)}
+	case HalfOpen:
+		if breaker.probes >= breaker.settings.HalfOpenMaxCalls {
+			return nil, &OpenError{Name: breaker.name, RetryAt: now.Add(breaker.settings.OpenTimeout)}
+		}
+
+		breaker.probes++
+	}
+
+	var once sync.Once
+
+	return func(err error) {
+		once.Do(func() { breaker.record(err) })
+	}, nil
+}
+
+// Do runs f unless the breaker is open and records its result.
+func (breaker *Breaker) Do(f func() error) error {
+	done, err := breaker.Allow()
+	if err != nil {
+		return err
+	}
+
+	err = f()
+	done(err)
+
+	return err
+}
+
+func (breaker *Breaker) record(err error) {
+	breaker.mu.Lock()
+	defer breaker.mu.Unlock()
+
+	now := time.Now()
+	state := breaker.currentState(now)
+
+	if state == HalfOpen && breaker.probes > 0 {
+		breaker.probes--
+	}
+
+	if err == nil || !breaker.settings.IsFailure(err) {
+		breaker.failures = 0
+
+		if state == HalfOpen {
+			breaker.setState(Closed, now)
+		}
+
+		return
+	}
+
+	breaker.failures++
+	breaker.lastErr = err
+
+	if state == HalfOpen || (state == Closed && breaker.failures >= breaker.settings.FailureThreshold) {
+		breaker.setState(Open, now)
+	}
+}
+
+// currentState must be called with mu held.
+func (breaker *Breaker) currentState(now time.Time) State {
+	if breaker.state == Open && now.Sub(breaker.openedAt) >= breaker.settings.OpenTimeout {
+		breaker.setState(HalfOpen, now)
+	}
+
+	return breaker.state
+}
+
+// setState must be called with mu held.
+func (breaker *Breaker) setState(state State, now time.Time) {
+	from := breaker.state
+	if from == state {
+		return
+	}
+
+	breaker.state = state
+	breaker.probes = 0
+
+	switch state {
+	case Open:
+		breaker.openedAt = now
+	case Closed:
+		breaker.failures = 0
+		breaker.lastErr = nil
+	}
+
+	log.Printf("Circuit breaker %s: %s -> %s", breaker.name, from, state)
+
+	if breaker.settings.OnStateChange != nil {
+		go breaker.settings.OnStateChange(breaker.name, from, state)
+	}
+
+	observerMu.RLock()
+	o := observer
+	observerMu.RUnlock()
+
+	if o != nil {
+		go o(breaker.name, from, state)
+	}
+}
+
+// HealthCheck reports the state, failing while open. It fits
+// lifecycle.Check and accepts a nil breaker, e.g. of a disabled client.
+func (breaker *Breaker) HealthCheck(ctx context.Context) (string, error) {
+	if breaker == nil {
+		return "disabled", nil
+	}
+
+	breaker.mu.Lock()
+	defer breaker.mu.Unlock()
+
+	state := breaker.currentState(time.Now())
+	if state != Open {
+		return state.String(), nil
+	}
+
+	return state.String(), fmt.Errorf("open since %s after %d failures, last error: %s",
+		breaker.openedAt.UTC().Format(time.RFC3339), breaker.failures, breaker.lastErr)
+}
+
+// Registry holds one breaker per name, e.g. per scraped host, created on
+// first use with the same settings.
+type Registry struct {
+	settings Settings
+
+	mu       sync.Mutex
+	breakers map[string]*Breaker
+}
+
+func NewRegistry(settings Settings) *Registry {
+	return &Registry{settings: settings, breakers: make(map[string]*Breaker)}
+}
+
+func (registry *Registry) Get(name string) *Breaker {
+	registry.mu.Lock()
+	defer registry.mu.Unlock()
+
+	breaker, ok := registry.breakers[name]
+	if !ok {
+		breaker = New(name, registry.settings)
+		registry.breakers[name] = breaker
+	}
+
+	return breaker
+}
+
+// HealthCheck lists the breakers that aren't closed, failing when any is
+// open. It fits lifecycle.Check.
+func (registry *Registry) HealthCheck(ctx context.Context) (string, error) {
+	registry.mu.Lock()
+	breakers := make([]*Breaker, 0, len(registry.breakers))
+	for _, breaker := range registry.breakers {
+		breakers = append(breakers, breaker)
+	}
+	registry.mu.Unlock()
+
+	sort.Slice(breakers, func(i, j int) bool { return breakers[i].name < breakers[j].name })
+
+	var notClosed, open []string
+
+	for _, breaker := range breakers {
+		switch state := breaker.State(); state {
+		case Open:
+			open = append(open, breaker.name)
+			notClosed = append(notClosed, breaker.name+": "+state.String())
+		case HalfOpen:
+			notClosed = append(notClosed, breaker.name+": "+state.String())
+		}
+	}
+
+	detail := fmt.Sprintf("%d breakers", len(breakers))
+	if len(notClosed) > 0 {
+		detail += ", " + strings.Join(notClosed, ", ")
+	}
+
+	if len(open) > 0 {
+		return detail, fmt.Errorf("open: %s", strings.Join(open, ", "))
+	}
+
+	return detail, nil
+}
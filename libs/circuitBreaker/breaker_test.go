@@ -0,0 +1,149 @@
+package circuitBreaker
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+var errDown = errors.New("dependency down")
+
+// errIgnored is an error that says nothing about the dependency's health.
+var errIgnored = errors.New("not found")
+
+func newTestBreaker() *Breaker {
+	return New("test", Settings{
+		FailureThreshold: 2,
+		OpenTimeout:      20 * time.Millisecond,
+		HalfOpenMaxCalls: 2,
+		IsFailure:        func(err error) bool { return !errors.Is(err, errIgnored) },
+	})
+}
+
+// step makes a call with result, or waits for the open timeout when wait is
+// set, then checks the breaker's state.
+type step struct {
+	result   error
+	wait     bool
+	rejected bool
+	state    State
+}
+
+func TestBreakerTransitions(t *testing.T) {
+	tests := map[string][]step{
+		"successes keep it closed": {
+			{state: Closed},
+			{state: Closed},
+		},
+		"consecutive failures open it": {
+			{result: errDown, state: Closed},
+			{result: errDown, state: Open},
+			{rejected: true, state: Open},
+		},
+		"a success resets the failures": {
+			{result: errDown, state: Closed},
+			{state: Closed},
+			{result: errDown, state: Closed},
+		},
+		"ignored errors aren't failures": {
+			{result: errIgnored, state: Closed},
+			{result: errIgnored, state: Closed},
+			{result: errDown, state: Closed},
+		},
+		"it half opens after the open timeout and a probe success closes it": {
+			{result: errDown, state: Closed},
+			{result: errDown, state: Open},
+			{wait: true, state: HalfOpen},
+			{state: Closed},
+			{result: errDown, state: Closed},
+		},
+		"a probe failure opens it again": {
+			{result: errDown, state: Closed},
+			{result: errDown, state: Open},
+			{wait: true, state: HalfOpen},
+			{result: errDown, state: Open},
+			{rejected: true, state: Open},
+		},
+	}
+
+	for name, steps := range tests {
+		t.Run(name, func(t *testing.T) {
+			breaker := newTestBreaker()
+
+			for i, s := range steps {
+				if s.wait {
+					time.Sleep(breaker.settings.OpenTimeout)
+				} else {
+					err := breaker.Do(func() error { return s.result })
+
+					if s.rejected {
+						require.ErrorIs(t, err, ErrOpen, "step %d", i)
+					} else {
+						require.Equal(t, s.result, err, "step %d", i)
+					}
+				}
+
+				require.Equal(t, s.state, breaker.State(), "step %d", i)
+			}
+		})
+	}
+}
+
+func TestBreakerLimitsHalfOpenProbes(t *testing.T) {
+	breaker := newTestBreaker()
+
+	for i := 0; i < 2; i++ {
+		require.Equal(t, errDown, breaker.Do(func() error { return errDown }))
+	}
+
+	_, err := breaker.Allow()
+
+	var openErr *OpenError
+	require.ErrorAs(t, err, &openErr)
+	require.Equal(t, "test", openErr.Name)
+
+	time.Sleep(breaker.settings.OpenTimeout)
+
+	first, err := breaker.Allow()
+	require.NoError(t, err)
+
+	second, err := breaker.Allow()
+	require.NoError(t, err)
+
+	_, err = breaker.Allow()
+	require.ErrorIs(t, err, ErrOpen, "more probes than HalfOpenMaxCalls")
+
+	// Errors that aren't failures count as successful probes.
+	first(errIgnored)
+	require.Equal(t, Closed, breaker.State())
+
+	// Calling done twice records once, one failure doesn't reach the threshold.
+	second(errDown)
+	second(errDown)
+	require.Equal(t, Closed, breaker.State())
+}
+
+func TestBreakerHealthCheck(t *testing.T) {
+	breaker := newTestBreaker()
+
+	detail, err := breaker.HealthCheck(nil)
+	require.NoError(t, err)
+	require.Equal(t, "closed", detail)
+
+	for i := 0; i < 2; i++ {
+		_ = breaker.Do(func() error { return errDown })
+	}
+
+	detail, err = breaker.HealthCheck(nil)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "dependency down")
+	require.Equal(t, "open", detail)
+
+	var disabled *Breaker
+
+	detail, err = disabled.HealthCheck(nil)
+	require.NoError(t, err)
+	require.Equal(t, "disabled", detail)
+}
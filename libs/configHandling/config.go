@@ -1,15 +1,180 @@
 package configHandling
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"log"
 	"net/http"
+	"net/url"
 	"os"
+	"strings"
+	"sync"
+	"time"
 
+	"sarasa/libs/errorHandling"
+	"sarasa/libs/retryHandling"
 	"sarasa/schemas"
 )
 
-func LoadConfig(c *schemas.Config, serviceName string) error {
+// loaded is the last configuration received from the config server.
+var loaded struct {
+	mu      sync.Mutex
+	at      time.Time
+	etag    string
+	sources []string
+	// sections are validated again on every change.
+	sections []string
+}
+
+// FetchPolicy retries fetching the configuration while the config server is
+// starting.
+var FetchPolicy = retryHandling.Policy{
+	MaxElapsed:   time.Minute,
+	InitialDelay: 500 * time.Millisecond,
+	MaxDelay:     10 * time.Second,
+	Jitter:       0.2,
+	OnRetry:      retryHandling.LogRetry,
+}
+
+// TokenEnv holds the token the config server asks this service for.
+const TokenEnv = "CONFIG_SERVER_TOKEN"
+
+// ProfileEnv names the config server profile to load, the server's default
+// one when unset.
+const ProfileEnv = "CONFIG_PROFILE"
+
+// WatchTimeout is how long a /watch long poll waits for a change.
+const WatchTimeout = 30 * time.Second
+
+// watchRetryDelay is the first wait after a failed watch, doubled up to
+// maxWatchRetryDelay while the config server stays unreachable.
+const (
+	watchRetryDelay    = time.Second
+	maxWatchRetryDelay = 30 * time.Second
+)
+
+// LoadConfig loads the configuration of serviceName into c from, by
+// increasing precedence:
+//   - the config server, when CONFIG_SERVER_SCHEMA, _HOST and _PORT are set
+//   - the JSON or YAML file named by SARASA_CONFIG_FILE
+//   - the SARASA_ environment variables, see EnvPrefix
+//
+// With a file, an unreachable config server is skipped. The effective
+// configuration is logged with its secrets redacted, then the sections the
+// service requires are validated.
+func LoadConfig(c *schemas.Config, serviceName string, sections ...string) error {
+	var sources []string
+
+	configURL, err := configServerURL("/", serviceName)
+	switch {
+	case err == nil:
+		err = FetchPolicy.Do(context.Background(), func(ctx context.Context) error {
+			_, err := fetchConfig(ctx, configURL, "", c)
+
+			return err
+		})
+		if err == nil {
+			sources = append(sources, "config server")
+		} else if os.Getenv(ConfigFileEnv) == "" {
+			return err
+		} else {
+			log.Printf("Config server unavailable, using %s - error: %s", os.Getenv(ConfigFileEnv), err)
+		}
+	case os.Getenv(ConfigFileEnv) == "":
+		return fmt.Errorf("%w, or %s", err, ConfigFileEnv)
+	}
+
+	localSources, err := applyLocal(c)
+	if err != nil {
+		return err
+	}
+
+	sources = append(sources, localSources...)
+
+	log.Printf("Configuration of %s loaded from %s: %s", serviceName, strings.Join(sources, ", "), Dump(*c))
+
+	if err := Validate(*c, sections...); err != nil {
+		return err
+	}
+
+	loaded.mu.Lock()
+	loaded.sections = sections
+	loaded.mu.Unlock()
+
+	markLoaded(sources)
+
+	return nil
+}
+
+// Watch long polls the config server and calls apply with every new
+// configuration of serviceName until ctx is done. Call it after LoadConfig,
+// in its own goroutine; apply runs on that goroutine.
+func Watch(ctx context.Context, serviceName string, apply func(c schemas.Config)) {
+	watchURL, err := configServerURL("/watch", serviceName)
+	if err != nil {
+		log.Printf("No config server, configuration changes won't be applied")
+		return
+	}
+
+	watchURL += fmt.Sprintf("&timeout=%d", int(WatchTimeout.Seconds()))
+	delay := watchRetryDelay
+
+	for ctx.Err() == nil {
+		var c schemas.Config
+
+		changed, err := fetchConfig(ctx, watchURL, currentETag(), &c)
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+
+			errorHandling.Report(err, "Failed watching configuration")
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(delay):
+			}
+
+			if delay *= 2; delay > maxWatchRetryDelay {
+				delay = maxWatchRetryDelay
+			}
+
+			continue
+		}
+
+		delay = watchRetryDelay
+
+		if !changed {
+			continue
+		}
+
+		// The local sources still take precedence over the new configuration.
+		sources, err := applyLocal(&c)
+		if err != nil {
+			errorHandling.Report(err, "Failed applying local configuration, ignoring the change")
+			continue
+		}
+
+		loaded.mu.Lock()
+		sections := loaded.sections
+		loaded.mu.Unlock()
+
+		if err := Validate(c, sections...); err != nil {
+			errorHandling.Report(err, "Invalid configuration, ignoring the change")
+			continue
+		}
+
+		markLoaded(append([]string{"config server"}, sources...))
+
+		log.Printf("Configuration of %s changed, applying it: %s", serviceName, Dump(c))
+		apply(c)
+	}
+}
+
+func configServerURL(path, serviceName string) (string, error) {
 	configServerSchema := os.Getenv("CONFIG_SERVER_SCHEMA")
 	configServerHost := os.Getenv("CONFIG_SERVER_HOST")
 	configServerPort := os.Getenv("CONFIG_SERVER_PORT")
@@ -17,17 +182,94 @@ func LoadConfig(c *schemas.Config, serviceName string) error {
 	if configServerSchema == "" ||
 		configServerHost == "" ||
 		configServerPort == "" {
-		return fmt.Errorf("need to define config server schema, host and port")
+		return "", fmt.Errorf("need to define config server schema, host and port")
+	}
+
+	configURL := fmt.Sprintf(
+		"%s://%s:%s%s?serviceName=%s",
+		configServerSchema, configServerHost, configServerPort, path, url.QueryEscape(serviceName))
+
+	if profile := os.Getenv(ProfileEnv); profile != "" {
+		configURL += "&profile=" + url.QueryEscape(profile)
 	}
 
-	response, err := http.Get(
-		fmt.Sprintf(
-			"%s://%s:%s/?serviceName=%s",
-			configServerSchema, configServerHost, configServerPort, serviceName),
-	)
+	return configURL, nil
+}
+
+// fetchConfig decodes the configuration at configURL into c. With an etag it
+// returns false, leaving c untouched, when the configuration didn't change.
+func fetchConfig(ctx context.Context, configURL, etag string, c *schemas.Config) (bool, error) {
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, configURL, nil)
 	if err != nil {
-		return err
+		return false, errorHandling.NewPermanent(err)
+	}
+
+	if etag != "" {
+		request.Header.Set("If-None-Match", etag)
+	}
+
+	if token := os.Getenv(TokenEnv); token != "" {
+		request.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		return false, errorHandling.Wrapf(errorHandling.DependencyDown, err, "configHandling/LoadConfig - Fail to reach the config server")
+	}
+
+	defer response.Body.Close()
+
+	if response.StatusCode == http.StatusNotModified {
+		return false, nil
+	}
+
+	if response.StatusCode >= http.StatusInternalServerError {
+		return false, errorHandling.NewTransient(fmt.Errorf("configHandling/LoadConfig - Fail to fetch the configuration, error: status %d", response.StatusCode))
+	}
+
+	if response.StatusCode != http.StatusOK {
+		return false, errorHandling.NewPermanent(fmt.Errorf("configHandling/LoadConfig - Fail to fetch the configuration, error: status %d", response.StatusCode))
+	}
+
+	if err := json.NewDecoder(response.Body).Decode(c); err != nil {
+		return false, errorHandling.Wrapf(errorHandling.InvalidInput, err, "configHandling/LoadConfig - Fail to decode the configuration")
+	}
+
+	loaded.mu.Lock()
+	loaded.etag = response.Header.Get("ETag")
+	loaded.mu.Unlock()
+
+	return true, nil
+}
+
+func markLoaded(sources []string) {
+	loaded.mu.Lock()
+	defer loaded.mu.Unlock()
+
+	loaded.at = time.Now()
+	loaded.sources = sources
+}
+
+func currentETag() string {
+	loaded.mu.Lock()
+	defer loaded.mu.Unlock()
+
+	return loaded.etag
+}
+
+// LoadedCheck is a health check failing until LoadConfig succeeded.
+func LoadedCheck(ctx context.Context) (string, error) {
+	loaded.mu.Lock()
+	defer loaded.mu.Unlock()
+
+	if loaded.at.IsZero() {
+		return "", errors.New("configuration not loaded")
+	}
+
+	detail := "loaded at " + loaded.at.UTC().Format(time.RFC3339) + " from " + strings.Join(loaded.sources, ", ")
+	if loaded.etag != "" {
+		detail += ", etag " + loaded.etag
 	}
 
-	return json.NewDecoder(response.Body).Decode(c)
+	return detail, nil
 }
@@ -0,0 +1,69 @@
+package configHandling
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+
+	"sarasa/schemas"
+)
+
+// Redacted replaces the value of set secrets in Redacted configurations.
+const Redacted = "***"
+
+// secretNames flag the fields holding secrets, matched case insensitively
+// against the end of their JSON name.
+var secretNames = []string{"password", "token", "secret", "key"}
+
+// Redact returns c as a JSON-like map with secrets replaced by Redacted, so
+// it can be logged or served.
+func Redact(c schemas.Config) map[string]interface{} {
+	return redactStruct(reflect.ValueOf(c))
+}
+
+// Dump returns the redacted JSON of c.
+func Dump(c schemas.Config) string {
+	dump, err := json.Marshal(Redact(c))
+	if err != nil {
+		return err.Error()
+	}
+
+	return string(dump)
+}
+
+// IsSecret tells whether the field with that JSON name holds a secret.
+func IsSecret(name string) bool {
+	name = strings.ToLower(name)
+
+	for _, secret := range secretNames {
+		if strings.HasSuffix(name, secret) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func redactStruct(v reflect.Value) map[string]interface{} {
+	m := make(map[string]interface{}, v.NumField())
+
+	for i := 0; i < v.NumField(); i++ {
+		name := jsonName(v.Type().Field(i))
+		if name == "" {
+			continue
+		}
+
+		field := v.Field(i)
+
+		switch {
+		case field.Kind() == reflect.Struct:
+			m[name] = redactStruct(field)
+		case IsSecret(name) && !field.IsZero():
+			m[name] = Redacted
+		default:
+			m[name] = field.Interface()
+		}
+	}
+
+	return m
+}
@@ -0,0 +1,201 @@
+package configHandling
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+	"sarasa/libs/errorHandling"
+	"sarasa/schemas"
+)
+
+// EnvPrefix starts every environment variable overriding a configuration
+// field, e.g. SARASA_RABBITMQ_HOST or SARASA_PROVIDER_SOURCE_URL: the JSON
+// names of the field's path, upper cased and joined by underscores.
+const EnvPrefix = "SARASA_"
+
+// ConfigFileEnv names the JSON or YAML file read on top of the config server
+// configuration.
+const ConfigFileEnv = EnvPrefix + "CONFIG_FILE"
+
+// applyLocal applies the configuration file and the environment overrides
+// on top of c, returning the sources it used.
+func applyLocal(c *schemas.Config) ([]string, error) {
+	var sources []string
+
+	if file := os.Getenv(ConfigFileEnv); file != "" {
+		if err := applyFile(c, file); err != nil {
+			return nil, err
+		}
+
+		sources = append(sources, "file "+file)
+	}
+
+	overrides, err := applyEnv(c, os.Environ())
+	if err != nil {
+		return nil, err
+	}
+
+	if len(overrides) > 0 {
+		sources = append(sources, "env "+strings.Join(overrides, ", "))
+	}
+
+	return sources, nil
+}
+
+// applyFile decodes file on top of c, leaving the fields it doesn't set as
+// they are. Files ending in .yaml or .yml are read as YAML, others as JSON;
+// both use the JSON field names.
+func applyFile(c *schemas.Config, file string) error {
+	content, err := os.ReadFile(file)
+	if err != nil {
+		return errorHandling.Wrapf(errorHandling.Permanent, err, "configHandling/applyFile - Fail to read %s", file)
+	}
+
+	switch strings.ToLower(filepath.Ext(file)) {
+	case ".yaml", ".yml":
+		var document interface{}
+		if err := yaml.Unmarshal(content, &document); err != nil {
+			return errorHandling.Wrapf(errorHandling.InvalidInput, err, "configHandling/applyFile - Fail to decode %s", file)
+		}
+
+		content, err = json.Marshal(jsonCompatible(document))
+		if err != nil {
+			return errorHandling.Wrapf(errorHandling.InvalidInput, err, "configHandling/applyFile - Fail to convert %s", file)
+		}
+	}
+
+	if err := json.Unmarshal(content, c); err != nil {
+		return errorHandling.Wrapf(errorHandling.InvalidInput, err, "configHandling/applyFile - Fail to decode %s", file)
+	}
+
+	return nil
+}
+
+// jsonCompatible turns the map[interface{}]interface{} YAML decodes into
+// map[string]interface{}, which encoding/json accepts.
+func jsonCompatible(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			m[fmt.Sprint(key)] = jsonCompatible(item)
+		}
+
+		return m
+	case []interface{}:
+		for i, item := range v {
+			v[i] = jsonCompatible(item)
+		}
+	}
+
+	return value
+}
+
+// applyEnv sets the fields of c overridden by a SARASA_ variable of environ,
+// returning their variable names. Unknown SARASA_ variables are logged.
+func applyEnv(c *schemas.Config, environ []string) ([]string, error) {
+	fields := make(map[string]reflect.Value)
+	collectEnvFields(reflect.ValueOf(c).Elem(), strings.TrimSuffix(EnvPrefix, "_"), fields)
+
+	var overrides []string
+
+	for _, entry := range environ {
+		name, value, _ := strings.Cut(entry, "=")
+		if !strings.HasPrefix(name, EnvPrefix) || name == ConfigFileEnv {
+			continue
+		}
+
+		field, ok := fields[name]
+		if !ok {
+			log.Printf("Ignoring %s, it doesn't match any configuration field", name)
+			continue
+		}
+
+		if err := setField(field, value); err != nil {
+			return nil, errorHandling.Wrapf(errorHandling.InvalidInput, err, "configHandling/applyEnv - Fail to apply %s", name)
+		}
+
+		overrides = append(overrides, name)
+	}
+
+	return overrides, nil
+}
+
+// collectEnvFields maps the environment variable name of every settable
+// field of v to the field.
+func collectEnvFields(v reflect.Value, prefix string, fields map[string]reflect.Value) {
+	for i := 0; i < v.NumField(); i++ {
+		name := jsonName(v.Type().Field(i))
+		if name == "" {
+			continue
+		}
+
+		key := prefix + "_" + strings.ToUpper(name)
+		field := v.Field(i)
+
+		if field.Kind() == reflect.Struct {
+			collectEnvFields(field, key, fields)
+			continue
+		}
+
+		fields[key] = field
+	}
+}
+
+// jsonName returns the JSON name of an exported field, empty when it isn't
+// encoded.
+func jsonName(field reflect.StructField) string {
+	if !field.IsExported() {
+		return ""
+	}
+
+	name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+	if name == "-" {
+		return ""
+	}
+
+	if name == "" {
+		name = field.Name
+	}
+
+	return name
+}
+
+func setField(field reflect.Value, value string) error {
+	switch field.Kind() {
+	case reflect.String:
+		field.SetString(value)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return err
+		}
+
+		field.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(value, 10, field.Type().Bits())
+		if err != nil {
+			return err
+		}
+
+		field.SetInt(n)
+	case reflect.Float32, reflect.Float64:
+		f, err := strconv.ParseFloat(value, field.Type().Bits())
+		if err != nil {
+			return err
+		}
+
+		field.SetFloat(f)
+	default:
+		return fmt.Errorf("unsupported field type %s", field.Type())
+	}
+
+	return nil
+}
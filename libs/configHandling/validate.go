@@ -0,0 +1,167 @@
+package configHandling
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+
+	"sarasa/libs/errorHandling"
+	"sarasa/schemas"
+)
+
+// Sections of schemas.Config a service can require, named like their file
+// and dependency in the config server.
+const (
+	Health   = "health"
+	Influx   = "influxdb"
+	Postgres = "postgres"
+	RabbitMQ = "rabbitmq"
+	Provider = "provider"
+	Telegram = "telegram"
+	Showcase = "showcase_server"
+)
+
+// Problem is a configuration field failing a rule.
+type Problem struct {
+	Field   string
+	Message string
+}
+
+func (problem Problem) String() string {
+	return problem.Field + ": " + problem.Message
+}
+
+// ValidationError lists every problem found in a configuration.
+type ValidationError struct {
+	Problems []Problem
+}
+
+func (e *ValidationError) Error() string {
+	lines := make([]string, 0, len(e.Problems)+1)
+	lines = append(lines, fmt.Sprintf("invalid configuration, %d problems:", len(e.Problems)))
+
+	for _, problem := range e.Problems {
+		lines = append(lines, "  - "+problem.String())
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+var sectionRules = map[string]func(v *validator, c schemas.Config){
+	Health: func(v *validator, c schemas.Config) {
+		if c.Health.Port != 0 {
+			v.between("health.port", c.Health.Port, 1, 65535)
+		}
+	},
+	Influx: func(v *validator, c schemas.Config) {
+		if !c.Influx.Enabled {
+			return
+		}
+
+		v.url("influxDB.url", c.Influx.Url)
+		v.required("influxDB.database", c.Influx.Database)
+		v.atLeast("influxDB.batchSize", c.Influx.BatchSize, 0)
+		v.atLeast("influxDB.flushIntervalMs", c.Influx.FlushIntervalMs, 0)
+		v.atLeast("influxDB.bufferSize", c.Influx.BufferSize, 0)
+
+		if c.Influx.BufferSize > 0 && c.Influx.BatchSize > c.Influx.BufferSize {
+			v.add("influxDB.batchSize", "must not exceed bufferSize")
+		}
+	},
+	Postgres: func(v *validator, c schemas.Config) {
+		v.required("postgres.host", c.Postgres.Host)
+		v.required("postgres.user", c.Postgres.User)
+		v.required("postgres.database", c.Postgres.Database)
+		v.atLeast("postgres.maxOpenConns", c.Postgres.MaxOpenConns, 0)
+		v.atLeast("postgres.maxIdleConns", c.Postgres.MaxIdleConns, 0)
+		v.atLeast("postgres.connMaxLifetimeSeconds", c.Postgres.ConnMaxLifetimeSeconds, 0)
+		v.atLeast("postgres.connMaxIdleTimeSeconds", c.Postgres.ConnMaxIdleTimeSeconds, 0)
+
+		if c.Postgres.MaxOpenConns > 0 && c.Postgres.MaxIdleConns > c.Postgres.MaxOpenConns {
+			v.add("postgres.maxIdleConns", "must not exceed maxOpenConns")
+		}
+	},
+	RabbitMQ: func(v *validator, c schemas.Config) {
+		v.required("rabbitMQ.host", c.RabbitMQ.Host)
+		v.required("rabbitMQ.user", c.RabbitMQ.User)
+		v.between("rabbitMQ.port", c.RabbitMQ.Port, 1, 65535)
+	},
+	Provider: func(v *validator, c schemas.Config) {
+		v.url("provider.source.url", c.Provider.Source.Url)
+		v.url("provider.source.domain", c.Provider.Source.Domain)
+		v.atLeast("provider.providersCount", c.Provider.ProvidersCount, 1)
+		v.between("provider.scrapWorkersCount", c.Provider.ScrapWorkersCount, 1, 100)
+	},
+	Telegram: func(v *validator, c schemas.Config) {
+		v.required("telegram.token", c.Telegram.Token)
+	},
+	Showcase: func(v *validator, c schemas.Config) {
+		v.atLeast("showcase_server.defaultRateLimitPerMinute", c.Showcase.DefaultRateLimitPerMinute, 0)
+		v.atLeast("showcase_server.failedAuthPerMinute", c.Showcase.FailedAuthPerMinute, 0)
+	},
+}
+
+// HasRules tells whether section is validated.
+func HasRules(section string) bool {
+	_, ok := sectionRules[section]
+
+	return ok
+}
+
+// Validate checks the given sections of c, ignoring the ones without rules,
+// e.g. a service's own name. It returns a *ValidationError, classified as
+// invalid input, listing every problem.
+func Validate(c schemas.Config, sections ...string) error {
+	v := &validator{}
+
+	for _, section := range sections {
+		if rules, ok := sectionRules[section]; ok {
+			rules(v, c)
+		}
+	}
+
+	if len(v.problems) == 0 {
+		return nil
+	}
+
+	return errorHandling.NewInvalidInput(&ValidationError{Problems: v.problems})
+}
+
+type validator struct {
+	problems []Problem
+}
+
+func (v *validator) add(field, message string) {
+	v.problems = append(v.problems, Problem{Field: field, Message: message})
+}
+
+func (v *validator) required(field, value string) {
+	if strings.TrimSpace(value) == "" {
+		v.add(field, "is required")
+	}
+}
+
+func (v *validator) atLeast(field string, value, min int) {
+	if value < min {
+		v.add(field, fmt.Sprintf("must be at least %d, got %d", min, value))
+	}
+}
+
+func (v *validator) between(field string, value, min, max int) {
+	if value < min || value > max {
+		v.add(field, fmt.Sprintf("must be between %d and %d, got %d", min, max, value))
+	}
+}
+
+// url requires an absolute http or https URL.
+func (v *validator) url(field, value string) {
+	if value == "" {
+		v.add(field, "is required")
+		return
+	}
+
+	parsed, err := url.Parse(value)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		v.add(field, fmt.Sprintf("must be an absolute http(s) URL, got %q", value))
+	}
+}
@@ -0,0 +1,79 @@
+package dataloader
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// BatchFunc loads every key in one go. Keys missing from the returned map
+// load as the zero value of V.
+type BatchFunc[K comparable, V any] func(ctx context.Context, keys []K) (map[K]V, error)
+
+// Loader collects the keys asked with Load, from any goroutine, during wait
+// after the first of them and fetches them with a single BatchFunc call.
+// Results are cached for the lifetime of the Loader, so create one per
+// request.
+type Loader[K comparable, V any] struct {
+	ctx   context.Context
+	wait  time.Duration
+	batch BatchFunc[K, V]
+
+	mu      sync.Mutex
+	current *pendingBatch[K, V]
+	results map[K]*pendingBatch[K, V]
+}
+
+// pendingBatch is a set of keys fetched together. done is closed once
+// values and err are set.
+type pendingBatch[K comparable, V any] struct {
+	keys   []K
+	done   chan struct{}
+	values map[K]V
+	err    error
+}
+
+func New[K comparable, V any](ctx context.Context, wait time.Duration, batch BatchFunc[K, V]) *Loader[K, V] {
+	return &Loader[K, V]{
+		ctx:     ctx,
+		wait:    wait,
+		batch:   batch,
+		results: make(map[K]*pendingBatch[K, V]),
+	}
+}
+
+// Load queues key and returns a thunk blocking until its value is fetched.
+func (loader *Loader[K, V]) Load(key K) func() (V, error) {
+	loader.mu.Lock()
+
+	b, ok := loader.results[key]
+	if !ok {
+		if loader.current == nil {
+			loader.current = &pendingBatch[K, V]{done: make(chan struct{})}
+			time.AfterFunc(loader.wait, loader.dispatch)
+		}
+
+		b = loader.current
+		b.keys = append(b.keys, key)
+		loader.results[key] = b
+	}
+
+	loader.mu.Unlock()
+
+	return func() (V, error) {
+		<-b.done
+
+		return b.values[key], b.err
+	}
+}
+
+// dispatch fetches the keys queued since the batch started.
+func (loader *Loader[K, V]) dispatch() {
+	loader.mu.Lock()
+	b := loader.current
+	loader.current = nil
+	loader.mu.Unlock()
+
+	b.values, b.err = loader.batch(loader.ctx, b.keys)
+	close(b.done)
+}
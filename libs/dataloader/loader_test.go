@@ -0,0 +1,74 @@
+package dataloader
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadBatchesConcurrentKeys(t *testing.T) {
+	var batches [][]int
+
+	loader := New(context.Background(), 10*time.Millisecond, func(ctx context.Context, keys []int) (map[int]string, error) {
+		batches = append(batches, keys)
+
+		values := make(map[int]string, len(keys))
+		for _, key := range keys {
+			if key != 3 {
+				values[key] = string(rune('a' + key))
+			}
+		}
+
+		return values, nil
+	})
+
+	var wg sync.WaitGroup
+	values := make([]string, 4)
+
+	for i := range values {
+		wg.Add(1)
+
+		go func(key int) {
+			defer wg.Done()
+
+			value, err := loader.Load(key)()
+			require.NoError(t, err)
+			values[key] = value
+		}(i)
+	}
+
+	wg.Wait()
+
+	require.Equal(t, []string{"a", "b", "c", ""}, values)
+	require.Len(t, batches, 1)
+	require.ElementsMatch(t, []int{0, 1, 2, 3}, batches[0])
+
+	// Loaded keys are cached, new ones start another batch.
+	value, err := loader.Load(1)()
+	require.NoError(t, err)
+	require.Equal(t, "b", value)
+	require.Len(t, batches, 1)
+
+	value, err = loader.Load(4)()
+	require.NoError(t, err)
+	require.Equal(t, "e", value)
+	require.Equal(t, []int{4}, batches[1])
+}
+
+func TestLoadReturnsBatchErrors(t *testing.T) {
+	loader := New(context.Background(), time.Millisecond, func(ctx context.Context, keys []int) (map[int]string, error) {
+		return nil, errors.New("boom")
+	})
+
+	first, second := loader.Load(1), loader.Load(2)
+
+	_, err := first()
+	require.EqualError(t, err, "boom")
+
+	_, err = second()
+	require.EqualError(t, err, "boom")
+}
@@ -0,0 +1,198 @@
+package errorHandling
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"strconv"
+	"sync"
+	"syscall"
+)
+
+// Category tells how a failure should be handled.
+type Category int
+
+const (
+	// Unknown errors are handled like permanent ones.
+	Unknown Category = iota
+	// Transient failures may succeed when retried as is, e.g. a timeout.
+	Transient
+	// Permanent failures won't succeed when retried.
+	Permanent
+	// InvalidInput failures come from bad data, e.g. a malformed message.
+	InvalidInput
+	// DependencyDown failures come from an unreachable dependency, e.g. the
+	// broker or the database. They may succeed once it's back.
+	DependencyDown
+)
+
+func (category Category) String() string {
+	switch category {
+	case Transient:
+		return "transient"
+	case Permanent:
+		return "permanent"
+	case InvalidInput:
+		return "invalid_input"
+	case DependencyDown:
+		return "dependency_down"
+	}
+
+	return "unknown"
+}
+
+// Retryable tells whether retrying may help.
+func (category Category) Retryable() bool {
+	return category == Transient || category == DependencyDown
+}
+
+type classifiedError struct {
+	category Category
+	err      error
+}
+
+func (e *classifiedError) Error() string {
+	return e.err.Error()
+}
+
+func (e *classifiedError) Unwrap() error {
+	return e.err
+}
+
+// Wrap tags err with category. It returns nil when err is nil.
+func Wrap(category Category, err error) error {
+	if err == nil {
+		return nil
+	}
+
+	return &classifiedError{category: category, err: err}
+}
+
+// Wrapf tags err with category and adds context to its message, keeping it
+// reachable through errors.Is and errors.As. It returns nil when err is nil.
+func Wrapf(category Category, err error, format string, args ...interface{}) error {
+	if err == nil {
+		return nil
+	}
+
+	return &classifiedError{category: category, err: fmt.Errorf("%s, error: %w", fmt.Sprintf(format, args...), err)}
+}
+
+// NewTransient, NewPermanent, NewInvalidInput and NewDependencyDown tag err
+// with their category. They return nil when err is nil.
+func NewTransient(err error) error { return Wrap(Transient, err) }
+
+func NewPermanent(err error) error { return Wrap(Permanent, err) }
+
+func NewInvalidInput(err error) error { return Wrap(InvalidInput, err) }
+
+func NewDependencyDown(err error) error { return Wrap(DependencyDown, err) }
+
+// Classifier tells the category of errors coming from a library, e.g. the
+// database driver. It returns false when it doesn't know err.
+type Classifier func(err error) (Category, bool)
+
+var classifiersMu sync.RWMutex
+var classifiers []Classifier
+
+// RegisterClassifier adds a classifier used by CategoryOf for untagged
+// errors. Client libraries register theirs from init.
+func RegisterClassifier(classifier Classifier) {
+	classifiersMu.Lock()
+	defer classifiersMu.Unlock()
+
+	classifiers = append(classifiers, classifier)
+}
+
+// CategoryOf returns the category err was tagged with, or the one guessed by
+// the registered classifiers and the standard library errors.
+func CategoryOf(err error) Category {
+	if err == nil {
+		return Unknown
+	}
+
+	var classified *classifiedError
+	if errors.As(err, &classified) {
+		return classified.category
+	}
+
+	classifiersMu.RLock()
+	defer classifiersMu.RUnlock()
+
+	for _, classifier := range classifiers {
+		if category, ok := classifier(err); ok {
+			return category
+		}
+	}
+
+	return classifyStd(err)
+}
+
+// IsRetryable tells whether retrying the operation that returned err may help.
+func IsRetryable(err error) bool {
+	return CategoryOf(err).Retryable()
+}
+
+func classifyStd(err error) Category {
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+	var numErr *strconv.NumError
+	var netErr net.Error
+	var opErr *net.OpError
+
+	switch {
+	case errors.Is(err, context.Canceled):
+		return Permanent
+	case errors.Is(err, context.DeadlineExceeded), errors.Is(err, io.ErrUnexpectedEOF):
+		return Transient
+	case errors.Is(err, syscall.ECONNREFUSED), errors.Is(err, syscall.ECONNRESET), errors.Is(err, syscall.EPIPE):
+		return DependencyDown
+	case errors.As(err, &syntaxErr), errors.As(err, &typeErr), errors.As(err, &numErr):
+		return InvalidInput
+	case errors.As(err, &netErr) && netErr.Timeout():
+		return Transient
+	case errors.As(err, &opErr):
+		return DependencyDown
+	}
+
+	return Unknown
+}
+
+// Observer is called for every error reported through Report, e.g. to count
+// them in InfluxDB.
+type Observer func(category Category, msg string, err error)
+
+var observerMu sync.RWMutex
+var observer Observer
+
+func SetObserver(o Observer) {
+	observerMu.Lock()
+	defer observerMu.Unlock()
+
+	observer = o
+}
+
+// Report logs a failure that only affects the current unit of work, e.g. a
+// message or a request, and notifies the observer. Long running loops use it
+// instead of FailOnError and move on to the next message.
+func Report(err error, msg string) {
+	if err == nil {
+		return
+	}
+
+	category := CategoryOf(err)
+
+	log.Printf("%s - category: %s - error: %s", msg, category, err)
+
+	observerMu.RLock()
+	o := observer
+	observerMu.RUnlock()
+
+	if o != nil {
+		o(category, msg, err)
+	}
+}
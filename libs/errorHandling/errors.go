@@ -4,6 +4,8 @@ import (
 	"log"
 )
 
+// FailOnError exits the process. Keep it for startup, where the service
+// can't work without what failed; use Report once it's serving.
 func FailOnError(err error, msg string) {
 	if err != nil {
 		log.Fatalf("%s - error: %s", msg, err)
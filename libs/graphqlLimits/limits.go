@@ -0,0 +1,252 @@
+// Package graphqlLimits rejects GraphQL queries nesting or fanning out too
+// much before they are executed, whatever executes them.
+package graphqlLimits
+
+import (
+	"encoding/json"
+	"math"
+
+	"github.com/vektah/gqlparser/v2"
+	"github.com/vektah/gqlparser/v2/ast"
+	"github.com/vektah/gqlparser/v2/gqlerror"
+	"github.com/vektah/gqlparser/v2/parser"
+	"github.com/vektah/gqlparser/v2/validator"
+)
+
+// Limits bound what a single request may ask for. They are checked with
+// @skip and @include applied. Zero disables a limit.
+type Limits struct {
+	// MaxDepth is how deeply fields may be nested, top level fields being
+	// at depth 1. Fragments add no depth.
+	MaxDepth int
+	// MaxComplexity bounds the sum of the complexity of every selected
+	// field, see ComplexityFn.
+	MaxComplexity int
+	// DefaultListSize is how many items lists are assumed to hold when
+	// their field has no ComplexityFn.
+	DefaultListSize int
+}
+
+// ComplexityFn estimates the cost of a field from its arguments, defaults
+// applied, and the cost of its selections. Fields without one cost 1 plus
+// their selections, times DefaultListSize for lists.
+type ComplexityFn func(args map[string]interface{}, childComplexity int) int
+
+// Checker checks queries against a schema and its Limits.
+type Checker struct {
+	schema     *ast.Schema
+	limits     Limits
+	complexity map[string]ComplexityFn
+}
+
+// NewChecker parses sdl. complexity is keyed by type and field name, e.g.
+// "Query.providers".
+func NewChecker(sdl string, limits Limits, complexity map[string]ComplexityFn) (*Checker, error) {
+	schema, err := gqlparser.LoadSchema(&ast.Source{Name: "schema.graphql", Input: sdl})
+	if err != nil {
+		return nil, err
+	}
+
+	return &Checker{schema: schema, limits: limits, complexity: complexity}, nil
+}
+
+// Check returns why the operation of query can't run, nil when it can. A
+// query that doesn't parse or validate can't be measured and is rejected
+// with the validation errors.
+func (checker *Checker) Check(query, operationName string, variables map[string]interface{}) gqlerror.List {
+	doc, err := parser.ParseQuery(&ast.Source{Input: query})
+	if err != nil {
+		return gqlerror.List{gqlerror.WrapIfUnwrapped(err)}
+	}
+
+	if errs := validator.ValidateWithRules(checker.schema, doc, nil); len(errs) > 0 {
+		return errs
+	}
+
+	op := doc.Operations.ForName(operationName)
+	if op == nil {
+		if operationName == "" {
+			return gqlerror.List{gqlerror.Errorf("Must provide operation name if query contains multiple operations.")}
+		}
+
+		return gqlerror.List{gqlerror.Errorf("Unknown operation named %q.", operationName)}
+	}
+
+	vars, err := validator.VariableValues(checker.schema, op, variables)
+	if err != nil {
+		return gqlerror.List{gqlerror.WrapIfUnwrapped(err)}
+	}
+
+	return checker.checkLimits(op, vars)
+}
+
+func (checker *Checker) checkLimits(op *ast.OperationDefinition, vars map[string]interface{}) gqlerror.List {
+	limits := checker.limits
+	if limits.MaxDepth <= 0 && limits.MaxComplexity <= 0 {
+		return nil
+	}
+
+	// Complexities are capped right above the limit, anything higher is
+	// rejected anyway, so they can't overflow.
+	ceiling := math.MaxInt32
+	if limits.MaxComplexity > 0 && limits.MaxComplexity < ceiling {
+		ceiling = limits.MaxComplexity + 1
+	}
+
+	m := measurement{checker: checker, vars: vars, ceiling: ceiling}
+	depth, complexity := m.measure([]ast.SelectionSet{op.SelectionSet})
+
+	var errs gqlerror.List
+
+	if limits.MaxDepth > 0 && depth > limits.MaxDepth {
+		errs = append(errs, gqlerror.ErrorPosf(op.Position,
+			"Query is nested %d levels deep, the maximum allowed is %d.", depth, limits.MaxDepth))
+	}
+
+	if limits.MaxComplexity > 0 && complexity > limits.MaxComplexity {
+		errs = append(errs, gqlerror.ErrorPosf(op.Position,
+			"Query is more complex than the maximum allowed of %d.", limits.MaxComplexity))
+	}
+
+	return errs
+}
+
+type measurement struct {
+	checker *Checker
+	vars    map[string]interface{}
+	ceiling int
+}
+
+// measure returns the depth and complexity of selectionSets, merging the
+// fields sharing a response name like execution does.
+func (m measurement) measure(selectionSets []ast.SelectionSet) (depth, complexity int) {
+	var keys []string
+	grouped := make(map[string][]*ast.Field)
+
+	for _, selectionSet := range selectionSets {
+		keys = m.collectFields(selectionSet, keys, grouped)
+	}
+
+	for _, key := range keys {
+		fields := grouped[key]
+
+		var childSets []ast.SelectionSet
+		for _, field := range fields {
+			if len(field.SelectionSet) > 0 {
+				childSets = append(childSets, field.SelectionSet)
+			}
+		}
+
+		childDepth, childComplexity := 0, 0
+		if len(childSets) > 0 {
+			childDepth, childComplexity = m.measure(childSets)
+		}
+
+		if 1+childDepth > depth {
+			depth = 1 + childDepth
+		}
+
+		complexity = capComplexity(complexity+capComplexity(m.fieldComplexity(fields[0], childComplexity), m.ceiling), m.ceiling)
+	}
+
+	return depth, complexity
+}
+
+// collectFields appends the response names of selectionSet, fragments
+// expanded, to keys and their fields to grouped.
+func (m measurement) collectFields(selectionSet ast.SelectionSet, keys []string, grouped map[string][]*ast.Field) []string {
+	for _, selection := range selectionSet {
+		switch selection := selection.(type) {
+		case *ast.Field:
+			if m.skipped(selection.Directives) {
+				continue
+			}
+
+			if _, ok := grouped[selection.Alias]; !ok {
+				keys = append(keys, selection.Alias)
+			}
+
+			grouped[selection.Alias] = append(grouped[selection.Alias], selection)
+		case *ast.InlineFragment:
+			if !m.skipped(selection.Directives) {
+				keys = m.collectFields(selection.SelectionSet, keys, grouped)
+			}
+		case *ast.FragmentSpread:
+			if !m.skipped(selection.Directives) && selection.Definition != nil {
+				keys = m.collectFields(selection.Definition.SelectionSet, keys, grouped)
+			}
+		}
+	}
+
+	return keys
+}
+
+func (m measurement) skipped(directives ast.DirectiveList) bool {
+	if skip := directives.ForName("skip"); skip != nil {
+		if skipped, _ := skip.ArgumentMap(m.vars)["if"].(bool); skipped {
+			return true
+		}
+	}
+
+	if include := directives.ForName("include"); include != nil {
+		if included, _ := include.ArgumentMap(m.vars)["if"].(bool); !included {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (m measurement) fieldComplexity(field *ast.Field, childComplexity int) int {
+	if field.ObjectDefinition != nil {
+		if complexity := m.checker.complexity[field.ObjectDefinition.Name+"."+field.Name]; complexity != nil {
+			return complexity(field.ArgumentMap(m.vars), childComplexity)
+		}
+	}
+
+	if field.Definition != nil && field.Definition.Type.Elem != nil {
+		return 1 + m.checker.limits.DefaultListSize*childComplexity
+	}
+
+	return 1 + childComplexity
+}
+
+// capComplexity keeps c between 1, so a negative estimate can't lower the
+// cost of the other fields, and ceiling.
+func capComplexity(c, ceiling int) int {
+	if c < 1 {
+		return 1
+	}
+
+	if c > ceiling {
+		return ceiling
+	}
+
+	return c
+}
+
+// IntArg reads an Int argument from the args of a ComplexityFn, whether it
+// was given literally or as a variable.
+func IntArg(args map[string]interface{}, name string) (int, bool) {
+	switch n := args[name].(type) {
+	case int:
+		return n, true
+	case int32:
+		return int(n), true
+	case int64:
+		return clampInt(float64(n)), true
+	case float64:
+		return clampInt(n), true
+	case json.Number:
+		f, err := n.Float64()
+
+		return clampInt(f), err == nil
+	}
+
+	return 0, false
+}
+
+// clampInt keeps huge sizes from wrapping around once converted to int.
+func clampInt(n float64) int {
+	return int(math.Max(math.MinInt32, math.Min(math.MaxInt32, n)))
+}
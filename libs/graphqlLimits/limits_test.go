@@ -0,0 +1,142 @@
+package graphqlLimits
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/vektah/gqlparser/v2/gqlerror"
+)
+
+// nodeSDL has a Node type nesting itself, once through a plain field and as
+// lists through siblings and children, children sized by its first argument.
+const nodeSDL = `
+type Query {
+  node: Node
+}
+
+type Node {
+  id: ID!
+  parent: Node
+  siblings: [Node!]!
+  children(first: Int = 2): [Node!]!
+}
+`
+
+func newNodeChecker(t *testing.T, limits Limits) *Checker {
+	checker, err := NewChecker(nodeSDL, limits, map[string]ComplexityFn{
+		"Node.children": func(args map[string]interface{}, childComplexity int) int {
+			first, _ := IntArg(args, "first")
+
+			return 1 + first*childComplexity
+		},
+	})
+	require.NoError(t, err)
+
+	return checker
+}
+
+func TestDepthLimit(t *testing.T) {
+	checker := newNodeChecker(t, Limits{MaxDepth: 3})
+
+	tests := map[string]bool{
+		`{ node { parent { id } } }`:            true,
+		`{ node { parent { parent { id } } } }`: false,
+		`{ node { ... on Node { ...P } } } fragment P on Node { parent { __typename } }`:    true,
+		`{ node { ...P } } fragment P on Node { parent { parent { id } } }`:                 false,
+		`{ node { parent { parent @skip(if: true) { id } } } }`:                             true,
+		`query ($deep: Boolean!) { node { parent { parent @include(if: $deep) { id } } } }`: true,
+	}
+
+	for query, allowed := range tests {
+		errs := checker.Check(query, "", map[string]interface{}{"deep": false})
+
+		if allowed {
+			require.Empty(t, errs, query)
+			continue
+		}
+
+		require.Len(t, errs, 1, query)
+		require.Equal(t, "Query is nested 4 levels deep, the maximum allowed is 3.", errs[0].Message, query)
+	}
+}
+
+func TestComplexityLimit(t *testing.T) {
+	checker := newNodeChecker(t, Limits{MaxComplexity: 20, DefaultListSize: 5})
+
+	tests := map[string]bool{
+		// node 1 + id 1
+		`{ node { id } }`: true,
+		// node 1 + siblings (1 + 5 * id 1)
+		`{ node { siblings { id } } }`: true,
+		// node 1 + siblings (1 + 5 * (id 1 + parent (1 + id 1 + parent (1 + id 1))))
+		`{ node { siblings { id parent { id parent { id } } } } }`: false,
+		// node 1 + children (1 + 9 * id 1)
+		`{ node { children(first: 9) { id } } }`: true,
+		// node 1 + children (1 + 2 * (1 + 2 * (1 + 2 * id 1)))
+		`{ node { children { children { children { id } } } } }`: true,
+		// node 1 + children (1 + 2 * (1 + 2 * (1 + 2 * (1 + 2 * id 1))))
+		`{ node { children { children { children { children { id } } } } } }`: false,
+		// Huge sizes can't overflow the estimate.
+		`{ node { children(first: 2147483647) { children(first: 2147483647) { children(first: 2147483647) { id } } } } }`: false,
+		// A negative size doesn't lower the cost of the other fields.
+		`{ node { children(first: -1000) { id } siblings { id siblings { id } } } }`: false,
+		// Fields sharing a response name are counted once.
+		`{ node { siblings { id } siblings { id } } }`: true,
+	}
+
+	for query, allowed := range tests {
+		errs := checker.Check(query, "", nil)
+
+		if allowed {
+			require.Empty(t, errs, query)
+			continue
+		}
+
+		require.Len(t, errs, 1, query)
+		require.Equal(t, "Query is more complex than the maximum allowed of 20.", errs[0].Message, query)
+	}
+}
+
+func TestComplexityOfVariables(t *testing.T) {
+	checker := newNodeChecker(t, Limits{MaxComplexity: 20})
+
+	query := `query ($first: Int) { node { children(first: $first) { id } } }`
+
+	require.Empty(t, checker.Check(query, "", map[string]interface{}{"first": 9}))
+	require.NotEmpty(t, checker.Check(query, "", map[string]interface{}{"first": 19}))
+}
+
+func TestLimitsErrorsPointAtTheOperation(t *testing.T) {
+	checker := newNodeChecker(t, Limits{MaxDepth: 2, MaxComplexity: 3})
+
+	errs := checker.Check(`{ node { id parent { id } } }`, "", nil)
+
+	require.Len(t, errs, 2)
+	require.Equal(t, []gqlerror.Location{{Line: 1, Column: 1}}, errs[0].Locations)
+}
+
+func TestInvalidQueriesAreRejected(t *testing.T) {
+	checker := newNodeChecker(t, Limits{})
+
+	tests := []struct {
+		query, operationName string
+	}{
+		{query: `{ node { id `},
+		{query: `{ node { unknown } }`},
+		{query: `query A { node { id } } query B { node { id } }`},
+		{query: `query A { node { id } }`, operationName: "B"},
+		{query: `query ($first: Int!) { node { children(first: $first) { id } } }`},
+	}
+
+	for _, test := range tests {
+		require.NotEmpty(t, checker.Check(test.query, test.operationName, nil), test.query)
+	}
+}
+
+func TestNoLimits(t *testing.T) {
+	checker := newNodeChecker(t, Limits{})
+
+	errs := checker.Check(`{ node { parent { parent { parent { parent { children(first: 100000) { id } } } } } } }`, "", nil)
+
+	require.Empty(t, errs)
+}
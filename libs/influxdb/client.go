@@ -1,8 +1,13 @@
 package influxdb
 
 import (
+	"context"
+	"errors"
 	"log"
+	"sync/atomic"
+	"time"
 
+	"sarasa/libs/circuitBreaker"
 	"sarasa/schemas"
 
 	influxDbClient "github.com/influxdata/influxdb1-client/v2"
@@ -10,8 +15,14 @@ import (
 
 type Client struct {
 	httpClient influxDbClient.Client
+	breaker    *circuitBreaker.Breaker
+	writer     *writer
 }
 
+// BreakerSettings configures the circuit breaker created by Init, which
+// drops points right away while InfluxDB is unreachable.
+var BreakerSettings = circuitBreaker.Settings{OpenTimeout: time.Minute}
+
 var enabled bool
 var database string
 
@@ -26,6 +37,8 @@ func (influxDB *Client) Init(ic schemas.InfluxConfig) error {
 
 	log.Println("Initializing InfluxDB client...")
 
+	influxDB.breaker = circuitBreaker.New("influxDB", BreakerSettings)
+
 	var err error
 
 	influxDB.httpClient, err = influxDbClient.NewHTTPClient(influxDbClient.HTTPConfig{
@@ -38,10 +51,24 @@ func (influxDB *Client) Init(ic schemas.InfluxConfig) error {
 
 	database = ic.Database
 
+	influxDB.writer = newWriter(ic)
+	go influxDB.writer.run(influxDB.write)
+
 	return nil
 }
 
-func (influxDb Client) Close() error {
+// Breaker returns the client's circuit breaker, nil while disabled.
+func (influxDB *Client) Breaker() *circuitBreaker.Breaker {
+	return influxDB.breaker
+}
+
+// Close writes the buffered points, waiting up to FlushTimeout, and closes
+// the client.
+func (influxDb *Client) Close() error {
+	if influxDb.writer != nil {
+		influxDb.writer.close()
+	}
+
 	if influxDb.httpClient == nil {
 		return nil
 	}
@@ -53,29 +80,69 @@ func (rabbitmq Client) String() string {
 	return "InfluxDB"
 }
 
+// Send buffers a point, written with others by the background writer. It
+// doesn't block: points are dropped, and counted, while the buffer is full.
+// It only fails for invalid points and once the client is closed.
 func (influxDB *Client) Send(pointName string, tags map[string]string, fields map[string]interface{}) error {
 	if !enabled {
 		return nil
 	}
 
-	log.Println("Sending to InfluxDB...")
+	if influxDB.writer == nil {
+		return errors.New("not initialized")
+	}
 
 	p, err := influxDbClient.NewPoint(pointName, tags, fields)
 	if err != nil {
 		return err
 	}
 
+	return influxDB.writer.enqueue(p)
+}
+
+// write writes a batch of points, retried by WritePolicy through the
+// circuit breaker.
+func (influxDB *Client) write(ctx context.Context, points []*influxDbClient.Point) error {
 	bp, err := influxDbClient.NewBatchPoints(influxDbClient.BatchPointsConfig{Database: database})
 	if err != nil {
 		return err
 	}
 
-	bp.AddPoint(p)
+	bp.AddPoints(points)
 
-	err = influxDB.httpClient.Write(bp)
-	if err != nil {
-		return err
+	return WritePolicy.Do(ctx, func(ctx context.Context) error {
+		return influxDB.breaker.Do(func() error {
+			return influxDB.httpClient.Write(bp)
+		})
+	})
+}
+
+// Stats returns the number of points written and dropped since Init.
+func (influxDB *Client) Stats() (written, dropped uint64) {
+	if influxDB.writer == nil {
+		return 0, 0
 	}
 
-	return nil
+	return atomic.LoadUint64(&influxDB.writer.written), atomic.LoadUint64(&influxDB.writer.dropped)
+}
+
+// Ping checks InfluxDB answers. It always succeeds when the client is
+// disabled.
+func (influxDB *Client) Ping(ctx context.Context) error {
+	if !enabled {
+		return nil
+	}
+
+	if influxDB.httpClient == nil {
+		return errors.New("not initialized")
+	}
+
+	timeout := time.Duration(0)
+	if deadline, ok := ctx.Deadline(); ok {
+		timeout = time.Until(deadline)
+	}
+
+	_, _, err := influxDB.httpClient.Ping(timeout)
+
+	return err
 }
@@ -0,0 +1,39 @@
+package influxdb
+
+import (
+	"time"
+
+	"sarasa/libs/circuitBreaker"
+	"sarasa/libs/errorHandling"
+)
+
+// ObserveService sends the errors reported by service, per category, and
+// the state changes of its circuit breakers to InfluxDB, tagged with the
+// service name.
+func (influxDB *Client) ObserveService(service string) {
+	errorHandling.SetObserver(func(category errorHandling.Category, msg string, err error) {
+		influxDB.observe("errors",
+			map[string]string{"service": service, "category": category.String()},
+			map[string]interface{}{"message": msg, "count": 1})
+	})
+
+	circuitBreaker.SetObserver(func(name string, from, to circuitBreaker.State) {
+		influxDB.observe("circuit_breaker",
+			map[string]string{"service": service, "breaker": name, "state": to.String()},
+			map[string]interface{}{"from": from.String(), "open": to == circuitBreaker.Open})
+	})
+}
+
+// QueryObserver returns a postgres.QueryObserver sending the latency of the
+// queries of service to InfluxDB.
+func (influxDB *Client) QueryObserver(service string) func(name string, elapsed time.Duration, err error) {
+	return func(name string, elapsed time.Duration, err error) {
+		influxDB.observe("postgres_query",
+			map[string]string{"service": service, "query": name},
+			map[string]interface{}{"elapsed": elapsed.Milliseconds(), "success": err == nil})
+	}
+}
+
+func (influxDB *Client) observe(pointName string, tags map[string]string, fields map[string]interface{}) {
+	errorHandling.LogOnError(influxDB.Send(pointName, tags, fields), "Could not write to InfluxDB")
+}
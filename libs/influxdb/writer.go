@@ -0,0 +1,175 @@
+package influxdb
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"sarasa/libs/retryHandling"
+	"sarasa/schemas"
+
+	influxDbClient "github.com/influxdata/influxdb1-client/v2"
+)
+
+// Defaults of the writer settings left at zero in schemas.InfluxConfig.
+const (
+	DefaultBatchSize     = 500
+	DefaultFlushInterval = time.Second
+	DefaultBufferSize    = 10000
+)
+
+// FlushTimeout bounds the last flush made by Close.
+var FlushTimeout = 5 * time.Second
+
+// WritePolicy retries the write of a batch while InfluxDB is briefly
+// unavailable. Points keep being buffered meanwhile.
+var WritePolicy = retryHandling.Policy{
+	MaxAttempts:  3,
+	MaxElapsed:   10 * time.Second,
+	InitialDelay: 200 * time.Millisecond,
+	MaxDelay:     2 * time.Second,
+	Jitter:       0.2,
+	OnRetry:      retryHandling.LogRetry,
+}
+
+// ErrClosed is returned by Send once the client is closed.
+var ErrClosed = errors.New("influxdb: client closed")
+
+// writer buffers points and writes them in batches from its own goroutine.
+type writer struct {
+	// Accessed atomically, first to stay 64-bit aligned.
+	dropped uint64
+	written uint64
+
+	batchSize     int
+	flushInterval time.Duration
+
+	// mu guards closed, so Send never writes to a closed points channel.
+	mu     sync.RWMutex
+	closed bool
+	points chan *influxDbClient.Point
+	done   chan struct{}
+
+	reportedDropped uint64
+}
+
+func newWriter(ic schemas.InfluxConfig) *writer {
+	w := &writer{
+		batchSize:     ic.BatchSize,
+		flushInterval: time.Duration(ic.FlushIntervalMs) * time.Millisecond,
+		done:          make(chan struct{}),
+	}
+
+	if w.batchSize <= 0 {
+		w.batchSize = DefaultBatchSize
+	}
+
+	if w.flushInterval <= 0 {
+		w.flushInterval = DefaultFlushInterval
+	}
+
+	bufferSize := ic.BufferSize
+	if bufferSize <= 0 {
+		bufferSize = DefaultBufferSize
+	}
+
+	w.points = make(chan *influxDbClient.Point, bufferSize)
+
+	return w
+}
+
+// enqueue buffers p without blocking, dropping it when the buffer is full.
+func (w *writer) enqueue(p *influxDbClient.Point) error {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+
+	if w.closed {
+		return ErrClosed
+	}
+
+	select {
+	case w.points <- p:
+	default:
+		atomic.AddUint64(&w.dropped, 1)
+	}
+
+	return nil
+}
+
+// run writes the buffered points with write, whenever batchSize of them are
+// buffered or flushInterval passed, until the points channel is closed. The
+// remaining points are then written before done is closed.
+func (w *writer) run(write func(ctx context.Context, points []*influxDbClient.Point) error) {
+	defer close(w.done)
+
+	ticker := time.NewTicker(w.flushInterval)
+	defer ticker.Stop()
+
+	batch := make([]*influxDbClient.Point, 0, w.batchSize)
+
+	flush := func(ctx context.Context) {
+		if len(batch) > 0 {
+			if err := write(ctx, batch); err != nil {
+				atomic.AddUint64(&w.dropped, uint64(len(batch)))
+				log.Printf("Dropped %d InfluxDB points - error: %s", len(batch), err)
+			} else {
+				atomic.AddUint64(&w.written, uint64(len(batch)))
+			}
+
+			batch = make([]*influxDbClient.Point, 0, w.batchSize)
+		}
+
+		w.reportDropped()
+	}
+
+	for {
+		select {
+		case p, ok := <-w.points:
+			if !ok {
+				ctx, cancel := context.WithTimeout(context.Background(), FlushTimeout)
+				flush(ctx)
+				cancel()
+
+				return
+			}
+
+			batch = append(batch, p)
+			if len(batch) >= w.batchSize {
+				flush(context.Background())
+			}
+		case <-ticker.C:
+			flush(context.Background())
+		}
+	}
+}
+
+// reportDropped logs the points dropped since the last report, once per
+// flush rather than once per point.
+func (w *writer) reportDropped() {
+	dropped := atomic.LoadUint64(&w.dropped)
+	if dropped == w.reportedDropped {
+		return
+	}
+
+	log.Printf("%d InfluxDB points dropped since start (%d since last flush)", dropped, dropped-w.reportedDropped)
+	w.reportedDropped = dropped
+}
+
+// close stops accepting points and waits for the buffered ones to be
+// written.
+func (w *writer) close() {
+	w.mu.Lock()
+	if w.closed {
+		w.mu.Unlock()
+		return
+	}
+
+	w.closed = true
+	close(w.points)
+	w.mu.Unlock()
+
+	<-w.done
+}
@@ -0,0 +1,134 @@
+package influxdb
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"sarasa/schemas"
+
+	influxDbClient "github.com/influxdata/influxdb1-client/v2"
+	"github.com/stretchr/testify/require"
+)
+
+// recorder is a write func keeping the size of every batch written.
+type recorder struct {
+	mu      sync.Mutex
+	batches []int
+	err     error
+}
+
+func (r *recorder) write(ctx context.Context, points []*influxDbClient.Point) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.batches = append(r.batches, len(points))
+
+	return r.err
+}
+
+func (r *recorder) sizes() []int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return append([]int(nil), r.batches...)
+}
+
+func newPoint(t *testing.T) *influxDbClient.Point {
+	p, err := influxDbClient.NewPoint("test", nil, map[string]interface{}{"value": 1}, time.Now())
+	require.NoError(t, err)
+
+	return p
+}
+
+func TestWriterBatches(t *testing.T) {
+	tests := map[string]struct {
+		points  int
+		err     error
+		batches []int
+		written uint64
+		dropped uint64
+	}{
+		"nothing to write": {
+			points: 0,
+		},
+		"full batches and the rest on close": {
+			points:  7,
+			batches: []int{3, 3, 1},
+			written: 7,
+		},
+		"failed writes drop their batches": {
+			points:  4,
+			err:     errors.New("influxdb down"),
+			batches: []int{3, 1},
+			dropped: 4,
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			w := newWriter(schemas.InfluxConfig{BatchSize: 3, FlushIntervalMs: 60000})
+			r := &recorder{err: tt.err}
+
+			go w.run(r.write)
+
+			for i := 0; i < tt.points; i++ {
+				require.NoError(t, w.enqueue(newPoint(t)))
+			}
+
+			w.close()
+
+			require.Equal(t, tt.batches, r.sizes())
+			require.Equal(t, tt.written, atomic.LoadUint64(&w.written))
+			require.Equal(t, tt.dropped, atomic.LoadUint64(&w.dropped))
+		})
+	}
+}
+
+func TestWriterFlushesOnInterval(t *testing.T) {
+	w := newWriter(schemas.InfluxConfig{BatchSize: 100, FlushIntervalMs: 10})
+	r := &recorder{}
+
+	go w.run(r.write)
+	defer w.close()
+
+	require.NoError(t, w.enqueue(newPoint(t)))
+	require.NoError(t, w.enqueue(newPoint(t)))
+
+	require.Eventually(t, func() bool {
+		return atomic.LoadUint64(&w.written) == 2
+	}, time.Second, 5*time.Millisecond)
+	require.Equal(t, []int{2}, r.sizes())
+}
+
+func TestWriterDropsPointsWhenTheBufferIsFull(t *testing.T) {
+	w := newWriter(schemas.InfluxConfig{BatchSize: 10, BufferSize: 2})
+
+	// Nothing drains the buffer until run starts.
+	for i := 0; i < 5; i++ {
+		require.NoError(t, w.enqueue(newPoint(t)))
+	}
+
+	require.Equal(t, uint64(3), atomic.LoadUint64(&w.dropped))
+
+	r := &recorder{}
+	go w.run(r.write)
+	w.close()
+
+	require.Equal(t, []int{2}, r.sizes())
+	require.Equal(t, uint64(2), atomic.LoadUint64(&w.written))
+}
+
+func TestWriterRejectsPointsOnceClosed(t *testing.T) {
+	w := newWriter(schemas.InfluxConfig{})
+	go w.run((&recorder{}).write)
+
+	w.close()
+	w.close()
+
+	require.ErrorIs(t, w.enqueue(newPoint(t)), ErrClosed)
+	require.Zero(t, atomic.LoadUint64(&w.dropped))
+}
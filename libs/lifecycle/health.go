@@ -0,0 +1,274 @@
+package lifecycle
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"sarasa/schemas"
+)
+
+const (
+	DefaultHealthPort = 8081
+
+	checkTimeout = 2 * time.Second
+
+	statusOK   = "ok"
+	statusFail = "fail"
+)
+
+// Check tells whether a dependency is usable. detail, when not empty, is
+// reported as is, e.g. the time of the last successful run.
+type Check func(ctx context.Context) (detail string, err error)
+
+// ErrorCheck adapts a ping style function to a Check.
+func ErrorCheck(ping func(ctx context.Context) error) Check {
+	return func(ctx context.Context) (string, error) {
+		return "", ping(ctx)
+	}
+}
+
+// Health serves /healthz (liveness: the process should be restarted when it
+// fails) and /readyz (readiness: dependencies are usable). The zero value is
+// ready to use; checks can be added while it serves.
+type Health struct {
+	mu        sync.Mutex
+	liveness  []namedCheck
+	readiness []namedCheck
+	server    *http.Server
+}
+
+type namedCheck struct {
+	name     string
+	check    Check
+	optional bool
+}
+
+// CheckStatus is the outcome of a single check.
+type CheckStatus struct {
+	Status    string `json:"status"`
+	Optional  bool   `json:"optional,omitempty"`
+	Detail    string `json:"detail,omitempty"`
+	Error     string `json:"error,omitempty"`
+	ElapsedMs int64  `json:"elapsedMs"`
+}
+
+// Report is the body of /healthz and /readyz. Status is "fail" when any
+// non optional check failed.
+type Report struct {
+	Status string                 `json:"status"`
+	Checks map[string]CheckStatus `json:"checks"`
+}
+
+func (health *Health) AddLivenessCheck(name string, check Check) {
+	health.mu.Lock()
+	defer health.mu.Unlock()
+
+	health.liveness = append(health.liveness, namedCheck{name: name, check: check})
+}
+
+func (health *Health) AddReadinessCheck(name string, check Check) {
+	health.mu.Lock()
+	defer health.mu.Unlock()
+
+	health.readiness = append(health.readiness, namedCheck{name: name, check: check})
+}
+
+// AddOptionalCheck adds a readiness check that is reported but never makes
+// the service unready, for dependencies the service works without.
+func (health *Health) AddOptionalCheck(name string, check Check) {
+	health.mu.Lock()
+	defer health.mu.Unlock()
+
+	health.readiness = append(health.readiness, namedCheck{name: name, check: check, optional: true})
+}
+
+func (health *Health) Live(ctx context.Context) Report {
+	health.mu.Lock()
+	checks := append([]namedCheck(nil), health.liveness...)
+	health.mu.Unlock()
+
+	return runChecks(ctx, checks)
+}
+
+func (health *Health) Ready(ctx context.Context) Report {
+	health.mu.Lock()
+	checks := append([]namedCheck(nil), health.readiness...)
+	health.mu.Unlock()
+
+	return runChecks(ctx, checks)
+}
+
+// runChecks runs every check concurrently, each bounded by checkTimeout.
+func runChecks(ctx context.Context, checks []namedCheck) Report {
+	report := Report{Status: statusOK, Checks: make(map[string]CheckStatus, len(checks))}
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+
+	for _, c := range checks {
+		wg.Add(1)
+
+		go func(c namedCheck) {
+			defer wg.Done()
+
+			status := runCheck(ctx, c)
+
+			mu.Lock()
+			defer mu.Unlock()
+
+			report.Checks[c.name] = status
+			if status.Status == statusFail && !c.optional {
+				report.Status = statusFail
+			}
+		}(c)
+	}
+
+	wg.Wait()
+
+	return report
+}
+
+func runCheck(ctx context.Context, c namedCheck) (status CheckStatus) {
+	ctx, cancel := context.WithTimeout(ctx, checkTimeout)
+	defer cancel()
+
+	start := time.Now()
+	status = CheckStatus{Status: statusOK, Optional: c.optional}
+
+	defer func() {
+		if r := recover(); r != nil {
+			status.Status = statusFail
+			status.Error = fmt.Sprintf("check panicked: %v", r)
+		}
+
+		status.ElapsedMs = time.Since(start).Milliseconds()
+	}()
+
+	detail, err := c.check(ctx)
+
+	status.Detail = detail
+	if err != nil {
+		status.Status = statusFail
+		status.Error = err.Error()
+	}
+
+	return status
+}
+
+// Handler serves /healthz and /readyz, answering 503 when the report fails.
+func (health *Health) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		writeReport(w, health.Live(r.Context()))
+	})
+	mux.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
+		writeReport(w, health.Ready(r.Context()))
+	})
+
+	return mux
+}
+
+func writeReport(w http.ResponseWriter, report Report) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+
+	if report.Status != statusOK {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+
+	if err := json.NewEncoder(w).Encode(report); err != nil {
+		log.Printf("Could not write health report - error: %s", err)
+	}
+}
+
+// Serve listens on the configured port, DefaultHealthPort when unset, until
+// Close is called.
+func (health *Health) Serve(hc schemas.HealthConfig) error {
+	port := hc.Port
+	if port == 0 {
+		port = DefaultHealthPort
+	}
+
+	server := &http.Server{
+		Addr:              fmt.Sprintf(":%d", port),
+		Handler:           health.Handler(),
+		ReadHeaderTimeout: 5 * time.Second,
+	}
+
+	health.mu.Lock()
+	health.server = server
+	health.mu.Unlock()
+
+	log.Printf("Serving health checks on %s", server.Addr)
+
+	err := server.ListenAndServe()
+	if errors.Is(err, http.ErrServerClosed) {
+		return nil
+	}
+
+	return err
+}
+
+func (health *Health) Close() error {
+	health.mu.Lock()
+	server := health.server
+	health.mu.Unlock()
+
+	if server == nil {
+		return nil
+	}
+
+	return server.Close()
+}
+
+func (health *Health) String() string {
+	return "Health server"
+}
+
+// Heartbeat records the last time something succeeded, e.g. a run.
+type Heartbeat struct {
+	mu   sync.Mutex
+	last time.Time
+}
+
+func (heartbeat *Heartbeat) Beat() {
+	heartbeat.mu.Lock()
+	defer heartbeat.mu.Unlock()
+
+	heartbeat.last = time.Now()
+}
+
+func (heartbeat *Heartbeat) Last() time.Time {
+	heartbeat.mu.Lock()
+	defer heartbeat.mu.Unlock()
+
+	return heartbeat.last
+}
+
+// Check reports the last beat. With a positive maxAge it also fails when
+// there was no beat within maxAge.
+func (heartbeat *Heartbeat) Check(maxAge time.Duration) Check {
+	return func(ctx context.Context) (string, error) {
+		last := heartbeat.Last()
+		if last.IsZero() {
+			if maxAge > 0 {
+				return "never", errors.New("no successful run yet")
+			}
+
+			return "never", nil
+		}
+
+		detail := last.UTC().Format(time.RFC3339)
+		if maxAge > 0 && time.Since(last) > maxAge {
+			return detail, fmt.Errorf("last successful run is older than %s", maxAge)
+		}
+
+		return detail, nil
+	}
+}
@@ -0,0 +1,200 @@
+package lifecycle
+
+import (
+	"context"
+	"errors"
+	"log"
+	"os"
+	"os/signal"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"sarasa/libs/errorHandling"
+	"sarasa/schemas"
+)
+
+// DefaultDrainTimeout leaves room for closing dependencies before docker
+// kills the container, 10 seconds after SIGTERM by default.
+const DefaultDrainTimeout = 8 * time.Second
+
+// Manager shuts a service down on SIGINT or SIGTERM:
+//  1. the root context is cancelled,
+//  2. stoppers run in registration order, so consumers stop taking work,
+//  3. in-flight work drains until the drain deadline,
+//  4. services are closed in reverse order of registration.
+type Manager struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	work       context.Context
+	cancelWork context.CancelFunc
+
+	drainTimeout time.Duration
+
+	mu       sync.Mutex
+	stoppers []namedStopper
+	services []schemas.Service
+
+	inFlight     sync.WaitGroup
+	inFlightSize int64
+
+	shutdown sync.Once
+	done     chan struct{}
+}
+
+type namedStopper struct {
+	name string
+	stop func(ctx context.Context) error
+}
+
+func NewManager(drainTimeout time.Duration) *Manager {
+	ctx, cancel := context.WithCancel(context.Background())
+	work, cancelWork := context.WithCancel(context.Background())
+
+	return &Manager{
+		ctx:          ctx,
+		cancel:       cancel,
+		work:         work,
+		cancelWork:   cancelWork,
+		drainTimeout: drainTimeout,
+		done:         make(chan struct{}),
+	}
+}
+
+// Context is cancelled as soon as the shutdown starts. Use it for loops and
+// waits that should stop taking new work.
+func (manager *Manager) Context() context.Context {
+	return manager.ctx
+}
+
+// WorkContext is cancelled when the drain deadline passes. Use it for
+// in-flight work, e.g. a transaction, so it's aborted cleanly instead of
+// having its dependencies closed underneath it.
+func (manager *Manager) WorkContext() context.Context {
+	return manager.work
+}
+
+// AddService registers a dependency to close on shutdown. Register them as
+// they are initialized, they are closed in reverse order.
+func (manager *Manager) AddService(service schemas.Service) {
+	manager.mu.Lock()
+	defer manager.mu.Unlock()
+
+	manager.services = append(manager.services, service)
+}
+
+// AddStopper registers something that takes work, e.g. a consumer or an HTTP
+// server. Stoppers run first, in registration order, and get a context that
+// expires with the drain deadline.
+func (manager *Manager) AddStopper(name string, stop func(ctx context.Context) error) {
+	manager.mu.Lock()
+	defer manager.mu.Unlock()
+
+	manager.stoppers = append(manager.stoppers, namedStopper{name: name, stop: stop})
+}
+
+// Track marks a unit of in-flight work; call the returned function when it's
+// done. The shutdown waits for tracked work until the drain deadline.
+func (manager *Manager) Track() (done func()) {
+	manager.inFlight.Add(1)
+	atomic.AddInt64(&manager.inFlightSize, 1)
+
+	var once sync.Once
+
+	return func() {
+		once.Do(func() {
+			atomic.AddInt64(&manager.inFlightSize, -1)
+			manager.inFlight.Done()
+		})
+	}
+}
+
+// Go runs fn in a tracked goroutine, e.g. a consumer loop.
+func (manager *Manager) Go(fn func(ctx context.Context)) {
+	done := manager.Track()
+
+	go func() {
+		defer done()
+
+		fn(manager.ctx)
+	}()
+}
+
+// HandleSignals starts the shutdown on SIGINT or SIGTERM. A second signal
+// exits right away.
+func (manager *Manager) HandleSignals() {
+	s := make(chan os.Signal, 2)
+
+	signal.Notify(s, syscall.SIGINT, syscall.SIGTERM)
+
+	go func() {
+		sig := <-s
+		log.Printf("Received %s, shutting down", sig)
+
+		go manager.Shutdown()
+
+		sig = <-s
+		log.Printf("Received %s while shutting down, exiting", sig)
+
+		os.Exit(1)
+	}()
+}
+
+// Shutdown runs the shutdown sequence once and returns when it's finished.
+func (manager *Manager) Shutdown() {
+	manager.shutdown.Do(func() {
+		defer close(manager.done)
+
+		manager.cancel()
+
+		deadline, cancel := context.WithTimeout(context.Background(), manager.drainTimeout)
+		defer cancel()
+
+		manager.mu.Lock()
+		stoppers := append([]namedStopper(nil), manager.stoppers...)
+		services := append([]schemas.Service(nil), manager.services...)
+		manager.mu.Unlock()
+
+		for _, stopper := range stoppers {
+			log.Printf("Stopping %s", stopper.name)
+			errorHandling.LogOnError(stopper.stop(deadline), "Failed stopping "+stopper.name)
+		}
+
+		drained := make(chan struct{})
+		go func() {
+			manager.inFlight.Wait()
+			close(drained)
+		}()
+
+		select {
+		case <-drained:
+			log.Println("In-flight work drained")
+		case <-deadline.Done():
+			log.Printf("Drain deadline passed with %d in-flight tasks, aborting them", atomic.LoadInt64(&manager.inFlightSize))
+		}
+
+		manager.cancelWork()
+
+		for i := len(services) - 1; i >= 0; i-- {
+			log.Printf("Closing service %s", services[i])
+			errorHandling.LogOnError(services[i].Close(), "Failed closing service "+services[i].String())
+		}
+	})
+}
+
+// Wait blocks until the shutdown has finished.
+func (manager *Manager) Wait() {
+	<-manager.done
+}
+
+// ReadyCheck fails once the shutdown has started, so the service is taken out
+// of rotation while it drains.
+func (manager *Manager) ReadyCheck(ctx context.Context) (string, error) {
+	if manager.ctx.Err() != nil {
+		return "", errors.New("shutting down")
+	}
+
+	return "", nil
+}
@@ -0,0 +1,91 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// APIKey is a showcase_server API key. The key itself is never stored, only
+// its hash. A RateLimitPerMinute of 0 means the server default applies.
+type APIKey struct {
+	ID                 int        `json:"id"`
+	Name               string     `json:"name"`
+	Scopes             []string   `json:"scopes"`
+	RateLimitPerMinute int        `json:"rateLimitPerMinute"`
+	CreatedAt          time.Time  `json:"createdAt"`
+	RevokedAt          *time.Time `json:"revokedAt,omitempty"`
+}
+
+const apiKeyColumns = "id, name, scopes, rate_limit_per_minute, created_at, revoked_at"
+
+func scanAPIKey(rows *sql.Rows) (APIKey, error) {
+	var key APIKey
+
+	return key, rows.Scan(
+		&key.ID,
+		&key.Name,
+		pq.Array(&key.Scopes),
+		&key.RateLimitPerMinute,
+		&key.CreatedAt,
+		&key.RevokedAt,
+	)
+}
+
+func (postgres *Client) CreateAPIKey(ctx context.Context, name, keyHash string, scopes []string, rateLimitPerMinute int) (APIKey, error) {
+	keys, err := queryAll(ctx, postgres, nil, "CreateAPIKey", `
+INSERT INTO api_keys (name, key_hash, scopes, rate_limit_per_minute) VALUES ($1, $2, $3, $4)
+RETURNING `+apiKeyColumns,
+		scanAPIKey,
+		name, keyHash, pq.Array(scopes), rateLimitPerMinute)
+	if err != nil {
+		return APIKey{}, err
+	}
+
+	return keys[0], nil
+}
+
+// GetActiveAPIKey returns the non revoked key with the given hash.
+func (postgres *Client) GetActiveAPIKey(ctx context.Context, keyHash string) (APIKey, error) {
+	keys, err := queryAll(ctx, postgres, nil, "GetActiveAPIKey",
+		"SELECT "+apiKeyColumns+" FROM api_keys WHERE key_hash = $1 AND revoked_at IS NULL",
+		scanAPIKey,
+		keyHash)
+	if err != nil {
+		return APIKey{}, err
+	}
+
+	if len(keys) == 0 {
+		return APIKey{}, ErrNotFound
+	}
+
+	return keys[0], nil
+}
+
+func (postgres *Client) ListAPIKeys(ctx context.Context) ([]APIKey, error) {
+	return queryAll(ctx, postgres, nil, "ListAPIKeys",
+		"SELECT "+apiKeyColumns+" FROM api_keys ORDER BY id",
+		scanAPIKey)
+}
+
+// RevokeAPIKey marks the key as revoked. Revoking twice is not an error.
+func (postgres *Client) RevokeAPIKey(ctx context.Context, id int) error {
+	result, err := postgres.exec(ctx, nil, "RevokeAPIKey",
+		"UPDATE api_keys SET revoked_at = COALESCE(revoked_at, now()) WHERE id = $1", id)
+	if err != nil {
+		return err
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if affected == 0 {
+		return ErrNotFound
+	}
+
+	return nil
+}
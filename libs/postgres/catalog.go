@@ -0,0 +1,103 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+
+	"sarasa/schemas"
+)
+
+// ErrNotFound is returned when a lookup by id matches no row.
+var ErrNotFound = errors.New("not found")
+
+// GetProvider returns a single provider with all its pics.
+func (postgres *Client) GetProvider(ctx context.Context, providerID int) (schemas.Provider, error) {
+	providers, err := queryAll(ctx, postgres, nil, "GetProvider", `
+SELECT
+	providers.id, providers.name, providers.phone,
+	sources.name as source,
+	zones.name as place,
+	providers.updated_at
+FROM providers
+    JOIN sources ON providers.source_id = sources.id
+    JOIN zones ON providers.zone_id = zones.id
+WHERE providers.id = $1
+`,
+		func(rows *sql.Rows) (schemas.Provider, error) {
+			var provider schemas.Provider
+
+			return provider, rows.Scan(
+				&provider.ID,
+				&provider.Name,
+				&provider.Phone,
+				&provider.Source,
+				&provider.Place,
+				&provider.UpdatedAt,
+			)
+		},
+		providerID)
+	if err != nil {
+		return schemas.Provider{}, err
+	}
+
+	if len(providers) == 0 {
+		return schemas.Provider{}, ErrNotFound
+	}
+
+	if err := postgres.attachPics(ctx, nil, providers); err != nil {
+		return schemas.Provider{}, err
+	}
+
+	return providers[0], nil
+}
+
+// ProviderExists reports whether a provider with the given id is stored.
+func (postgres *Client) ProviderExists(ctx context.Context, providerID int) (bool, error) {
+	exists, err := queryAll(ctx, postgres, nil, "ProviderExists",
+		"SELECT EXISTS (SELECT 1 FROM providers WHERE id = $1)",
+		func(rows *sql.Rows) (bool, error) {
+			var exists bool
+
+			return exists, rows.Scan(&exists)
+		},
+		providerID)
+	if err != nil {
+		return false, err
+	}
+
+	return len(exists) == 1 && exists[0], nil
+}
+
+// GetZonesSummary lists every zone with the number of providers in it.
+func (postgres *Client) GetZonesSummary(ctx context.Context) ([]schemas.ZoneSummary, error) {
+	return queryAll(ctx, postgres, nil, "GetZonesSummary", `
+SELECT zones.id, zones.name, count(providers.id)
+FROM zones
+    LEFT JOIN providers ON providers.zone_id = zones.id
+GROUP BY zones.id, zones.name
+ORDER BY zones.name
+`,
+		func(rows *sql.Rows) (schemas.ZoneSummary, error) {
+			var zone schemas.ZoneSummary
+
+			return zone, rows.Scan(&zone.ID, &zone.Name, &zone.ProvidersCount)
+		})
+}
+
+// GetSourcesSummary lists every source with the number of providers it has
+// and the time its last scrape was saved.
+func (postgres *Client) GetSourcesSummary(ctx context.Context) ([]schemas.SourceSummary, error) {
+	return queryAll(ctx, postgres, nil, "GetSourcesSummary", `
+SELECT sources.id, sources.name, count(providers.id), sources.last_saved_at
+FROM sources
+    LEFT JOIN providers ON providers.source_id = sources.id
+GROUP BY sources.id, sources.name, sources.last_saved_at
+ORDER BY sources.name
+`,
+		func(rows *sql.Rows) (schemas.SourceSummary, error) {
+			var source schemas.SourceSummary
+
+			return source, rows.Scan(&source.ID, &source.Name, &source.ProvidersCount, &source.LastSavedAt)
+		})
+}
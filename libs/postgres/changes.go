@@ -0,0 +1,166 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+
+	"sarasa/schemas"
+)
+
+// getSourceProviders returns the stored providers of a source with their pics.
+func (postgres *Client) getSourceProviders(ctx context.Context, tx *sql.Tx, sourceID int) ([]schemas.Provider, error) {
+	providers, err := queryAll(ctx, postgres, tx, "GetSourceProviders", `
+SELECT
+	providers.id, providers.name, providers.phone,
+	sources.name as source,
+	zones.name as place,
+	providers.updated_at
+FROM providers
+    JOIN sources ON providers.source_id = sources.id
+    JOIN zones ON providers.zone_id = zones.id
+WHERE providers.source_id = $1
+ORDER BY providers.id
+`,
+		func(rows *sql.Rows) (schemas.Provider, error) {
+			var provider schemas.Provider
+
+			return provider, rows.Scan(
+				&provider.ID,
+				&provider.Name,
+				&provider.Phone,
+				&provider.Source,
+				&provider.Place,
+				&provider.UpdatedAt,
+			)
+		},
+		sourceID)
+	if err != nil {
+		return nil, err
+	}
+
+	return providers, postgres.attachPics(ctx, tx, providers)
+}
+
+// diffProviders compares two saves of the same source. Providers are matched
+// by name and phone, like matchProviders does; a matched provider is updated
+// when its zone or pics differ.
+func diffProviders(previous, current []schemas.Provider) []schemas.ProviderChange {
+	pending := make(map[string][]schemas.Provider, len(previous))
+	for _, provider := range previous {
+		pending[providerKey(provider)] = append(pending[providerKey(provider)], provider)
+	}
+
+	changes := make([]schemas.ProviderChange, 0)
+	for _, provider := range current {
+		k := providerKey(provider)
+
+		if len(pending[k]) == 0 {
+			changes = append(changes, schemas.ProviderChange{Type: schemas.ProviderCreated, Provider: provider})
+			continue
+		}
+
+		old := pending[k][0]
+		pending[k] = pending[k][1:]
+
+		if providerChanged(old, provider) {
+			changes = append(changes, schemas.ProviderChange{Type: schemas.ProviderUpdated, Provider: provider})
+		}
+	}
+
+	for _, provider := range previous {
+		k := providerKey(provider)
+		if len(pending[k]) > 0 && pending[k][0].ID == provider.ID {
+			pending[k] = pending[k][1:]
+			changes = append(changes, schemas.ProviderChange{Type: schemas.ProviderRemoved, Provider: provider})
+		}
+	}
+
+	return changes
+}
+
+// providerKey identifies a provider across the saves of its source.
+func providerKey(p schemas.Provider) string {
+	return p.Name + "\x00" + p.Phone
+}
+
+// matchProviders returns, for every provider of current, the id of the
+// provider of previous with the same name and phone, or 0 when it's new.
+// Duplicates are paired in order, like diffProviders does.
+func matchProviders(previous, current []schemas.Provider) []int {
+	pending := make(map[string][]int, len(previous))
+	for _, provider := range previous {
+		pending[providerKey(provider)] = append(pending[providerKey(provider)], provider.ID)
+	}
+
+	ids := make([]int, len(current))
+	for i, provider := range current {
+		k := providerKey(provider)
+
+		if len(pending[k]) > 0 {
+			ids[i] = pending[k][0]
+			pending[k] = pending[k][1:]
+		}
+	}
+
+	return ids
+}
+
+// changedProviders tells, for every provider of current, whether it has to
+// be written: it's new, its id in ids being 0, or its zone or pics differ
+// from the provider of previous with that id.
+func changedProviders(previous, current []schemas.Provider, ids []int) []bool {
+	byID := make(map[int]schemas.Provider, len(previous))
+	for _, provider := range previous {
+		byID[provider.ID] = provider
+	}
+
+	changed := make([]bool, len(current))
+	for i, provider := range current {
+		changed[i] = ids[i] == 0 || providerChanged(byID[ids[i]], provider)
+	}
+
+	return changed
+}
+
+// providerChanged compares the stored fields of two providers sharing a
+// key. Links aren't stored.
+func providerChanged(old, provider schemas.Provider) bool {
+	return old.Place != provider.Place || !equalPics(old.Pics, provider.Pics)
+}
+
+// withApproved returns current along with the providers of previous whose
+// key is in approved and current lacks.
+func withApproved(previous, current []schemas.Provider, approved map[string]bool) []schemas.Provider {
+	if len(approved) == 0 {
+		return current
+	}
+
+	scraped := make(map[string]bool, len(current))
+	for _, provider := range current {
+		scraped[providerKey(provider)] = true
+	}
+
+	// Appending never writes to the backing array of the caller's slice.
+	kept := current[:len(current):len(current)]
+	for _, provider := range previous {
+		if approved[providerKey(provider)] && !scraped[providerKey(provider)] {
+			kept = append(kept, provider)
+		}
+	}
+
+	return kept
+}
+
+func equalPics(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+
+	return true
+}
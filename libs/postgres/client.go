@@ -1,36 +1,306 @@
 package postgres
 
 import (
+	"context"
 	"database/sql"
+	"errors"
 	"fmt"
 	"log"
+	"sync"
+	"time"
 
+	"sarasa/libs/circuitBreaker"
+	"sarasa/libs/retryHandling"
 	"sarasa/schemas"
 
 	_ "github.com/lib/pq"
 )
 
+// ConnectPolicy retries the first ping while the database is starting.
+var ConnectPolicy = retryHandling.Policy{
+	MaxElapsed:   time.Minute,
+	InitialDelay: time.Second,
+	MaxDelay:     10 * time.Second,
+	Jitter:       0.2,
+	OnRetry:      retryHandling.LogRetry,
+}
+
+// RetryPolicy is used by Retry.
+var RetryPolicy = retryHandling.DefaultPolicy
+
+// Client wraps the connection pool. It holds no per-operation state, so a
+// single instance can be shared between goroutines; transactions are scoped
+// to a WithTx call instead of being stored on the client.
 type Client struct {
 	connection *sql.DB
-	txn        *sql.Tx
+	stmts      *stmtCache
+	observer   QueryObserver
+	breaker    *circuitBreaker.Breaker
+}
+
+// BreakerSettings configures the circuit breaker created by Init, which
+// fails queries fast while the database is unreachable.
+var BreakerSettings = circuitBreaker.Settings{OpenTimeout: 15 * time.Second}
+
+// QueryObserver is called after every query run through the client with the
+// query name, how long it took and the resulting error, if any.
+type QueryObserver func(name string, elapsed time.Duration, err error)
+
+// stmtCache keeps one prepared statement per query text for the lifetime of
+// the connection pool. Transactions reuse them through Tx.StmtContext.
+type stmtCache struct {
+	mu    sync.Mutex
+	stmts map[string]*sql.Stmt
 }
 
+// Init connects to Postgres, waiting for it with ConnectPolicy, and applies
+// the pending migrations.
 func (postgres *Client) Init(pc schemas.PostgresConfig) error {
 	log.Println("Initializing Postgres client...")
 
-	var err error
-
 	dataSource := fmt.Sprintf("postgres://%s:%s@%s/%s?sslmode=disable", pc.User, pc.Password, pc.Host, pc.Database)
 
-	postgres.connection, err = sql.Open("postgres", dataSource)
+	connection, err := sql.Open("postgres", dataSource)
 	if err != nil {
 		return err
 	}
 
+	postgres.Use(connection)
+	postgres.breaker = circuitBreaker.New("postgres", BreakerSettings)
+
+	if pc.MaxOpenConns > 0 {
+		postgres.connection.SetMaxOpenConns(pc.MaxOpenConns)
+	}
+
+	if pc.MaxIdleConns > 0 {
+		postgres.connection.SetMaxIdleConns(pc.MaxIdleConns)
+	}
+
+	if pc.ConnMaxLifetimeSeconds > 0 {
+		postgres.connection.SetConnMaxLifetime(time.Duration(pc.ConnMaxLifetimeSeconds) * time.Second)
+	}
+
+	if pc.ConnMaxIdleTimeSeconds > 0 {
+		postgres.connection.SetConnMaxIdleTime(time.Duration(pc.ConnMaxIdleTimeSeconds) * time.Second)
+	}
+
+	if err := ConnectPolicy.Do(context.Background(), postgres.connection.PingContext); err != nil {
+		return err
+	}
+
+	return postgres.Migrate(context.Background())
+}
+
+// Use makes the client run its queries on db, an already opened pool, e.g. a
+// sqlmock one in tests. Unlike Init it neither pings nor migrates it.
+func (postgres *Client) Use(db *sql.DB) {
+	postgres.connection = db
+	postgres.stmts = &stmtCache{stmts: make(map[string]*sql.Stmt)}
+}
+
+// Retry runs f with RetryPolicy, e.g. a WithTx call failing on a deadlock.
+// f must be safe to run again.
+func (postgres *Client) Retry(ctx context.Context, f func(ctx context.Context) error) error {
+	return RetryPolicy.Do(ctx, f)
+}
+
+// WithTx runs fn inside a transaction. The transaction is committed when fn
+// returns nil and rolled back on any error, including a panic inside fn.
+func (postgres *Client) WithTx(ctx context.Context, fn func(tx *sql.Tx) error) (err error) {
+	done, err := postgres.guard(nil)
+	if err != nil {
+		return err
+	}
+
+	defer func() { done(err) }()
+
+	tx, err := postgres.connection.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("postgressClient/WithTx - Fail to start a transaction, error: %w", err)
+	}
+
+	defer func() {
+		if p := recover(); p != nil {
+			rollback(tx)
+			panic(p)
+		}
+
+		if err != nil {
+			rollback(tx)
+		}
+	}()
+
+	if err = fn(tx); err != nil {
+		return err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return fmt.Errorf("postgressClient/WithTx - Fail to commit txn, error: %w", err)
+	}
+
 	return nil
 }
 
+func rollback(tx *sql.Tx) {
+	if err := tx.Rollback(); err != nil && err != sql.ErrTxDone {
+		log.Printf("Error rolling back postgres transaction - error: %s", err)
+	}
+}
+
+// SetQueryObserver registers fn to be notified of every query latency. It is
+// meant to be called once right after Init.
+func (postgres *Client) SetQueryObserver(fn QueryObserver) {
+	postgres.observer = fn
+}
+
+// prepare returns the cached statement for query, preparing it on first use.
+// Inside a transaction the cached statement is rebound to tx.
+func (postgres *Client) prepare(ctx context.Context, tx *sql.Tx, query string) (*sql.Stmt, error) {
+	postgres.stmts.mu.Lock()
+	stmt, ok := postgres.stmts.stmts[query]
+	if !ok {
+		var err error
+
+		stmt, err = postgres.connection.PrepareContext(ctx, query)
+		if err != nil {
+			postgres.stmts.mu.Unlock()
+			return nil, err
+		}
+
+		postgres.stmts.stmts[query] = stmt
+	}
+	postgres.stmts.mu.Unlock()
+
+	if tx != nil {
+		return tx.StmtContext(ctx, stmt), nil
+	}
+
+	return stmt, nil
+}
+
+// guard reserves a call on the circuit breaker. Statements run on tx are
+// covered by the WithTx call that started it.
+func (postgres *Client) guard(tx *sql.Tx) (done func(err error), err error) {
+	if tx != nil || postgres.breaker == nil {
+		return func(error) {}, nil
+	}
+
+	return postgres.breaker.Allow()
+}
+
+// Breaker returns the client's circuit breaker, nil before Init.
+func (postgres *Client) Breaker() *circuitBreaker.Breaker {
+	return postgres.breaker
+}
+
+func (postgres *Client) observe(name string, startTime time.Time, err error) {
+	if postgres.observer != nil {
+		postgres.observer(name, time.Since(startTime), err)
+	}
+}
+
+// queryAll runs a cached statement, on tx when not nil, and scans every row
+// with scan. It takes care of closing rows and reporting the query latency.
+func queryAll[T any](ctx context.Context, postgres *Client, tx *sql.Tx, name, query string, scan func(rows *sql.Rows) (T, error), args ...interface{}) (result []T, err error) {
+	done, err := postgres.guard(tx)
+	if err != nil {
+		return nil, err
+	}
+
+	defer func() { done(err) }()
+
+	startTime := time.Now()
+	defer func() { postgres.observe(name, startTime, err) }()
+
+	stmt, err := postgres.prepare(ctx, tx, query)
+	if err != nil {
+		return nil, fmt.Errorf("%s - Fail to prepare statement, error: %w", name, err)
+	}
+
+	rows, err := stmt.QueryContext(ctx, args...)
+	if err != nil {
+		return nil, fmt.Errorf("%s - Fail to run query, error: %w", name, err)
+	}
+
+	defer closeRows(rows)
+
+	result = make([]T, 0)
+	for rows.Next() {
+		row, err := scan(rows)
+		if err != nil {
+			return nil, fmt.Errorf("%s - Fail to scan row, error: %w", name, err)
+		}
+
+		result = append(result, row)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("%s - Fail to iterate rows, error: %w", name, err)
+	}
+
+	return result, nil
+}
+
+// exec runs a cached statement that returns no rows, on tx when not nil.
+func (postgres *Client) exec(ctx context.Context, tx *sql.Tx, name, query string, args ...interface{}) (result sql.Result, err error) {
+	done, err := postgres.guard(tx)
+	if err != nil {
+		return nil, err
+	}
+
+	defer func() { done(err) }()
+
+	startTime := time.Now()
+	defer func() { postgres.observe(name, startTime, err) }()
+
+	stmt, err := postgres.prepare(ctx, tx, query)
+	if err != nil {
+		return nil, fmt.Errorf("%s - Fail to prepare statement, error: %w", name, err)
+	}
+
+	result, err = stmt.ExecContext(ctx, args...)
+	if err != nil {
+		return nil, fmt.Errorf("%s - Fail to exec statement, error: %w", name, err)
+	}
+
+	return result, nil
+}
+
+func closeStmt(stmt *sql.Stmt) {
+	if err := stmt.Close(); err != nil {
+		log.Printf("Error closing postgres statement - error: %s", err)
+	}
+}
+
+func closeRows(rows *sql.Rows) {
+	if err := rows.Close(); err != nil {
+		log.Printf("Error closing postgres statement rows - error: %s", err)
+	}
+}
+
+// Ping checks a connection to the database can be used.
+func (postgres *Client) Ping(ctx context.Context) error {
+	if postgres.connection == nil {
+		return errors.New("not initialized")
+	}
+
+	return postgres.connection.PingContext(ctx)
+}
+
 func (postgres Client) Close() error {
+	if postgres.connection == nil {
+		return nil
+	}
+
+	if postgres.stmts != nil {
+		postgres.stmts.mu.Lock()
+		for query, stmt := range postgres.stmts.stmts {
+			closeStmt(stmt)
+			delete(postgres.stmts.stmts, query)
+		}
+		postgres.stmts.mu.Unlock()
+	}
+
 	return postgres.connection.Close()
 }
 
@@ -49,6 +49,82 @@ SET default_tablespace = '';
 
 SET default_table_access_method = heap;
 
+--
+-- Name: api_keys; Type: TABLE; Schema: public; Owner: postgres
+--
+
+CREATE TABLE public.api_keys (
+    id integer NOT NULL,
+    name character varying NOT NULL,
+    key_hash character(64) NOT NULL,
+    scopes character varying[] NOT NULL,
+    rate_limit_per_minute integer DEFAULT 0 NOT NULL,
+    created_at timestamp with time zone DEFAULT now() NOT NULL,
+    revoked_at timestamp with time zone
+);
+
+
+ALTER TABLE public.api_keys OWNER TO postgres;
+
+--
+-- Name: api_keys_id_seq; Type: SEQUENCE; Schema: public; Owner: postgres
+--
+
+CREATE SEQUENCE public.api_keys_id_seq
+    AS integer
+    START WITH 1
+    INCREMENT BY 1
+    NO MINVALUE
+    NO MAXVALUE
+    CACHE 1;
+
+
+ALTER TABLE public.api_keys_id_seq OWNER TO postgres;
+
+--
+-- Name: api_keys_id_seq; Type: SEQUENCE OWNED BY; Schema: public; Owner: postgres
+--
+
+ALTER SEQUENCE public.api_keys_id_seq OWNED BY public.api_keys.id;
+
+
+--
+-- Name: provider_first_seen; Type: TABLE; Schema: public; Owner: postgres
+--
+
+CREATE TABLE public.provider_first_seen (
+    id integer NOT NULL,
+    source_id integer NOT NULL,
+    name character varying NOT NULL,
+    phone character varying NOT NULL,
+    first_seen_at timestamp with time zone DEFAULT now() NOT NULL
+);
+
+
+ALTER TABLE public.provider_first_seen OWNER TO postgres;
+
+--
+-- Name: provider_first_seen_id_seq; Type: SEQUENCE; Schema: public; Owner: postgres
+--
+
+CREATE SEQUENCE public.provider_first_seen_id_seq
+    AS integer
+    START WITH 1
+    INCREMENT BY 1
+    NO MINVALUE
+    NO MAXVALUE
+    CACHE 1;
+
+
+ALTER TABLE public.provider_first_seen_id_seq OWNER TO postgres;
+
+--
+-- Name: provider_first_seen_id_seq; Type: SEQUENCE OWNED BY; Schema: public; Owner: postgres
+--
+
+ALTER SEQUENCE public.provider_first_seen_id_seq OWNED BY public.provider_first_seen.id;
+
+
 --
 -- Name: provider_pics; Type: TABLE; Schema: public; Owner: postgres
 --
@@ -93,7 +169,8 @@ CREATE TABLE public.providers (
     name character varying NOT NULL,
     phone character varying NOT NULL,
     zone_id integer NOT NULL,
-    source_id integer NOT NULL
+    source_id integer NOT NULL,
+    updated_at timestamp with time zone DEFAULT now() NOT NULL
 );
 
 
@@ -121,13 +198,151 @@ ALTER TABLE public.providers_id_seq OWNER TO postgres;
 ALTER SEQUENCE public.providers_id_seq OWNED BY public.providers.id;
 
 
+--
+-- Name: quarantine_decisions; Type: TABLE; Schema: public; Owner: postgres
+--
+
+CREATE TABLE public.quarantine_decisions (
+    id integer NOT NULL,
+    source character varying NOT NULL,
+    name character varying NOT NULL,
+    phone character varying NOT NULL,
+    status character varying NOT NULL,
+    decided_at timestamp with time zone DEFAULT now() NOT NULL
+);
+
+
+ALTER TABLE public.quarantine_decisions OWNER TO postgres;
+
+--
+-- Name: quarantine_decisions_id_seq; Type: SEQUENCE; Schema: public; Owner: postgres
+--
+
+CREATE SEQUENCE public.quarantine_decisions_id_seq
+    AS integer
+    START WITH 1
+    INCREMENT BY 1
+    NO MINVALUE
+    NO MAXVALUE
+    CACHE 1;
+
+
+ALTER TABLE public.quarantine_decisions_id_seq OWNER TO postgres;
+
+--
+-- Name: quarantine_decisions_id_seq; Type: SEQUENCE OWNED BY; Schema: public; Owner: postgres
+--
+
+ALTER SEQUENCE public.quarantine_decisions_id_seq OWNED BY public.quarantine_decisions.id;
+
+
+--
+-- Name: quarantined_providers; Type: TABLE; Schema: public; Owner: postgres
+--
+
+CREATE TABLE public.quarantined_providers (
+    id integer NOT NULL,
+    run_id integer NOT NULL,
+    source character varying NOT NULL,
+    name character varying NOT NULL,
+    phone character varying NOT NULL,
+    place character varying NOT NULL,
+    link character varying NOT NULL,
+    pics character varying[] NOT NULL,
+    reason character varying NOT NULL,
+    status character varying DEFAULT 'pending'::character varying NOT NULL,
+    created_at timestamp with time zone DEFAULT now() NOT NULL,
+    decided_at timestamp with time zone
+);
+
+
+ALTER TABLE public.quarantined_providers OWNER TO postgres;
+
+--
+-- Name: quarantined_providers_id_seq; Type: SEQUENCE; Schema: public; Owner: postgres
+--
+
+CREATE SEQUENCE public.quarantined_providers_id_seq
+    AS integer
+    START WITH 1
+    INCREMENT BY 1
+    NO MINVALUE
+    NO MAXVALUE
+    CACHE 1;
+
+
+ALTER TABLE public.quarantined_providers_id_seq OWNER TO postgres;
+
+--
+-- Name: quarantined_providers_id_seq; Type: SEQUENCE OWNED BY; Schema: public; Owner: postgres
+--
+
+ALTER SEQUENCE public.quarantined_providers_id_seq OWNED BY public.quarantined_providers.id;
+
+
+--
+-- Name: runs; Type: TABLE; Schema: public; Owner: postgres
+--
+
+CREATE TABLE public.runs (
+    id integer NOT NULL,
+    uuid character varying NOT NULL,
+    source character varying NOT NULL,
+    status character varying NOT NULL,
+    received_providers_count integer NOT NULL,
+    invalid_providers_count integer NOT NULL,
+    saved_providers_count integer NOT NULL,
+    invalid_reasons jsonb DEFAULT '{}'::jsonb NOT NULL,
+    error character varying DEFAULT ''::character varying NOT NULL,
+    started_at timestamp with time zone NOT NULL,
+    finished_at timestamp with time zone NOT NULL
+);
+
+
+ALTER TABLE public.runs OWNER TO postgres;
+
+--
+-- Name: runs_id_seq; Type: SEQUENCE; Schema: public; Owner: postgres
+--
+
+CREATE SEQUENCE public.runs_id_seq
+    AS integer
+    START WITH 1
+    INCREMENT BY 1
+    NO MINVALUE
+    NO MAXVALUE
+    CACHE 1;
+
+
+ALTER TABLE public.runs_id_seq OWNER TO postgres;
+
+--
+-- Name: runs_id_seq; Type: SEQUENCE OWNED BY; Schema: public; Owner: postgres
+--
+
+ALTER SEQUENCE public.runs_id_seq OWNED BY public.runs.id;
+
+
+--
+-- Name: schema_migrations; Type: TABLE; Schema: public; Owner: postgres
+--
+
+CREATE TABLE public.schema_migrations (
+    version character varying NOT NULL,
+    applied_at timestamp with time zone DEFAULT now() NOT NULL
+);
+
+
+ALTER TABLE public.schema_migrations OWNER TO postgres;
+
 --
 -- Name: sources; Type: TABLE; Schema: public; Owner: postgres
 --
 
 CREATE TABLE public.sources (
     id integer NOT NULL,
-    name character varying NOT NULL
+    name character varying NOT NULL,
+    last_saved_at timestamp with time zone
 );
 
 
@@ -189,6 +404,20 @@ ALTER TABLE public.zones_id_seq OWNER TO postgres;
 ALTER SEQUENCE public.zones_id_seq OWNED BY public.zones.id;
 
 
+--
+-- Name: api_keys id; Type: DEFAULT; Schema: public; Owner: postgres
+--
+
+ALTER TABLE ONLY public.api_keys ALTER COLUMN id SET DEFAULT nextval('public.api_keys_id_seq'::regclass);
+
+
+--
+-- Name: provider_first_seen id; Type: DEFAULT; Schema: public; Owner: postgres
+--
+
+ALTER TABLE ONLY public.provider_first_seen ALTER COLUMN id SET DEFAULT nextval('public.provider_first_seen_id_seq'::regclass);
+
+
 --
 -- Name: provider_pics id; Type: DEFAULT; Schema: public; Owner: postgres
 --
@@ -203,6 +432,27 @@ ALTER TABLE ONLY public.provider_pics ALTER COLUMN id SET DEFAULT nextval('publi
 ALTER TABLE ONLY public.providers ALTER COLUMN id SET DEFAULT nextval('public.providers_id_seq'::regclass);
 
 
+--
+-- Name: quarantine_decisions id; Type: DEFAULT; Schema: public; Owner: postgres
+--
+
+ALTER TABLE ONLY public.quarantine_decisions ALTER COLUMN id SET DEFAULT nextval('public.quarantine_decisions_id_seq'::regclass);
+
+
+--
+-- Name: quarantined_providers id; Type: DEFAULT; Schema: public; Owner: postgres
+--
+
+ALTER TABLE ONLY public.quarantined_providers ALTER COLUMN id SET DEFAULT nextval('public.quarantined_providers_id_seq'::regclass);
+
+
+--
+-- Name: runs id; Type: DEFAULT; Schema: public; Owner: postgres
+--
+
+ALTER TABLE ONLY public.runs ALTER COLUMN id SET DEFAULT nextval('public.runs_id_seq'::regclass);
+
+
 --
 -- Name: sources id; Type: DEFAULT; Schema: public; Owner: postgres
 --
@@ -217,6 +467,36 @@ ALTER TABLE ONLY public.sources ALTER COLUMN id SET DEFAULT nextval('public.sour
 ALTER TABLE ONLY public.zones ALTER COLUMN id SET DEFAULT nextval('public.zones_id_seq'::regclass);
 
 
+--
+-- Data for Name: schema_migrations; Type: TABLE DATA; Schema: public; Owner: postgres
+--
+
+COPY public.schema_migrations (version, applied_at) FROM stdin;
+0001_providers_updated_at	2026-10-19 00:00:00+00
+0002_sources_last_saved_at	2026-10-19 00:00:00+00
+0003_provider_first_seen	2026-10-19 00:00:00+00
+0004_api_keys	2026-10-19 00:00:00+00
+0005_runs_and_quarantine	2026-10-19 00:00:00+00
+0006_quarantine_decisions	2026-10-19 00:00:00+00
+\.
+
+
+--
+-- Name: api_keys api_keys_pkey; Type: CONSTRAINT; Schema: public; Owner: postgres
+--
+
+ALTER TABLE ONLY public.api_keys
+    ADD CONSTRAINT api_keys_pkey PRIMARY KEY (id);
+
+
+--
+-- Name: provider_first_seen provider_first_seen_pkey; Type: CONSTRAINT; Schema: public; Owner: postgres
+--
+
+ALTER TABLE ONLY public.provider_first_seen
+    ADD CONSTRAINT provider_first_seen_pkey PRIMARY KEY (id);
+
+
 --
 -- Name: provider_pics provider_pics_pk; Type: CONSTRAINT; Schema: public; Owner: postgres
 --
@@ -233,6 +513,38 @@ ALTER TABLE ONLY public.providers
     ADD CONSTRAINT providers_pk PRIMARY KEY (id);
 
 
+--
+-- Name: quarantine_decisions quarantine_decisions_pkey; Type: CONSTRAINT; Schema: public; Owner: postgres
+--
+
+ALTER TABLE ONLY public.quarantine_decisions
+    ADD CONSTRAINT quarantine_decisions_pkey PRIMARY KEY (id);
+
+
+--
+-- Name: quarantined_providers quarantined_providers_pkey; Type: CONSTRAINT; Schema: public; Owner: postgres
+--
+
+ALTER TABLE ONLY public.quarantined_providers
+    ADD CONSTRAINT quarantined_providers_pkey PRIMARY KEY (id);
+
+
+--
+-- Name: runs runs_pkey; Type: CONSTRAINT; Schema: public; Owner: postgres
+--
+
+ALTER TABLE ONLY public.runs
+    ADD CONSTRAINT runs_pkey PRIMARY KEY (id);
+
+
+--
+-- Name: schema_migrations schema_migrations_pkey; Type: CONSTRAINT; Schema: public; Owner: postgres
+--
+
+ALTER TABLE ONLY public.schema_migrations
+    ADD CONSTRAINT schema_migrations_pkey PRIMARY KEY (version);
+
+
 --
 -- Name: sources sources_pk; Type: CONSTRAINT; Schema: public; Owner: postgres
 --
@@ -249,6 +561,76 @@ ALTER TABLE ONLY public.zones
     ADD CONSTRAINT zones_pk PRIMARY KEY (id);
 
 
+--
+-- Name: api_keys_key_hash_uindex; Type: INDEX; Schema: public; Owner: postgres
+--
+
+CREATE UNIQUE INDEX api_keys_key_hash_uindex ON public.api_keys USING btree (key_hash);
+
+
+--
+-- Name: provider_first_seen_first_seen_at_index; Type: INDEX; Schema: public; Owner: postgres
+--
+
+CREATE INDEX provider_first_seen_first_seen_at_index ON public.provider_first_seen USING btree (first_seen_at);
+
+
+--
+-- Name: provider_first_seen_key_uindex; Type: INDEX; Schema: public; Owner: postgres
+--
+
+CREATE UNIQUE INDEX provider_first_seen_key_uindex ON public.provider_first_seen USING btree (source_id, name, phone);
+
+
+--
+-- Name: provider_pics_provider_id_index; Type: INDEX; Schema: public; Owner: postgres
+--
+
+CREATE INDEX provider_pics_provider_id_index ON public.provider_pics USING btree (provider_id);
+
+
+--
+-- Name: providers_source_id_index; Type: INDEX; Schema: public; Owner: postgres
+--
+
+CREATE INDEX providers_source_id_index ON public.providers USING btree (source_id);
+
+
+--
+-- Name: providers_updated_at_index; Type: INDEX; Schema: public; Owner: postgres
+--
+
+CREATE INDEX providers_updated_at_index ON public.providers USING btree (updated_at, id);
+
+
+--
+-- Name: providers_zone_id_index; Type: INDEX; Schema: public; Owner: postgres
+--
+
+CREATE INDEX providers_zone_id_index ON public.providers USING btree (zone_id);
+
+
+--
+-- Name: quarantine_decisions_key_uindex; Type: INDEX; Schema: public; Owner: postgres
+--
+
+CREATE UNIQUE INDEX quarantine_decisions_key_uindex ON public.quarantine_decisions USING btree (source, name, phone);
+
+
+--
+-- Name: quarantined_providers_run_id_index; Type: INDEX; Schema: public; Owner: postgres
+--
+
+CREATE INDEX quarantined_providers_run_id_index ON public.quarantined_providers USING btree (run_id);
+
+
+--
+-- Name: runs_source_index; Type: INDEX; Schema: public; Owner: postgres
+--
+
+CREATE INDEX runs_source_index ON public.runs USING btree (source, id);
+
+
 --
 -- Name: sources_name_uindex; Type: INDEX; Schema: public; Owner: postgres
 --
@@ -263,6 +645,14 @@ CREATE UNIQUE INDEX sources_name_uindex ON public.sources USING btree (name);
 CREATE UNIQUE INDEX zones_name_uindex ON public.zones USING btree (name);
 
 
+--
+-- Name: provider_first_seen provider_first_seen_source_id_fkey; Type: FK CONSTRAINT; Schema: public; Owner: postgres
+--
+
+ALTER TABLE ONLY public.provider_first_seen
+    ADD CONSTRAINT provider_first_seen_source_id_fkey FOREIGN KEY (source_id) REFERENCES public.sources(id) ON UPDATE CASCADE ON DELETE CASCADE;
+
+
 --
 -- Name: provider_pics provider_pics_provider_id_fk; Type: FK CONSTRAINT; Schema: public; Owner: postgres
 --
@@ -287,6 +677,15 @@ ALTER TABLE ONLY public.providers
     ADD CONSTRAINT providers_zones_zone_id_fk FOREIGN KEY (zone_id) REFERENCES public.zones(id) ON UPDATE CASCADE ON DELETE CASCADE;
 
 
+--
+-- Name: quarantined_providers quarantined_providers_run_id_fkey; Type: FK CONSTRAINT; Schema: public; Owner: postgres
+--
+
+ALTER TABLE ONLY public.quarantined_providers
+    ADD CONSTRAINT quarantined_providers_run_id_fkey FOREIGN KEY (run_id) REFERENCES public.runs(id) ON UPDATE CASCADE ON DELETE CASCADE;
+
+
 --
 -- PostgreSQL database dump complete
---
\ No newline at end of file
+--
+
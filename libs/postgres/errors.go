@@ -0,0 +1,44 @@
+package postgres
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+
+	"sarasa/libs/errorHandling"
+
+	"github.com/lib/pq"
+)
+
+func init() {
+	errorHandling.RegisterClassifier(classify)
+}
+
+// classify maps driver errors to errorHandling categories, using the
+// SQLSTATE class for server errors.
+func classify(err error) (errorHandling.Category, bool) {
+	switch {
+	case errors.Is(err, ErrNotFound), errors.Is(err, sql.ErrNoRows), errors.Is(err, ErrAlreadyDecided):
+		return errorHandling.Permanent, true
+	case errors.Is(err, ErrInvalidFilter), errors.Is(err, ErrNoPlace):
+		return errorHandling.InvalidInput, true
+	case errors.Is(err, driver.ErrBadConn), errors.Is(err, sql.ErrConnDone):
+		return errorHandling.DependencyDown, true
+	}
+
+	var pqErr *pq.Error
+	if !errors.As(err, &pqErr) {
+		return errorHandling.Unknown, false
+	}
+
+	switch pqErr.Code.Class() {
+	case "08", "53", "57": // connection exception, insufficient resources, operator intervention
+		return errorHandling.DependencyDown, true
+	case "40": // transaction rollback: serialization failures and deadlocks
+		return errorHandling.Transient, true
+	case "22", "23": // data exception, integrity constraint violation
+		return errorHandling.InvalidInput, true
+	}
+
+	return errorHandling.Permanent, true
+}
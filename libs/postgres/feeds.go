@@ -0,0 +1,68 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"sarasa/schemas"
+)
+
+// NewProvider is a provider along with the first time it was seen. FirstSeenID
+// identifies the provider across saves, even when its source stops listing
+// it for a while and it's inserted again with a new Provider.ID.
+type NewProvider struct {
+	schemas.Provider
+	FirstSeenID int
+	FirstSeenAt time.Time
+	FirstPic    string
+}
+
+// GetNewProvidersByZone returns the most recently discovered providers of a
+// zone, newest first.
+func (postgres *Client) GetNewProvidersByZone(ctx context.Context, zone string, limit int) ([]NewProvider, error) {
+	return postgres.getNewProviders(ctx, "GetNewProvidersByZone", "zones.name = $1", zone, limit)
+}
+
+// GetNewProvidersBySource returns the most recently discovered providers of a
+// source, newest first.
+func (postgres *Client) GetNewProvidersBySource(ctx context.Context, source string, limit int) ([]NewProvider, error) {
+	return postgres.getNewProviders(ctx, "GetNewProvidersBySource", "sources.name = $1", source, limit)
+}
+
+func (postgres *Client) getNewProviders(ctx context.Context, name, condition, value string, limit int) ([]NewProvider, error) {
+	return queryAll(ctx, postgres, nil, name, `
+SELECT
+	providers.id, providers.name, providers.phone,
+	sources.name as source,
+	zones.name as place,
+	providers.updated_at,
+	first_seen.id, first_seen.first_seen_at,
+	COALESCE((SELECT pic_url FROM provider_pics WHERE provider_id = providers.id ORDER BY id LIMIT 1), '')
+FROM providers
+    JOIN sources ON providers.source_id = sources.id
+    JOIN zones ON providers.zone_id = zones.id
+    JOIN provider_first_seen first_seen ON first_seen.source_id = providers.source_id
+        AND first_seen.name = providers.name
+        AND first_seen.phone = providers.phone
+WHERE `+condition+`
+ORDER BY first_seen.first_seen_at DESC, providers.id DESC
+LIMIT $2
+`,
+		func(rows *sql.Rows) (NewProvider, error) {
+			var provider NewProvider
+
+			return provider, rows.Scan(
+				&provider.ID,
+				&provider.Name,
+				&provider.Phone,
+				&provider.Source,
+				&provider.Place,
+				&provider.UpdatedAt,
+				&provider.FirstSeenID,
+				&provider.FirstSeenAt,
+				&provider.FirstPic,
+			)
+		},
+		value, limit)
+}
@@ -0,0 +1,93 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"fmt"
+	"io/fs"
+	"log"
+	"sort"
+	"strings"
+)
+
+// migrationFiles change the schema of dump.sql, applied in name order by
+// Migrate. dump.sql is kept up to date with them and records them as
+// applied, so a database created from it has nothing to migrate.
+//
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+// migrationsLock is the advisory lock key serializing Migrate between the
+// services starting at the same time.
+const migrationsLock = 7305
+
+// Migrate applies the migrations not recorded in schema_migrations yet, each
+// in its own transaction. A database migrated by hand before must have its
+// migrations inserted in schema_migrations first.
+func (postgres *Client) Migrate(ctx context.Context) error {
+	_, err := postgres.connection.ExecContext(ctx, `
+CREATE TABLE IF NOT EXISTS public.schema_migrations (
+    version character varying PRIMARY KEY,
+    applied_at timestamp with time zone DEFAULT now() NOT NULL
+)`)
+	if err != nil {
+		return fmt.Errorf("postgressClient/Migrate - Fail to create schema_migrations, error: %w", err)
+	}
+
+	files, err := fs.Glob(migrationFiles, "migrations/*.sql")
+	if err != nil {
+		return err
+	}
+
+	sort.Strings(files)
+
+	for _, file := range files {
+		version := strings.TrimSuffix(strings.TrimPrefix(file, "migrations/"), ".sql")
+
+		err := postgres.WithTx(ctx, func(tx *sql.Tx) error {
+			return postgres.migrate(ctx, tx, file, version)
+		})
+		if err != nil {
+			return fmt.Errorf("postgressClient/Migrate - Fail to apply %s, error: %w", version, err)
+		}
+	}
+
+	return nil
+}
+
+func (postgres *Client) migrate(ctx context.Context, tx *sql.Tx, file, version string) error {
+	if _, err := postgres.exec(ctx, tx, "LockMigrations", "SELECT pg_advisory_xact_lock($1)", migrationsLock); err != nil {
+		return err
+	}
+
+	applied, err := queryAll(ctx, postgres, tx, "GetMigration",
+		"SELECT version FROM schema_migrations WHERE version = $1",
+		func(rows *sql.Rows) (string, error) {
+			var v string
+
+			return v, rows.Scan(&v)
+		},
+		version)
+	if err != nil || len(applied) > 0 {
+		return err
+	}
+
+	content, err := migrationFiles.ReadFile(file)
+	if err != nil {
+		return err
+	}
+
+	// Migrations hold several statements, which can't be prepared.
+	if _, err := tx.ExecContext(ctx, string(content)); err != nil {
+		return err
+	}
+
+	if _, err := postgres.exec(ctx, tx, "RecordMigration", "INSERT INTO schema_migrations (version) VALUES ($1)", version); err != nil {
+		return err
+	}
+
+	log.Printf("Applied migration %s", version)
+
+	return nil
+}
@@ -0,0 +1,9 @@
+-- The last time the provider was inserted, or its zone or pics changed on a
+-- save of its source.
+ALTER TABLE public.providers
+    ADD COLUMN updated_at timestamp with time zone DEFAULT now() NOT NULL;
+
+CREATE INDEX providers_zone_id_index ON public.providers USING btree (zone_id);
+CREATE INDEX providers_source_id_index ON public.providers USING btree (source_id);
+CREATE INDEX providers_updated_at_index ON public.providers USING btree (updated_at, id);
+CREATE INDEX provider_pics_provider_id_index ON public.provider_pics USING btree (provider_id);
@@ -0,0 +1,3 @@
+-- Set by core every time a scrape of the source is saved.
+ALTER TABLE public.sources
+    ADD COLUMN last_saved_at timestamp with time zone;
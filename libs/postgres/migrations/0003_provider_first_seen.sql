@@ -0,0 +1,18 @@
+-- Providers rows are deleted when their source stops listing them, so the
+-- first time a provider was seen is kept apart, keyed by source, name and
+-- phone.
+CREATE TABLE public.provider_first_seen (
+    id serial PRIMARY KEY,
+    source_id integer NOT NULL REFERENCES public.sources(id) ON UPDATE CASCADE ON DELETE CASCADE,
+    name character varying NOT NULL,
+    phone character varying NOT NULL,
+    first_seen_at timestamp with time zone DEFAULT now() NOT NULL
+);
+
+CREATE UNIQUE INDEX provider_first_seen_key_uindex ON public.provider_first_seen USING btree (source_id, name, phone);
+CREATE INDEX provider_first_seen_first_seen_at_index ON public.provider_first_seen USING btree (first_seen_at);
+
+INSERT INTO public.provider_first_seen (source_id, name, phone, first_seen_at)
+SELECT source_id, name, phone, min(updated_at)
+FROM public.providers
+GROUP BY source_id, name, phone;
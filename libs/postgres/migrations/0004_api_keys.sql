@@ -0,0 +1,12 @@
+-- Keys of the showcase_server API. Only a SHA-256 of the key is stored.
+CREATE TABLE public.api_keys (
+    id serial PRIMARY KEY,
+    name character varying NOT NULL,
+    key_hash character(64) NOT NULL,
+    scopes character varying[] NOT NULL,
+    rate_limit_per_minute integer DEFAULT 0 NOT NULL,
+    created_at timestamp with time zone DEFAULT now() NOT NULL,
+    revoked_at timestamp with time zone
+);
+
+CREATE UNIQUE INDEX api_keys_key_hash_uindex ON public.api_keys USING btree (key_hash);
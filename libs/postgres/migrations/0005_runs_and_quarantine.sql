@@ -0,0 +1,35 @@
+-- One row per scrape processed by core.
+CREATE TABLE public.runs (
+    id serial PRIMARY KEY,
+    uuid character varying NOT NULL,
+    source character varying NOT NULL,
+    status character varying NOT NULL,
+    received_providers_count integer NOT NULL,
+    invalid_providers_count integer NOT NULL,
+    saved_providers_count integer NOT NULL,
+    invalid_reasons jsonb DEFAULT '{}'::jsonb NOT NULL,
+    error character varying DEFAULT '' NOT NULL,
+    started_at timestamp with time zone NOT NULL,
+    finished_at timestamp with time zone NOT NULL
+);
+
+CREATE INDEX runs_source_index ON public.runs USING btree (source, id);
+
+-- Providers core refused to save, waiting for someone to approve or reject
+-- them.
+CREATE TABLE public.quarantined_providers (
+    id serial PRIMARY KEY,
+    run_id integer NOT NULL REFERENCES public.runs(id) ON UPDATE CASCADE ON DELETE CASCADE,
+    source character varying NOT NULL,
+    name character varying NOT NULL,
+    phone character varying NOT NULL,
+    place character varying NOT NULL,
+    link character varying NOT NULL,
+    pics character varying[] NOT NULL,
+    reason character varying NOT NULL,
+    status character varying DEFAULT 'pending' NOT NULL,
+    created_at timestamp with time zone DEFAULT now() NOT NULL,
+    decided_at timestamp with time zone
+);
+
+CREATE INDEX quarantined_providers_run_id_index ON public.quarantined_providers USING btree (run_id);
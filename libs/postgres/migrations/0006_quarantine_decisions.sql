@@ -0,0 +1,19 @@
+-- Approvals and rejections of quarantined providers, keyed by source, name
+-- and phone, so the next scrapes of a decided provider aren't quarantined
+-- again.
+CREATE TABLE public.quarantine_decisions (
+    id serial PRIMARY KEY,
+    source character varying NOT NULL,
+    name character varying NOT NULL,
+    phone character varying NOT NULL,
+    status character varying NOT NULL,
+    decided_at timestamp with time zone DEFAULT now() NOT NULL
+);
+
+CREATE UNIQUE INDEX quarantine_decisions_key_uindex ON public.quarantine_decisions USING btree (source, name, phone);
+
+INSERT INTO public.quarantine_decisions (source, name, phone, status, decided_at)
+SELECT DISTINCT ON (source, name, phone) source, name, phone, status, decided_at
+FROM public.quarantined_providers
+WHERE status <> 'pending' AND decided_at IS NOT NULL
+ORDER BY source, name, phone, decided_at DESC;
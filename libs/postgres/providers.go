@@ -1,6 +1,7 @@
 package postgres
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
 	"log"
@@ -9,77 +10,108 @@ import (
 	"sarasa/schemas"
 )
 
-func (postgres *Client) SaveProvidersList(providers []schemas.Provider, availableZones map[string]int, availableSources map[string]int) error {
-	var err error
+// SaveProvidersList replaces the stored providers of the source of providers
+// and returns what changed compared to the previous save of that source.
+// Providers approved from quarantine since that save are kept, as the
+// scrape may predate the approval.
+func (postgres *Client) SaveProvidersList(ctx context.Context, providers []schemas.Provider, availableZones map[string]int, availableSources map[string]int) ([]schemas.ProviderChange, error) {
+	var changes []schemas.ProviderChange
 
 	log.Printf("Saving %d providers to postgres...\n", len(providers))
 
-	postgres.txn, err = postgres.connection.Begin()
-	if err != nil {
-		return fmt.Errorf("postgressClient/SaveZonesFromProviders - Fail to start a transaction, error: %s", err)
-	}
+	err := postgres.WithTx(ctx, func(tx *sql.Tx) error {
+		sourceID := availableSources[providers[0].Source]
 
-	err = postgres.DeleteProvidersFromSource(availableSources[providers[0].Source])
-	if err != nil {
-		return err
-	}
+		previous, err := postgres.getSourceProviders(ctx, tx, sourceID)
+		if err != nil {
+			return fmt.Errorf("saveProvidersList - Fail to get previous providers, error: %w", err)
+		}
 
-	err = postgres.SaveZonesFromProviders(providers, availableZones)
-	if err != nil {
-		return err
-	}
+		approved, err := postgres.getApprovedSinceSave(ctx, tx, sourceID)
+		if err != nil {
+			return fmt.Errorf("saveProvidersList - Fail to get approved providers, error: %w", err)
+		}
 
-	zonesMap, err := postgres.GetZones()
-	if err != nil {
-		return fmt.Errorf("saveProvidersList - Fail to get zones, error: %s", err)
-	}
+		providers = withApproved(previous, providers, approved)
 
-	err = postgres.SaveSourcesFromProviders(providers, availableSources)
-	if err != nil {
-		return err
-	}
+		err = postgres.SaveZonesFromProviders(ctx, tx, providers, availableZones)
+		if err != nil {
+			return err
+		}
 
-	sourcesMap, err := postgres.GetSources()
-	if err != nil {
-		return fmt.Errorf("saveProvidersList - Fail to get sources, error: %s", err)
-	}
+		zonesMap, err := postgres.getZones(ctx, tx)
+		if err != nil {
+			return fmt.Errorf("saveProvidersList - Fail to get zones, error: %w", err)
+		}
 
-	err = postgres.SaveProviders(providers, zonesMap, sourcesMap)
-	if err != nil {
-		return fmt.Errorf("saveProvidersList - Fail to save providers, error: %s", err)
-	}
+		err = postgres.SaveSourcesFromProviders(ctx, tx, providers, availableSources)
+		if err != nil {
+			return err
+		}
+
+		sourcesMap, err := postgres.getSources(ctx, tx)
+		if err != nil {
+			return fmt.Errorf("saveProvidersList - Fail to get sources, error: %w", err)
+		}
+
+		providerIDs, err := postgres.SaveProviders(ctx, tx, previous, providers, zonesMap, sourcesMap)
+		if err != nil {
+			return fmt.Errorf("saveProvidersList - Fail to save providers, error: %w", err)
+		}
+
+		saved := make([]schemas.Provider, len(providers))
+		for i, provider := range providers {
+			saved[i] = provider
+			saved[i].ID = providerIDs[i]
+		}
+
+		changes = diffProviders(previous, saved)
+
+		err = postgres.markProvidersSeen(ctx, tx, providers, sourcesMap)
+		if err != nil {
+			return fmt.Errorf("saveProvidersList - Fail to mark providers as seen, error: %w", err)
+		}
+
+		_, err = postgres.exec(ctx, tx, "TouchSource",
+			"UPDATE sources SET last_saved_at = now() WHERE id = $1", sourcesMap[providers[0].Source])
+		if err != nil {
+			return fmt.Errorf("saveProvidersList - Fail to update source, error: %w", err)
+		}
 
-	err = postgres.txn.Commit()
+		return nil
+	})
 	if err != nil {
-		return fmt.Errorf("postgressClient/SaveZonesFromProviders - Fail to commit txn, error: %s", err)
+		return nil, err
 	}
 
 	log.Printf("%d providers saved to postgres.\n", len(providers))
-	postgres.txn = nil
 
-	return nil
+	return changes, nil
 }
 
-func (postgres *Client) DeleteProvidersFromSource(sourceID int) error {
-	stmt, err := postgres.txn.Prepare(fmt.Sprintf("DELETE FROM providers WHERE source_id = %d", sourceID))
-	if err != nil {
-		return fmt.Errorf("postgressClient/SaveZonesFromProviders - Fail to prepare CopyIn statement, error: %s", err)
-	}
+// markProvidersSeen records when providers were first seen, in a single
+// statement, keeping the first time of the ones already seen.
+func (postgres *Client) markProvidersSeen(ctx context.Context, tx *sql.Tx, providers []schemas.Provider, sourcesMap map[string]int) error {
+	sourceIDs := make([]int64, len(providers))
+	names := make([]string, len(providers))
+	phones := make([]string, len(providers))
 
-	_, err = stmt.Exec()
-	if err != nil {
-		return fmt.Errorf("postgressClient/SaveZonesFromProviders - Fail to exec(final) CopyIn statement, error: %s", err)
+	for i, provider := range providers {
+		sourceIDs[i] = int64(sourcesMap[provider.Source])
+		names[i] = provider.Name
+		phones[i] = provider.Phone
 	}
 
-	err = stmt.Close()
-	if err != nil {
-		return fmt.Errorf("postgressClient/SaveZonesFromProviders - Fail to close stmt, error: %s", err)
-	}
+	_, err := postgres.exec(ctx, tx, "MarkProvidersSeen", `
+INSERT INTO provider_first_seen (source_id, name, phone)
+SELECT source_id, name, phone FROM unnest($1::integer[], $2::varchar[], $3::varchar[]) AS seen (source_id, name, phone)
+ON CONFLICT (source_id, name, phone) DO NOTHING`,
+		pq.Array(sourceIDs), pq.Array(names), pq.Array(phones))
 
-	return nil
+	return err
 }
 
-func (postgres *Client) SaveZonesFromProviders(providers []schemas.Provider, availableZones map[string]int) error {
+func (postgres *Client) SaveZonesFromProviders(ctx context.Context, tx *sql.Tx, providers []schemas.Provider, availableZones map[string]int) error {
 	zonesMap := make(map[string]bool, 0)
 	for i := 0; i < len(providers); i++ {
 		zonesMap[providers[i].Place] = true
@@ -92,69 +124,37 @@ func (postgres *Client) SaveZonesFromProviders(providers []schemas.Provider, ava
 		}
 	}
 
-	stmt, err := postgres.txn.Prepare(pq.CopyIn("zones", "name"))
+	stmt, err := tx.PrepareContext(ctx, pq.CopyIn("zones", "name"))
 	if err != nil {
-		return fmt.Errorf("postgressClient/SaveZonesFromProviders - Fail to prepare CopyIn statement, error: %s", err)
+		return fmt.Errorf("postgressClient/SaveZonesFromProviders - Fail to prepare CopyIn statement, error: %w", err)
 	}
 
+	defer closeStmt(stmt)
+
 	for _, zone := range zones {
-		_, err := stmt.Exec(zone)
+		_, err := stmt.ExecContext(ctx, zone)
 		if err != nil {
-			return fmt.Errorf("postgressClient/SaveZonesFromProviders - Fail to exec(for) CopyIn statement, error: %s", err)
+			return fmt.Errorf("postgressClient/SaveZonesFromProviders - Fail to exec(for) CopyIn statement, error: %w", err)
 		}
 	}
 
-	_, err = stmt.Exec()
+	_, err = stmt.ExecContext(ctx)
 	if err != nil {
-		return fmt.Errorf("postgressClient/SaveZonesFromProviders - Fail to exec(final) CopyIn statement, error: %s", err)
-	}
-
-	err = stmt.Close()
-	if err != nil {
-		return fmt.Errorf("postgressClient/SaveZonesFromProviders - Fail to close stmt, error: %s", err)
+		return fmt.Errorf("postgressClient/SaveZonesFromProviders - Fail to exec(final) CopyIn statement, error: %w", err)
 	}
 
 	return nil
 }
 
-func (postgres *Client) GetZones() (map[string]int, error) {
-	var stmt *sql.Stmt
-	var err error
-
-	if postgres.txn != nil {
-		stmt, err = postgres.txn.Prepare("SELECT id, name FROM zones")
-	} else {
-		stmt, err = postgres.connection.Prepare("SELECT id, name FROM zones")
-	}
-
-	if err != nil {
-		return nil, err
-	}
-
-	rows, err := stmt.Query()
-	defer func() {
-		if err := rows.Close(); err != nil {
-			log.Printf("Error closing postgres statement rows - error: %s", err)
-		}
-	}()
-
-	zones := make(map[string]int, 0)
-	for rows.Next() {
-		var id int
-		var name string
-
-		err = rows.Scan(&id, &name)
-		if err != nil {
-			return nil, fmt.Errorf("getZones - Fail to scan row, error: %s", err)
-		}
-
-		zones[name] = id
-	}
+func (postgres *Client) GetZones(ctx context.Context) (map[string]int, error) {
+	return postgres.getZones(ctx, nil)
+}
 
-	return zones, nil
+func (postgres *Client) getZones(ctx context.Context, tx *sql.Tx) (map[string]int, error) {
+	return postgres.getNamedIDs(ctx, tx, "GetZones", "SELECT id, name FROM zones")
 }
 
-func (postgres *Client) SaveSourcesFromProviders(providers []schemas.Provider, availableSources map[string]int) error {
+func (postgres *Client) SaveSourcesFromProviders(ctx context.Context, tx *sql.Tx, providers []schemas.Provider, availableSources map[string]int) error {
 	sourcesMap := make(map[string]bool, 0)
 	for i := 0; i < len(providers); i++ {
 		sourcesMap[providers[i].Source] = true
@@ -167,242 +167,224 @@ func (postgres *Client) SaveSourcesFromProviders(providers []schemas.Provider, a
 		}
 	}
 
-	stmt, err := postgres.txn.Prepare(pq.CopyIn("sources", "name"))
+	stmt, err := tx.PrepareContext(ctx, pq.CopyIn("sources", "name"))
 	if err != nil {
-		return fmt.Errorf("postgressClient/SaveSourcesFromProviders - Fail to prepare CopyIn statement, error: %s", err)
+		return fmt.Errorf("postgressClient/SaveSourcesFromProviders - Fail to prepare CopyIn statement, error: %w", err)
 	}
 
+	defer closeStmt(stmt)
+
 	for _, source := range sources {
-		_, err := stmt.Exec(source)
+		_, err := stmt.ExecContext(ctx, source)
 		if err != nil {
-			return fmt.Errorf("postgressClient/SaveSourcesFromProviders - Fail to exec(for) CopyIn statement, error: %s", err)
+			return fmt.Errorf("postgressClient/SaveSourcesFromProviders - Fail to exec(for) CopyIn statement, error: %w", err)
 		}
 	}
 
-	_, err = stmt.Exec()
+	_, err = stmt.ExecContext(ctx)
 	if err != nil {
-		return fmt.Errorf("postgressClient/SaveSourcesFromProviders - Fail to exec(final) CopyIn statement, error: %s", err)
-	}
-
-	err = stmt.Close()
-	if err != nil {
-		return fmt.Errorf("postgressClient/SaveSourcesFromProviders - Fail to close stmt, error: %s", err)
+		return fmt.Errorf("postgressClient/SaveSourcesFromProviders - Fail to exec(final) CopyIn statement, error: %w", err)
 	}
 
 	return nil
 }
 
-func (postgres *Client) GetSources() (map[string]int, error) {
-	var stmt *sql.Stmt
-	var err error
+func (postgres *Client) GetSources(ctx context.Context) (map[string]int, error) {
+	return postgres.getSources(ctx, nil)
+}
 
-	if postgres.txn != nil {
-		stmt, err = postgres.txn.Prepare("SELECT id, name FROM sources")
-	} else {
-		stmt, err = postgres.connection.Prepare("SELECT id, name FROM sources")
+func (postgres *Client) getSources(ctx context.Context, tx *sql.Tx) (map[string]int, error) {
+	return postgres.getNamedIDs(ctx, tx, "GetSources", "SELECT id, name FROM sources")
+}
+
+// getNamedIDs maps the second column of query to the first one.
+func (postgres *Client) getNamedIDs(ctx context.Context, tx *sql.Tx, name, query string, args ...interface{}) (map[string]int, error) {
+	type namedID struct {
+		id   int
+		name string
 	}
 
+	rows, err := queryAll(ctx, postgres, tx, name, query, func(rows *sql.Rows) (namedID, error) {
+		var row namedID
+
+		return row, rows.Scan(&row.id, &row.name)
+	}, args...)
 	if err != nil {
 		return nil, err
 	}
 
-	rows, err := stmt.Query()
-	defer func() {
-		if err := rows.Close(); err != nil {
-			log.Printf("Error closing postgres statement rows - error: %s", err)
-		}
-	}()
-
-	sources := make(map[string]int, 0)
-	for rows.Next() {
-		var id int
-		var name string
-
-		err = rows.Scan(&id, &name)
-		if err != nil {
-			return nil, fmt.Errorf("GetSources - Fail to scan row, error: %s", err)
-		}
-
-		sources[name] = id
+	ids := make(map[string]int, len(rows))
+	for _, row := range rows {
+		ids[row.name] = row.id
 	}
 
-	return sources, nil
+	return ids, nil
 }
 
-func (postgres *Client) SaveProviders(providers []schemas.Provider, zones map[string]int, sources map[string]int) error {
-	stmt, err := postgres.txn.Prepare(pq.CopyIn("providers", "name", "phone", "zone_id", "source_id"))
-	if err != nil {
-		return fmt.Errorf("postgressClient/SaveProviders - Fail to prepare CopyIn statement, error: %s", err)
-	}
-
-	for _, provider := range providers {
-		_, err := stmt.Exec(provider.Name, provider.Phone, zones[provider.Place], sources[provider.Source])
-		if err != nil {
-			return fmt.Errorf("postgressClient/SaveProviders - Fail to exec(for) CopyIn statement, error: %s", err)
+// SaveProviders stores providers, the latest scrape of a source, in place of
+// previous, the ones stored for it with their pics. Providers scraped again,
+// with the same name and phone, keep their id so links and listing cursors
+// stay valid, and are only written, bumping updated_at, when their zone or
+// pics changed. The others are inserted one by one so each pic is linked to
+// the id returned for its own row, phones not being unique. The ids are
+// returned in the order of providers.
+func (postgres *Client) SaveProviders(ctx context.Context, tx *sql.Tx, previous, providers []schemas.Provider, zones map[string]int, sources map[string]int) ([]int, error) {
+	providerIDs := matchProviders(previous, providers)
+	changed := changedProviders(previous, providers, providerIDs)
+
+	var changedIDs, changedZoneIDs, removedIDs []int64
+
+	kept := make(map[int]bool, len(providers))
+	for i, id := range providerIDs {
+		if id == 0 {
+			continue
 		}
-	}
 
-	_, err = stmt.Exec()
-	if err != nil {
-		return fmt.Errorf("postgressClient/SaveProviders - Fail to exec(final) CopyIn statement, error: %s", err)
-	}
-
-	err = stmt.Close()
-	if err != nil {
-		return fmt.Errorf("postgressClient/SaveProviders - Fail to close stmt, error: %s", err)
-	}
-
-	providersPhoneIdMap, err := postgres.GetProvidersPhoneIdMap()
-	if err != nil {
-		return fmt.Errorf("saveProvidersList - Fail to get zones, error: %s", err)
-	}
-
-	stmt, err = postgres.txn.Prepare(pq.CopyIn("provider_pics", "provider_id", "pic_url"))
-	if err != nil {
-		return fmt.Errorf("postgressClient/SaveProviders - Fail to prepare CopyIn statement, error: %s", err)
-	}
+		kept[id] = true
 
-	for _, provider := range providers {
-		for _, pic := range provider.Pics {
-			_, err := stmt.Exec(providersPhoneIdMap[provider.Phone], pic)
-			if err != nil {
-				return fmt.Errorf("postgressClient/SaveProviders - Fail to exec(for) CopyIn statement, error: %s", err)
-			}
+		if changed[i] {
+			changedIDs = append(changedIDs, int64(id))
+			changedZoneIDs = append(changedZoneIDs, int64(zones[providers[i].Place]))
 		}
 	}
 
-	_, err = stmt.Exec()
-	if err != nil {
-		return fmt.Errorf("postgressClient/SaveProviders - Fail to exec(final2) CopyIn statement, error: %s", err)
+	for _, provider := range previous {
+		if !kept[provider.ID] {
+			removedIDs = append(removedIDs, int64(provider.ID))
+		}
 	}
 
-	err = stmt.Close()
-	if err != nil {
-		return fmt.Errorf("postgressClient/SaveProviders - Fail to close stmt, error: %s", err)
+	if len(removedIDs) > 0 {
+		_, err := postgres.exec(ctx, tx, "DeleteProviders",
+			"DELETE FROM providers WHERE id = ANY($1)", pq.Array(removedIDs))
+		if err != nil {
+			return nil, fmt.Errorf("postgressClient/SaveProviders - Fail to delete providers, error: %w", err)
+		}
 	}
 
-	return nil
-}
-
-func (postgres *Client) GetProvidersPhoneIdMap() (map[string]int, error) {
-	var stmt *sql.Stmt
-	var err error
-
-	query := "SELECT id, phone FROM providers"
-	if postgres.txn != nil {
-		stmt, err = postgres.txn.Prepare(query)
-	} else {
-		stmt, err = postgres.connection.Prepare(query)
-	}
+	if len(changedIDs) > 0 {
+		_, err := postgres.exec(ctx, tx, "UpdateProviders", `
+UPDATE providers SET zone_id = changed.zone_id, updated_at = now()
+FROM unnest($1::integer[], $2::integer[]) AS changed (id, zone_id)
+WHERE providers.id = changed.id`,
+			pq.Array(changedIDs), pq.Array(changedZoneIDs))
+		if err != nil {
+			return nil, fmt.Errorf("postgressClient/SaveProviders - Fail to update providers, error: %w", err)
+		}
 
-	if err != nil {
-		return nil, err
+		_, err = postgres.exec(ctx, tx, "DeleteProvidersPics",
+			"DELETE FROM provider_pics WHERE provider_id = ANY($1)", pq.Array(changedIDs))
+		if err != nil {
+			return nil, fmt.Errorf("postgressClient/SaveProviders - Fail to delete pics, error: %w", err)
+		}
 	}
 
-	rows, err := stmt.Query()
-	defer func() {
-		if err := rows.Close(); err != nil {
-			log.Printf("Error closing postgres statement rows - error: %s", err)
+	for i, provider := range providers {
+		if providerIDs[i] != 0 {
+			continue
 		}
-	}()
 
-	providers := make(map[string]int, 0)
-	for rows.Next() {
-		var id int
-		var phone string
-
-		err = rows.Scan(&id, &phone)
+		id, err := postgres.insertProvider(ctx, tx, provider, zones[provider.Place], sources[provider.Source])
 		if err != nil {
-			return nil, fmt.Errorf("GetProviders - Fail to scan row, error: %s", err)
+			return nil, fmt.Errorf("postgressClient/SaveProviders - Fail to insert provider, error: %w", err)
 		}
 
-		providers[phone] = id
+		providerIDs[i] = id
 	}
 
-	return providers, nil
-}
-
-func (postgres *Client) GetProvidersByZone(zoneID int) ([]schemas.Provider, error) {
-	var stmt *sql.Stmt
-	var err error
+	var written []schemas.Provider
+	var writtenIDs []int
 
-	query := fmt.Sprintf("SELECT id, name, phone FROM providers WHERE zone_id = %d", zoneID)
-	if postgres.txn != nil {
-		stmt, err = postgres.txn.Prepare(query)
-	} else {
-		stmt, err = postgres.connection.Prepare(query)
+	for i, provider := range providers {
+		if changed[i] {
+			written = append(written, provider)
+			writtenIDs = append(writtenIDs, providerIDs[i])
+		}
 	}
 
+	stmt, err := tx.PrepareContext(ctx, pq.CopyIn("provider_pics", "provider_id", "pic_url"))
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("postgressClient/SaveProviders - Fail to prepare CopyIn statement, error: %w", err)
 	}
 
-	rows, err := stmt.Query()
-	defer func() {
-		if err := rows.Close(); err != nil {
-			log.Printf("Error closing postgres statement rows - error: %s", err)
-		}
-	}()
-
-	providers := make([]schemas.Provider, 0)
-	for rows.Next() {
-		var id int
-		var name string
-		var phone string
+	defer closeStmt(stmt)
 
-		err = rows.Scan(&id, &name, &phone)
+	for _, pic := range providerPics(written, writtenIDs) {
+		_, err := stmt.ExecContext(ctx, pic.providerID, pic.url)
 		if err != nil {
-			return nil, fmt.Errorf("GetProvidersByZone - Fail to scan row, error: %s", err)
+			return nil, fmt.Errorf("postgressClient/SaveProviders - Fail to exec(for) CopyIn statement, error: %w", err)
 		}
+	}
 
-		providers = append(providers, schemas.Provider{ID: id, Name: name, Phone: phone})
+	_, err = stmt.ExecContext(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("postgressClient/SaveProviders - Fail to exec(final) CopyIn statement, error: %w", err)
 	}
 
-	return providers, nil
+	return providerIDs, nil
 }
 
-// GetProviderPics currently not being used.
-func (postgres *Client) GetProviderPics(providerID int) ([]string, error) {
-	var stmt *sql.Stmt
-	var err error
+func (postgres *Client) insertProvider(ctx context.Context, tx *sql.Tx, provider schemas.Provider, zoneID, sourceID int) (int, error) {
+	ids, err := queryAll(ctx, postgres, tx, "InsertProvider",
+		"INSERT INTO providers (name, phone, zone_id, source_id) VALUES ($1, $2, $3, $4) RETURNING id",
+		func(rows *sql.Rows) (int, error) {
+			var id int
 
-	query := fmt.Sprintf("SELECT pic_url FROM provider_pics WHERE provider_id = %d LIMIT 10", providerID)
-	if postgres.txn != nil {
-		stmt, err = postgres.txn.Prepare(query)
-	} else {
-		stmt, err = postgres.connection.Prepare(query)
+			return id, rows.Scan(&id)
+		},
+		provider.Name, provider.Phone, zoneID, sourceID)
+	if err != nil {
+		return 0, err
 	}
 
-	if err != nil {
-		return nil, err
+	if len(ids) != 1 {
+		return 0, fmt.Errorf("InsertProvider - expected 1 returned id, got %d", len(ids))
 	}
 
-	rows, err := stmt.Query()
-	defer func() {
-		if err := rows.Close(); err != nil {
-			log.Printf("Error closing postgres statement rows - error: %s", err)
-		}
-	}()
+	return ids[0], nil
+}
 
-	pics := make([]string, 0)
-	for rows.Next() {
-		var picURL string
+type providerPic struct {
+	providerID int
+	url        string
+}
 
-		err = rows.Scan(&picURL)
-		if err != nil {
-			return nil, fmt.Errorf("GetProviderPics - Fail to scan row, error: %s", err)
+// providerPics pairs every pic with the id of the provider at the same index.
+func providerPics(providers []schemas.Provider, providerIDs []int) []providerPic {
+	var pics []providerPic
+	for i, provider := range providers {
+		for _, url := range provider.Pics {
+			pics = append(pics, providerPic{providerID: providerIDs[i], url: url})
 		}
-
-		pics = append(pics, picURL)
 	}
 
-	return pics, nil
+	return pics
 }
 
-func (postgres *Client) GetProviders() ([]schemas.Provider, error) {
-	var stmt *sql.Stmt
-	var err error
+func (postgres *Client) GetProvidersByZone(ctx context.Context, zoneID int) ([]schemas.Provider, error) {
+	return queryAll(ctx, postgres, nil, "GetProvidersByZone",
+		"SELECT id, name, phone FROM providers WHERE zone_id = $1",
+		func(rows *sql.Rows) (schemas.Provider, error) {
+			var provider schemas.Provider
 
+			return provider, rows.Scan(&provider.ID, &provider.Name, &provider.Phone)
+		},
+		zoneID)
+}
+
+// GetProviderPics returns up to 10 pics of the provider, oldest first.
+func (postgres *Client) GetProviderPics(ctx context.Context, providerID int) ([]string, error) {
+	return queryAll(ctx, postgres, nil, "GetProviderPics",
+		"SELECT pic_url FROM provider_pics WHERE provider_id = $1 ORDER BY id LIMIT 10",
+		func(rows *sql.Rows) (string, error) {
+			var picURL string
+
+			return picURL, rows.Scan(&picURL)
+		},
+		providerID)
+}
+
+func (postgres *Client) GetProviders(ctx context.Context) ([]schemas.Provider, error) {
 	query := `
 SELECT 
 	providers.id, providers.name, providers.phone, 
@@ -415,69 +397,36 @@ FROM providers
     JOIN provider_pics on providers.id = provider_pics.provider_id
 ORDER BY providers.id, zones.id
 `
-	if postgres.txn != nil {
-		stmt, err = postgres.txn.Prepare(query)
-	} else {
-		stmt, err = postgres.connection.Prepare(query)
+	type providerRow struct {
+		provider schemas.Provider
+		pic      string
 	}
 
+	rows, err := queryAll(ctx, postgres, nil, "GetProviders", query, func(rows *sql.Rows) (providerRow, error) {
+		var row providerRow
+
+		return row, rows.Scan(
+			&row.provider.ID,
+			&row.provider.Name,
+			&row.provider.Phone,
+			&row.provider.Source,
+			&row.provider.Place,
+			&row.pic,
+		)
+	})
 	if err != nil {
 		return nil, err
 	}
 
-	rows, err := stmt.Query()
-	defer func() {
-		if err := rows.Close(); err != nil {
-			log.Printf("Error closing postgres statement rows - error: %s", err)
-		}
-	}()
-	rows.Next()
-
 	providers := make([]schemas.Provider, 0)
-
-	currentProvider := schemas.Provider{}
-	var pic string
-
-	err = rows.Scan(
-		&currentProvider.ID,
-		&currentProvider.Name,
-		&currentProvider.Phone,
-		&currentProvider.Source,
-		&currentProvider.Place,
-		&pic,
-	)
-	if err != nil {
-		return nil, fmt.Errorf("GetProviders - Fail to scan row, error: %s", err)
-	}
-
-	currentProvider.Pics = []string{pic}
-
-	newProvider := schemas.Provider{}
-	for rows.Next() {
-		err = rows.Scan(
-			&newProvider.ID,
-			&newProvider.Name,
-			&newProvider.Phone,
-			&newProvider.Source,
-			&newProvider.Place,
-			&pic,
-		)
-
-		if err != nil {
-			return nil, fmt.Errorf("GetProviders - Fail to scan row, error: %s", err)
-		}
-
-		if currentProvider.ID == newProvider.ID {
-			currentProvider.Pics = append(currentProvider.Pics, pic)
-		} else {
-			providers = append(providers, currentProvider)
-			currentProvider = newProvider
-			currentProvider.Pics = []string{pic}
+	for _, row := range rows {
+		if last := len(providers) - 1; last >= 0 && providers[last].ID == row.provider.ID {
+			providers[last].Pics = append(providers[last].Pics, row.pic)
+			continue
 		}
-	}
 
-	if currentProvider.ID == newProvider.ID {
-		providers = append(providers, currentProvider)
+		row.provider.Pics = []string{row.pic}
+		providers = append(providers, row.provider)
 	}
 
 	return providers, nil
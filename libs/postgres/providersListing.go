@@ -0,0 +1,377 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+	"sarasa/schemas"
+)
+
+const (
+	DefaultProvidersLimit = 50
+	MaxProvidersLimit     = 200
+)
+
+// ErrInvalidFilter is wrapped by every error caused by a bad ProvidersFilter,
+// so callers can tell them apart from database failures.
+var ErrInvalidFilter = errors.New("invalid filter")
+
+// ProvidersFilter narrows and orders a providers listing. Zero values mean
+// "no filter".
+type ProvidersFilter struct {
+	Zone         string
+	Source       string
+	Name         string // case-insensitive substring of the provider name
+	HasPics      *bool
+	UpdatedSince time.Time
+
+	// Sort is one of "id", "name" or "updated", prefixed with "-" for
+	// descending order. Defaults to "id". Ids are kept across saves, so id
+	// and name cursors stay valid; updated_at changes when a save changes the
+	// provider, so an updated cursor may skip or repeat providers changed
+	// meanwhile.
+	Sort   string
+	Cursor string
+	Limit  int
+
+	// SkipPics leaves Pics nil, for callers loading them separately.
+	SkipPics bool
+}
+
+type providersSort struct {
+	column string
+	cast   string
+	value  func(p schemas.Provider) string
+}
+
+var providersSorts = map[string]providersSort{
+	"id": {
+		column: "providers.id",
+		cast:   "integer",
+		value:  func(p schemas.Provider) string { return strconv.Itoa(p.ID) },
+	},
+	"name": {
+		column: "providers.name",
+		cast:   "varchar",
+		value:  func(p schemas.Provider) string { return p.Name },
+	},
+	"updated": {
+		column: "providers.updated_at",
+		cast:   "timestamptz",
+		value:  func(p schemas.Provider) string { return p.UpdatedAt.Format(time.RFC3339Nano) },
+	},
+}
+
+// providersCursor is the keyset position after the last returned provider.
+type providersCursor struct {
+	Sort  string `json:"s"`
+	Value string `json:"v"`
+	ID    int    `json:"id"`
+}
+
+func encodeProvidersCursor(c providersCursor) string {
+	raw, _ := json.Marshal(c)
+
+	return base64.RawURLEncoding.EncodeToString(raw)
+}
+
+func decodeProvidersCursor(cursor string) (providersCursor, error) {
+	var c providersCursor
+
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return c, fmt.Errorf("%w: malformed cursor", ErrInvalidFilter)
+	}
+
+	if err := json.Unmarshal(raw, &c); err != nil {
+		return c, fmt.Errorf("%w: malformed cursor", ErrInvalidFilter)
+	}
+
+	return c, nil
+}
+
+// likeEscaper escapes the LIKE wildcards, with the default backslash escape.
+var likeEscaper = strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`)
+
+// escapeLike makes s match itself literally in a LIKE pattern.
+func escapeLike(s string) string {
+	return likeEscaper.Replace(s)
+}
+
+// ListProviders returns one page of providers matching filter, each with its
+// pics. Only the page is read from the database.
+func (postgres *Client) ListProviders(ctx context.Context, filter ProvidersFilter) (schemas.ProvidersPage, error) {
+	page := schemas.ProvidersPage{Providers: make([]schemas.Provider, 0)}
+
+	sortName := filter.Sort
+	if sortName == "" {
+		sortName = "id"
+	}
+
+	descending := strings.HasPrefix(sortName, "-")
+	sort, ok := providersSorts[strings.TrimPrefix(sortName, "-")]
+	if !ok {
+		return page, fmt.Errorf("%w: unknown sort %q", ErrInvalidFilter, filter.Sort)
+	}
+
+	limit := filter.Limit
+	if limit == 0 {
+		limit = DefaultProvidersLimit
+	}
+
+	if limit < 0 || limit > MaxProvidersLimit {
+		return page, fmt.Errorf("%w: limit must be between 1 and %d", ErrInvalidFilter, MaxProvidersLimit)
+	}
+
+	var conditions []string
+	var args []interface{}
+
+	arg := func(value interface{}) string {
+		args = append(args, value)
+
+		return fmt.Sprintf("$%d", len(args))
+	}
+
+	if filter.Zone != "" {
+		conditions = append(conditions, "zones.name = "+arg(filter.Zone))
+	}
+
+	if filter.Source != "" {
+		conditions = append(conditions, "sources.name = "+arg(filter.Source))
+	}
+
+	if filter.Name != "" {
+		conditions = append(conditions, "providers.name ILIKE '%' || "+arg(escapeLike(filter.Name))+" || '%'")
+	}
+
+	if filter.HasPics != nil {
+		exists := "EXISTS (SELECT 1 FROM provider_pics WHERE provider_pics.provider_id = providers.id)"
+		if !*filter.HasPics {
+			exists = "NOT " + exists
+		}
+
+		conditions = append(conditions, exists)
+	}
+
+	if !filter.UpdatedSince.IsZero() {
+		conditions = append(conditions, "providers.updated_at >= "+arg(filter.UpdatedSince))
+	}
+
+	if filter.Cursor != "" {
+		cursor, err := decodeProvidersCursor(filter.Cursor)
+		if err != nil {
+			return page, err
+		}
+
+		if cursor.Sort != sortName {
+			return page, fmt.Errorf("%w: cursor does not match sort %q", ErrInvalidFilter, sortName)
+		}
+
+		operator := ">"
+		if descending {
+			operator = "<"
+		}
+
+		conditions = append(conditions, fmt.Sprintf("(%s, providers.id) %s (%s::%s, %s)",
+			sort.column, operator, arg(cursor.Value), sort.cast, arg(cursor.ID)))
+	}
+
+	direction := "ASC"
+	if descending {
+		direction = "DESC"
+	}
+
+	query := `
+SELECT
+	providers.id, providers.name, providers.phone,
+	sources.name as source,
+	zones.name as place,
+	providers.updated_at
+FROM providers
+    JOIN sources ON providers.source_id = sources.id
+    JOIN zones ON providers.zone_id = zones.id
+`
+	if len(conditions) > 0 {
+		query += "WHERE " + strings.Join(conditions, "\n    AND ") + "\n"
+	}
+
+	query += fmt.Sprintf("ORDER BY %s %s, providers.id %s\nLIMIT %s", sort.column, direction, direction, arg(limit+1))
+
+	providers, err := queryAll(ctx, postgres, nil, "ListProviders", query, func(rows *sql.Rows) (schemas.Provider, error) {
+		var provider schemas.Provider
+
+		return provider, rows.Scan(
+			&provider.ID,
+			&provider.Name,
+			&provider.Phone,
+			&provider.Source,
+			&provider.Place,
+			&provider.UpdatedAt,
+		)
+	}, args...)
+	if err != nil {
+		return page, err
+	}
+
+	if len(providers) > limit {
+		providers = providers[:limit]
+
+		last := providers[limit-1]
+		page.NextCursor = encodeProvidersCursor(providersCursor{Sort: sortName, Value: sort.value(last), ID: last.ID})
+	}
+
+	if !filter.SkipPics {
+		if err := postgres.attachPics(ctx, nil, providers); err != nil {
+			return page, err
+		}
+	}
+
+	page.Providers = providers
+
+	return page, nil
+}
+
+// attachPics loads the pics of every given provider with a single query.
+func (postgres *Client) attachPics(ctx context.Context, tx *sql.Tx, providers []schemas.Provider) error {
+	if len(providers) == 0 {
+		return nil
+	}
+
+	ids := make([]int, len(providers))
+	for i, provider := range providers {
+		ids[i] = provider.ID
+	}
+
+	pics, err := postgres.getPics(ctx, tx, ids)
+	if err != nil {
+		return err
+	}
+
+	for i, provider := range providers {
+		providers[i].Pics = pics[provider.ID]
+	}
+
+	return nil
+}
+
+// GetPicsByProviders returns the pics of every given provider, keyed by
+// provider id, with a single query. Providers without pics get an empty
+// list.
+func (postgres *Client) GetPicsByProviders(ctx context.Context, providerIDs []int) (map[int][]string, error) {
+	return postgres.getPics(ctx, nil, providerIDs)
+}
+
+func (postgres *Client) getPics(ctx context.Context, tx *sql.Tx, providerIDs []int) (map[int][]string, error) {
+	ids := make([]int64, len(providerIDs))
+	pics := make(map[int][]string, len(providerIDs))
+	for i, id := range providerIDs {
+		ids[i] = int64(id)
+		pics[id] = make([]string, 0)
+	}
+
+	rows, err := queryAll(ctx, postgres, tx, "GetProvidersPics",
+		"SELECT provider_id, pic_url FROM provider_pics WHERE provider_id = ANY($1) ORDER BY provider_id, id",
+		func(rows *sql.Rows) (providerPic, error) {
+			var pic providerPic
+
+			return pic, rows.Scan(&pic.providerID, &pic.url)
+		},
+		pq.Array(ids))
+	if err != nil {
+		return nil, err
+	}
+
+	for _, pic := range rows {
+		pics[pic.providerID] = append(pics[pic.providerID], pic.url)
+	}
+
+	return pics, nil
+}
+
+// ListProvidersByZones returns the first limit providers, by id, of every
+// given zone with a single query. Pics are not loaded. NextCursor is set
+// when a zone has more providers and continues ListProviders filtered by
+// that zone.
+func (postgres *Client) ListProvidersByZones(ctx context.Context, zones []string, limit int) (map[string]schemas.ProvidersPage, error) {
+	return postgres.listProvidersByGroup(ctx, "ListProvidersByZones", "zones.name", zones, limit)
+}
+
+// ListProvidersBySources is ListProvidersByZones for sources.
+func (postgres *Client) ListProvidersBySources(ctx context.Context, sources []string, limit int) (map[string]schemas.ProvidersPage, error) {
+	return postgres.listProvidersByGroup(ctx, "ListProvidersBySources", "sources.name", sources, limit)
+}
+
+type groupedProvider struct {
+	group    string
+	provider schemas.Provider
+}
+
+func (postgres *Client) listProvidersByGroup(ctx context.Context, name, column string, groups []string, limit int) (map[string]schemas.ProvidersPage, error) {
+	if limit <= 0 || limit > MaxProvidersLimit {
+		return nil, fmt.Errorf("%w: limit must be between 1 and %d", ErrInvalidFilter, MaxProvidersLimit)
+	}
+
+	rows, err := queryAll(ctx, postgres, nil, name, `
+SELECT grp, id, name, phone, source, place, updated_at
+FROM (
+    SELECT
+        `+column+` as grp,
+        providers.id, providers.name, providers.phone,
+        sources.name as source,
+        zones.name as place,
+        providers.updated_at,
+        row_number() OVER (PARTITION BY `+column+` ORDER BY providers.id) as position
+    FROM providers
+        JOIN sources ON providers.source_id = sources.id
+        JOIN zones ON providers.zone_id = zones.id
+    WHERE `+column+` = ANY($1)
+) ranked
+WHERE position <= $2
+ORDER BY grp, id
+`,
+		func(rows *sql.Rows) (groupedProvider, error) {
+			var row groupedProvider
+
+			return row, rows.Scan(
+				&row.group,
+				&row.provider.ID,
+				&row.provider.Name,
+				&row.provider.Phone,
+				&row.provider.Source,
+				&row.provider.Place,
+				&row.provider.UpdatedAt,
+			)
+		},
+		pq.Array(groups), limit+1)
+	if err != nil {
+		return nil, err
+	}
+
+	pages := make(map[string]schemas.ProvidersPage, len(groups))
+	for _, group := range groups {
+		pages[group] = schemas.ProvidersPage{Providers: make([]schemas.Provider, 0)}
+	}
+
+	for _, row := range rows {
+		page := pages[row.group]
+
+		if len(page.Providers) == limit {
+			last := page.Providers[limit-1]
+			page.NextCursor = encodeProvidersCursor(providersCursor{Sort: "id", Value: providersSorts["id"].value(last), ID: last.ID})
+		} else {
+			page.Providers = append(page.Providers, row.provider)
+		}
+
+		pages[row.group] = page
+	}
+
+	return pages, nil
+}
@@ -0,0 +1,19 @@
+package postgres
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestEscapeLike(t *testing.T) {
+	for input, expected := range map[string]string{
+		"ana":     "ana",
+		"100%":    `100\%`,
+		"a_b":     `a\_b`,
+		`back\sl`: `back\\sl`,
+		`%_\`:     `\%\_\\`,
+	} {
+		require.Equal(t, expected, escapeLike(input), input)
+	}
+}
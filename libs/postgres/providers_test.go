@@ -0,0 +1,220 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"regexp"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/lib/pq"
+	"github.com/stretchr/testify/require"
+	"sarasa/libs/retryHandling"
+	"sarasa/schemas"
+)
+
+func newMockClient(t *testing.T) (*Client, sqlmock.Sqlmock) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+
+	t.Cleanup(func() { db.Close() })
+
+	client := &Client{}
+	client.Use(db)
+
+	return client, mock
+}
+
+// expectStatement expects query to be prepared for the pool, then on the
+// transaction's connection.
+func expectStatement(mock sqlmock.Sqlmock, query string) string {
+	query = regexp.QuoteMeta(query)
+
+	mock.ExpectPrepare(query)
+	mock.ExpectPrepare(query)
+
+	return query
+}
+
+const updateProvidersQuery = `
+UPDATE providers SET zone_id = changed.zone_id, updated_at = now()
+FROM unnest($1::integer[], $2::integer[]) AS changed (id, zone_id)
+WHERE providers.id = changed.id`
+
+// Providers sharing a phone used to get each other's pics, looked up by
+// phone after the insert. Pics must follow the id of their own provider,
+// whether it's kept from the previous save or returned by its insert.
+func TestSaveProvidersDuplicatePhones(t *testing.T) {
+	client, mock := newMockClient(t)
+
+	previous := []schemas.Provider{
+		{ID: 9, Name: "Dora", Phone: "1177770000"},
+		{ID: 10, Name: "Ana", Phone: "1155550000"},
+	}
+	providers := []schemas.Provider{
+		{Name: "Ana", Phone: "1155550000", Place: "Palermo", Source: "s1", Pics: []string{"ana-1.jpg", "ana-2.jpg"}},
+		{Name: "Bea", Phone: "1155550000", Place: "Palermo", Source: "s1", Pics: []string{"bea-1.jpg"}},
+		{Name: "Cleo", Phone: "1155550000", Place: "Belgrano", Source: "s1", Pics: []string{"cleo-1.jpg"}},
+	}
+	zones := map[string]int{"Palermo": 1, "Belgrano": 2}
+	sources := map[string]int{"s1": 7}
+
+	mock.ExpectBegin()
+
+	deleteProviders := expectStatement(mock, "DELETE FROM providers WHERE id = ANY($1)")
+	mock.ExpectExec(deleteProviders).WithArgs(pq.Array([]int64{9})).WillReturnResult(sqlmock.NewResult(0, 1))
+
+	updateProviders := expectStatement(mock, updateProvidersQuery)
+	mock.ExpectExec(updateProviders).WithArgs(pq.Array([]int64{10}), pq.Array([]int64{1})).WillReturnResult(sqlmock.NewResult(0, 1))
+
+	deletePics := expectStatement(mock, "DELETE FROM provider_pics WHERE provider_id = ANY($1)")
+	mock.ExpectExec(deletePics).WithArgs(pq.Array([]int64{10})).WillReturnResult(sqlmock.NewResult(0, 2))
+
+	insert := expectStatement(mock, "INSERT INTO providers (name, phone, zone_id, source_id) VALUES ($1, $2, $3, $4) RETURNING id")
+	mock.ExpectQuery(insert).WithArgs("Bea", "1155550000", 1, 7).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(11))
+	mock.ExpectQuery(insert).WithArgs("Cleo", "1155550000", 2, 7).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(12))
+
+	copyIn := mock.ExpectPrepare(regexp.QuoteMeta(`COPY "provider_pics" ("provider_id", "pic_url") FROM STDIN`))
+	copyIn.ExpectExec().WithArgs(10, "ana-1.jpg").WillReturnResult(sqlmock.NewResult(0, 1))
+	copyIn.ExpectExec().WithArgs(10, "ana-2.jpg").WillReturnResult(sqlmock.NewResult(0, 1))
+	copyIn.ExpectExec().WithArgs(11, "bea-1.jpg").WillReturnResult(sqlmock.NewResult(0, 1))
+	copyIn.ExpectExec().WithArgs(12, "cleo-1.jpg").WillReturnResult(sqlmock.NewResult(0, 1))
+	copyIn.ExpectExec().WithArgs().WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectCommit()
+
+	ctx := context.Background()
+
+	tx, err := client.connection.BeginTx(ctx, nil)
+	require.NoError(t, err)
+
+	ids, err := client.SaveProviders(ctx, tx, previous, providers, zones, sources)
+	require.NoError(t, err)
+	require.NoError(t, tx.Commit())
+
+	require.Equal(t, []int{10, 11, 12}, ids)
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+// Providers scraped again unchanged keep their row and pics as they are, so
+// their updated_at stays the time they last changed.
+func TestSaveProvidersOnlyWritesChanges(t *testing.T) {
+	client, mock := newMockClient(t)
+
+	previous := []schemas.Provider{
+		{ID: 1, Name: "Ana", Phone: "1", Place: "Palermo", Pics: []string{"ana-1.jpg"}},
+		{ID: 2, Name: "Bea", Phone: "2", Place: "Palermo", Pics: []string{"bea-1.jpg"}},
+		{ID: 3, Name: "Cleo", Phone: "3", Place: "Palermo"},
+	}
+	providers := []schemas.Provider{
+		{Name: "Ana", Phone: "1", Place: "Palermo", Source: "s1", Pics: []string{"ana-1.jpg"}, Link: "https://example.com/ana"},
+		{Name: "Bea", Phone: "2", Place: "Palermo", Source: "s1", Pics: []string{"bea-1.jpg", "bea-2.jpg"}},
+		{Name: "Cleo", Phone: "3", Place: "Belgrano", Source: "s1"},
+	}
+	zones := map[string]int{"Palermo": 1, "Belgrano": 2}
+	sources := map[string]int{"s1": 7}
+
+	require.Equal(t, []bool{false, true, true}, changedProviders(previous, providers, []int{1, 2, 3}))
+
+	mock.ExpectBegin()
+
+	updateProviders := expectStatement(mock, updateProvidersQuery)
+	mock.ExpectExec(updateProviders).WithArgs(pq.Array([]int64{2, 3}), pq.Array([]int64{1, 2})).WillReturnResult(sqlmock.NewResult(0, 2))
+
+	deletePics := expectStatement(mock, "DELETE FROM provider_pics WHERE provider_id = ANY($1)")
+	mock.ExpectExec(deletePics).WithArgs(pq.Array([]int64{2, 3})).WillReturnResult(sqlmock.NewResult(0, 1))
+
+	copyIn := mock.ExpectPrepare(regexp.QuoteMeta(`COPY "provider_pics" ("provider_id", "pic_url") FROM STDIN`))
+	copyIn.ExpectExec().WithArgs(2, "bea-1.jpg").WillReturnResult(sqlmock.NewResult(0, 1))
+	copyIn.ExpectExec().WithArgs(2, "bea-2.jpg").WillReturnResult(sqlmock.NewResult(0, 1))
+	copyIn.ExpectExec().WithArgs().WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectCommit()
+
+	ctx := context.Background()
+
+	tx, err := client.connection.BeginTx(ctx, nil)
+	require.NoError(t, err)
+
+	ids, err := client.SaveProviders(ctx, tx, previous, providers, zones, sources)
+	require.NoError(t, err)
+	require.NoError(t, tx.Commit())
+
+	require.Equal(t, []int{1, 2, 3}, ids)
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestProviderPicsPairsByIndex(t *testing.T) {
+	providers := []schemas.Provider{
+		{Phone: "1155550000", Pics: []string{"a.jpg", "b.jpg"}},
+		{Phone: "1155550000", Pics: []string{"c.jpg"}},
+		{Phone: "1155550000"},
+	}
+
+	require.Equal(t, []providerPic{
+		{providerID: 3, url: "a.jpg"},
+		{providerID: 3, url: "b.jpg"},
+		{providerID: 1, url: "c.jpg"},
+	}, providerPics(providers, []int{3, 1, 2}))
+}
+
+func TestMatchProvidersKeepsIDs(t *testing.T) {
+	previous := []schemas.Provider{
+		{ID: 1, Name: "Ana", Phone: "1"},
+		{ID: 2, Name: "Ana", Phone: "1"},
+		{ID: 3, Name: "Bea", Phone: "1"},
+	}
+	current := []schemas.Provider{
+		{Name: "Bea", Phone: "1"},
+		{Name: "Ana", Phone: "1"},
+		{Name: "Ana", Phone: "1"},
+		{Name: "Ana", Phone: "1"},
+		{Name: "Ana", Phone: "2"},
+	}
+
+	require.Equal(t, []int{3, 1, 2, 0, 0}, matchProviders(previous, current))
+}
+
+// A deadlock inside SaveProviders must stay reachable through the wrapping
+// errors so Retry runs the whole transaction again.
+func TestSaveProvidersRetriesDeadlocks(t *testing.T) {
+	client, mock := newMockClient(t)
+
+	previousPolicy := RetryPolicy
+	RetryPolicy = retryHandling.Policy{MaxAttempts: 2}
+	t.Cleanup(func() { RetryPolicy = previousPolicy })
+
+	previous := []schemas.Provider{{ID: 9, Name: "Dora", Phone: "1177770000"}}
+	providers := []schemas.Provider{{Name: "Ana", Phone: "1155550000", Place: "Palermo", Source: "s1"}}
+	zones := map[string]int{"Palermo": 1}
+	sources := map[string]int{"s1": 7}
+
+	deadlock := &pq.Error{Code: "40P01", Message: "deadlock detected"}
+
+	mock.ExpectBegin()
+	deleteProviders := expectStatement(mock, "DELETE FROM providers WHERE id = ANY($1)")
+	mock.ExpectExec(deleteProviders).WithArgs(pq.Array([]int64{9})).WillReturnError(deadlock)
+	mock.ExpectRollback()
+
+	mock.ExpectBegin()
+	mock.ExpectExec(deleteProviders).WithArgs(pq.Array([]int64{9})).WillReturnResult(sqlmock.NewResult(0, 1))
+	insert := expectStatement(mock, "INSERT INTO providers (name, phone, zone_id, source_id) VALUES ($1, $2, $3, $4) RETURNING id")
+	mock.ExpectQuery(insert).WithArgs("Ana", "1155550000", 1, 7).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(10))
+	copyIn := mock.ExpectPrepare(regexp.QuoteMeta(`COPY "provider_pics" ("provider_id", "pic_url") FROM STDIN`))
+	copyIn.ExpectExec().WithArgs().WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectCommit()
+
+	attempts := 0
+
+	err := client.Retry(context.Background(), func(ctx context.Context) error {
+		attempts++
+
+		return client.WithTx(ctx, func(tx *sql.Tx) error {
+			_, err := client.SaveProviders(ctx, tx, previous, providers, zones, sources)
+
+			return err
+		})
+	})
+	require.NoError(t, err)
+
+	require.Equal(t, 2, attempts)
+	require.NoError(t, mock.ExpectationsWereMet())
+}
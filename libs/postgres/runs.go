@@ -0,0 +1,412 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/lib/pq"
+	"sarasa/schemas"
+)
+
+// ErrAlreadyDecided is returned when approving or rejecting a quarantined
+// provider that is no longer pending.
+var ErrAlreadyDecided = errors.New("already decided")
+
+// ErrNoPlace is returned when approving a quarantined provider without a
+// place, as it couldn't be listed in any zone.
+var ErrNoPlace = errors.New("quarantined provider has no place")
+
+const runColumns = `id, uuid, source, status, received_providers_count, invalid_providers_count,
+	saved_providers_count, invalid_reasons, error, started_at, finished_at`
+
+func scanRun(rows *sql.Rows) (schemas.Run, error) {
+	var run schemas.Run
+	var invalidReasons []byte
+
+	err := rows.Scan(
+		&run.ID,
+		&run.UUID,
+		&run.Source,
+		&run.Status,
+		&run.ReceivedProvidersCount,
+		&run.InvalidProvidersCount,
+		&run.SavedProvidersCount,
+		&invalidReasons,
+		&run.Error,
+		&run.StartedAt,
+		&run.FinishedAt,
+	)
+	if err != nil {
+		return run, err
+	}
+
+	run.DurationMs = run.FinishedAt.Sub(run.StartedAt).Milliseconds()
+
+	return run, json.Unmarshal(invalidReasons, &run.InvalidReasons)
+}
+
+const quarantinedColumns = "id, run_id, source, name, phone, place, link, pics, reason, status, created_at, decided_at"
+
+func scanQuarantined(rows *sql.Rows) (schemas.QuarantinedProvider, error) {
+	var quarantined schemas.QuarantinedProvider
+
+	return quarantined, rows.Scan(
+		&quarantined.ID,
+		&quarantined.RunID,
+		&quarantined.Provider.Source,
+		&quarantined.Provider.Name,
+		&quarantined.Provider.Phone,
+		&quarantined.Provider.Place,
+		&quarantined.Provider.Link,
+		pq.Array(&quarantined.Provider.Pics),
+		&quarantined.Reason,
+		&quarantined.Status,
+		&quarantined.CreatedAt,
+		&quarantined.DecidedAt,
+	)
+}
+
+// SaveRun stores a run along with its quarantined providers and returns the
+// run id.
+func (postgres *Client) SaveRun(ctx context.Context, run schemas.Run) (int, error) {
+	invalidReasons, err := json.Marshal(run.InvalidReasons)
+	if err != nil {
+		return 0, err
+	}
+
+	if run.InvalidReasons == nil {
+		invalidReasons = []byte("{}")
+	}
+
+	var runID int
+
+	err = postgres.WithTx(ctx, func(tx *sql.Tx) error {
+		ids, err := queryAll(ctx, postgres, tx, "SaveRun", `
+INSERT INTO runs (uuid, source, status, received_providers_count, invalid_providers_count,
+	saved_providers_count, invalid_reasons, error, started_at, finished_at)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+RETURNING id`,
+			func(rows *sql.Rows) (int, error) {
+				var id int
+
+				return id, rows.Scan(&id)
+			},
+			run.UUID, run.Source, run.Status, run.ReceivedProvidersCount, run.InvalidProvidersCount,
+			run.SavedProvidersCount, invalidReasons, run.Error, run.StartedAt, run.FinishedAt)
+		if err != nil {
+			return err
+		}
+
+		runID = ids[0]
+
+		for _, quarantined := range run.Quarantined {
+			provider := quarantined.Provider
+			if provider.Pics == nil {
+				provider.Pics = []string{}
+			}
+
+			// Providers decided meanwhile aren't quarantined again.
+			_, err := postgres.exec(ctx, tx, "SaveQuarantined", `
+INSERT INTO quarantined_providers (run_id, source, name, phone, place, link, pics, reason)
+SELECT $1, $2, $3, $4, $5, $6, $7, $8
+WHERE NOT EXISTS (
+    SELECT FROM quarantine_decisions WHERE source = $2 AND name = $3 AND phone = $4
+)`,
+				runID, provider.Source, provider.Name, provider.Phone, provider.Place, provider.Link,
+				pq.Array(provider.Pics), quarantined.Reason)
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+
+	return runID, err
+}
+
+// ListRuns returns the latest runs, newest first, optionally of a single
+// source. Quarantined providers are not included.
+func (postgres *Client) ListRuns(ctx context.Context, source string, limit int) ([]schemas.Run, error) {
+	if source == "" {
+		return queryAll(ctx, postgres, nil, "ListRuns",
+			"SELECT "+runColumns+" FROM runs ORDER BY id DESC LIMIT $1",
+			scanRun,
+			limit)
+	}
+
+	return queryAll(ctx, postgres, nil, "ListSourceRuns",
+		"SELECT "+runColumns+" FROM runs WHERE source = $1 ORDER BY id DESC LIMIT $2",
+		scanRun,
+		source, limit)
+}
+
+// GetRun returns a run with its quarantined providers.
+func (postgres *Client) GetRun(ctx context.Context, runID int) (schemas.Run, error) {
+	runs, err := queryAll(ctx, postgres, nil, "GetRun",
+		"SELECT "+runColumns+" FROM runs WHERE id = $1",
+		scanRun,
+		runID)
+	if err != nil {
+		return schemas.Run{}, err
+	}
+
+	if len(runs) == 0 {
+		return schemas.Run{}, ErrNotFound
+	}
+
+	run := runs[0]
+
+	quarantined, err := postgres.GetQuarantinedByRuns(ctx, []int{runID})
+	run.Quarantined = quarantined[runID]
+
+	return run, err
+}
+
+// ListRunsBySources returns the latest limit runs, newest first, of every
+// given source with a single query. Quarantined providers are not included.
+func (postgres *Client) ListRunsBySources(ctx context.Context, sources []string, limit int) (map[string][]schemas.Run, error) {
+	rows, err := queryAll(ctx, postgres, nil, "ListRunsBySources", `
+SELECT `+runColumns+`
+FROM (
+    SELECT *, row_number() OVER (PARTITION BY source ORDER BY id DESC) as position
+    FROM runs
+    WHERE source = ANY($1)
+) ranked
+WHERE position <= $2
+ORDER BY source, id DESC
+`,
+		scanRun,
+		pq.Array(sources), limit)
+	if err != nil {
+		return nil, err
+	}
+
+	runs := make(map[string][]schemas.Run, len(sources))
+	for _, source := range sources {
+		runs[source] = make([]schemas.Run, 0)
+	}
+
+	for _, run := range rows {
+		runs[run.Source] = append(runs[run.Source], run)
+	}
+
+	return runs, nil
+}
+
+// GetQuarantinedByRuns returns the quarantined providers of every given run,
+// keyed by run id, with a single query.
+func (postgres *Client) GetQuarantinedByRuns(ctx context.Context, runIDs []int) (map[int][]schemas.QuarantinedProvider, error) {
+	ids := make([]int64, len(runIDs))
+	quarantined := make(map[int][]schemas.QuarantinedProvider, len(runIDs))
+	for i, id := range runIDs {
+		ids[i] = int64(id)
+		quarantined[id] = make([]schemas.QuarantinedProvider, 0)
+	}
+
+	rows, err := queryAll(ctx, postgres, nil, "GetQuarantinedByRuns",
+		"SELECT "+quarantinedColumns+" FROM quarantined_providers WHERE run_id = ANY($1) ORDER BY run_id, id",
+		scanQuarantined,
+		pq.Array(ids))
+	if err != nil {
+		return nil, err
+	}
+
+	for _, q := range rows {
+		quarantined[q.RunID] = append(quarantined[q.RunID], q)
+	}
+
+	return quarantined, nil
+}
+
+// ApproveQuarantined stores a quarantined provider as a regular one and
+// remembers the decision, so the next scrapes of it are saved despite being
+// invalid. Providers without a place can't be listed and are only rejected.
+func (postgres *Client) ApproveQuarantined(ctx context.Context, quarantinedID int) (schemas.Provider, error) {
+	var provider schemas.Provider
+
+	err := postgres.WithTx(ctx, func(tx *sql.Tx) error {
+		quarantined, err := postgres.lockPendingQuarantined(ctx, tx, quarantinedID)
+		if err != nil {
+			return err
+		}
+
+		provider = quarantined.Provider
+
+		if provider.Place == "" {
+			return ErrNoPlace
+		}
+
+		zoneID, err := postgres.ensureNamedID(ctx, tx, "zones", provider.Place)
+		if err != nil {
+			return err
+		}
+
+		sourceID, err := postgres.ensureNamedID(ctx, tx, "sources", provider.Source)
+		if err != nil {
+			return err
+		}
+
+		provider.ID, err = postgres.insertProvider(ctx, tx, provider, zoneID, sourceID)
+		if err != nil {
+			return err
+		}
+
+		for _, pic := range provider.Pics {
+			_, err := postgres.exec(ctx, tx, "InsertProviderPic",
+				"INSERT INTO provider_pics (provider_id, pic_url) VALUES ($1, $2)", provider.ID, pic)
+			if err != nil {
+				return err
+			}
+		}
+
+		err = postgres.markProvidersSeen(ctx, tx, []schemas.Provider{provider}, map[string]int{provider.Source: sourceID})
+		if err != nil {
+			return err
+		}
+
+		return postgres.decideQuarantined(ctx, tx, quarantined, schemas.QuarantineApproved)
+	})
+
+	return provider, err
+}
+
+// RejectQuarantined marks a quarantined provider as rejected and remembers
+// the decision, so the next scrapes of it aren't quarantined again.
+func (postgres *Client) RejectQuarantined(ctx context.Context, quarantinedID int) error {
+	return postgres.WithTx(ctx, func(tx *sql.Tx) error {
+		quarantined, err := postgres.lockPendingQuarantined(ctx, tx, quarantinedID)
+		if err != nil {
+			return err
+		}
+
+		return postgres.decideQuarantined(ctx, tx, quarantined, schemas.QuarantineRejected)
+	})
+}
+
+func (postgres *Client) lockPendingQuarantined(ctx context.Context, tx *sql.Tx, quarantinedID int) (schemas.QuarantinedProvider, error) {
+	quarantined, err := queryAll(ctx, postgres, tx, "LockQuarantined",
+		"SELECT "+quarantinedColumns+" FROM quarantined_providers WHERE id = $1 FOR UPDATE",
+		scanQuarantined,
+		quarantinedID)
+	if err != nil {
+		return schemas.QuarantinedProvider{}, err
+	}
+
+	if len(quarantined) == 0 {
+		return schemas.QuarantinedProvider{}, ErrNotFound
+	}
+
+	if quarantined[0].Status != schemas.QuarantinePending {
+		return quarantined[0], fmt.Errorf("%w: quarantined provider is %s", ErrAlreadyDecided, quarantined[0].Status)
+	}
+
+	return quarantined[0], nil
+}
+
+// decideQuarantined stores the decision for the provider of quarantined and
+// applies it to every pending quarantined row of that provider.
+func (postgres *Client) decideQuarantined(ctx context.Context, tx *sql.Tx, quarantined schemas.QuarantinedProvider, status string) error {
+	provider := quarantined.Provider
+
+	_, err := postgres.exec(ctx, tx, "SaveQuarantineDecision", `
+INSERT INTO quarantine_decisions (source, name, phone, status) VALUES ($1, $2, $3, $4)
+ON CONFLICT (source, name, phone) DO UPDATE SET status = excluded.status, decided_at = now()`,
+		provider.Source, provider.Name, provider.Phone, status)
+	if err != nil {
+		return err
+	}
+
+	_, err = postgres.exec(ctx, tx, "DecideQuarantined", `
+UPDATE quarantined_providers SET status = $4, decided_at = now()
+WHERE source = $1 AND name = $2 AND phone = $3 AND status = 'pending'`,
+		provider.Source, provider.Name, provider.Phone, status)
+
+	return err
+}
+
+// QuarantineDecisions holds the decided status of the quarantined providers
+// of a source.
+type QuarantineDecisions map[string]string
+
+// Of returns the decided status of provider, empty when there is none.
+func (decisions QuarantineDecisions) Of(provider schemas.Provider) string {
+	return decisions[providerKey(provider)]
+}
+
+// GetQuarantineDecisions returns the decisions taken on quarantined
+// providers of source.
+func (postgres *Client) GetQuarantineDecisions(ctx context.Context, source string) (QuarantineDecisions, error) {
+	type decision struct {
+		provider schemas.Provider
+		status   string
+	}
+
+	rows, err := queryAll(ctx, postgres, nil, "GetQuarantineDecisions",
+		"SELECT name, phone, status FROM quarantine_decisions WHERE source = $1",
+		func(rows *sql.Rows) (decision, error) {
+			var row decision
+
+			return row, rows.Scan(&row.provider.Name, &row.provider.Phone, &row.status)
+		},
+		source)
+	if err != nil {
+		return nil, err
+	}
+
+	decisions := make(QuarantineDecisions, len(rows))
+	for _, row := range rows {
+		decisions[providerKey(row.provider)] = row.status
+	}
+
+	return decisions, nil
+}
+
+// getApprovedSinceSave returns the keys of the providers of source approved
+// after its last save, which the providers being saved may predate.
+func (postgres *Client) getApprovedSinceSave(ctx context.Context, tx *sql.Tx, sourceID int) (map[string]bool, error) {
+	providers, err := queryAll(ctx, postgres, tx, "GetApprovedSinceSave", `
+SELECT quarantine_decisions.name, quarantine_decisions.phone
+FROM quarantine_decisions
+    JOIN sources ON quarantine_decisions.source = sources.name
+WHERE sources.id = $1
+    AND quarantine_decisions.status = 'approved'
+    AND (sources.last_saved_at IS NULL OR quarantine_decisions.decided_at > sources.last_saved_at)
+`,
+		func(rows *sql.Rows) (schemas.Provider, error) {
+			var provider schemas.Provider
+
+			return provider, rows.Scan(&provider.Name, &provider.Phone)
+		},
+		sourceID)
+	if err != nil {
+		return nil, err
+	}
+
+	approved := make(map[string]bool, len(providers))
+	for _, provider := range providers {
+		approved[providerKey(provider)] = true
+	}
+
+	return approved, nil
+}
+
+// ensureNamedID returns the id of the row of table (zones or sources) with
+// the given name, inserting it when missing.
+func (postgres *Client) ensureNamedID(ctx context.Context, tx *sql.Tx, table, name string) (int, error) {
+	_, err := postgres.exec(ctx, tx, "Ensure"+table,
+		"INSERT INTO "+table+" (name) VALUES ($1) ON CONFLICT (name) DO NOTHING", name)
+	if err != nil {
+		return 0, err
+	}
+
+	ids, err := postgres.getNamedIDs(ctx, tx, "Get"+table, "SELECT id, name FROM "+table+" WHERE name = $1", name)
+	if err != nil {
+		return 0, err
+	}
+
+	return ids[name], nil
+}
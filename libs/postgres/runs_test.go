@@ -0,0 +1,101 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/lib/pq"
+	"github.com/stretchr/testify/require"
+	"sarasa/libs/errorHandling"
+	"sarasa/schemas"
+)
+
+var quarantinedColumnNames = []string{
+	"id", "run_id", "source", "name", "phone", "place", "link", "pics", "reason", "status", "created_at", "decided_at",
+}
+
+const lockQuarantinedQuery = "SELECT " + quarantinedColumns + " FROM quarantined_providers WHERE id = $1 FOR UPDATE"
+
+// Rejecting stores the decision and applies it to the other pending rows of
+// the same provider, quarantined by other runs.
+func TestRejectQuarantinedStoresTheDecision(t *testing.T) {
+	client, mock := newMockClient(t)
+
+	mock.ExpectBegin()
+	lock := expectStatement(mock, lockQuarantinedQuery)
+	mock.ExpectQuery(lock).WithArgs(3).WillReturnRows(sqlmock.NewRows(quarantinedColumnNames).
+		AddRow(3, 1, "s1", "Ana", "11", "Palermo", "", pq.Array([]string{}), "phone.length != 10", "pending", time.Now(), nil))
+
+	decision := expectStatement(mock, `
+INSERT INTO quarantine_decisions (source, name, phone, status) VALUES ($1, $2, $3, $4)
+ON CONFLICT (source, name, phone) DO UPDATE SET status = excluded.status, decided_at = now()`)
+	mock.ExpectExec(decision).WithArgs("s1", "Ana", "11", "rejected").WillReturnResult(sqlmock.NewResult(1, 1))
+
+	decide := expectStatement(mock, `
+UPDATE quarantined_providers SET status = $4, decided_at = now()
+WHERE source = $1 AND name = $2 AND phone = $3 AND status = 'pending'`)
+	mock.ExpectExec(decide).WithArgs("s1", "Ana", "11", "rejected").WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectCommit()
+
+	require.NoError(t, client.RejectQuarantined(context.Background(), 3))
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+// A provider without a place would be listed in an empty-named zone.
+func TestApproveQuarantinedRequiresAPlace(t *testing.T) {
+	client, mock := newMockClient(t)
+
+	mock.ExpectBegin()
+	lock := expectStatement(mock, lockQuarantinedQuery)
+	mock.ExpectQuery(lock).WithArgs(3).WillReturnRows(sqlmock.NewRows(quarantinedColumnNames).
+		AddRow(3, 1, "s1", "Ana", "1155550000", "", "", pq.Array([]string{"a.jpg"}), "place empty", "pending", time.Now(), nil))
+	mock.ExpectRollback()
+
+	_, err := client.ApproveQuarantined(context.Background(), 3)
+	require.True(t, errors.Is(err, ErrNoPlace))
+	require.Equal(t, errorHandling.InvalidInput, errorHandling.CategoryOf(err))
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestQuarantineDecisionsOf(t *testing.T) {
+	client, mock := newMockClient(t)
+
+	query := regexp.QuoteMeta("SELECT name, phone, status FROM quarantine_decisions WHERE source = $1")
+	mock.ExpectPrepare(query)
+	mock.ExpectQuery(query).WithArgs("s1").WillReturnRows(sqlmock.NewRows([]string{"name", "phone", "status"}).
+		AddRow("Ana", "11", "approved").
+		AddRow("Bea", "11", "rejected"))
+
+	decisions, err := client.GetQuarantineDecisions(context.Background(), "s1")
+	require.NoError(t, err)
+
+	require.Equal(t, schemas.QuarantineApproved, decisions.Of(schemas.Provider{Name: "Ana", Phone: "11"}))
+	require.Equal(t, schemas.QuarantineRejected, decisions.Of(schemas.Provider{Name: "Bea", Phone: "11"}))
+	require.Empty(t, decisions.Of(schemas.Provider{Name: "Ana", Phone: "12"}))
+	require.Empty(t, QuarantineDecisions(nil).Of(schemas.Provider{Name: "Ana", Phone: "11"}))
+}
+
+func TestWithApprovedKeepsApprovedProviders(t *testing.T) {
+	previous := []schemas.Provider{
+		{ID: 1, Name: "Ana", Phone: "11", Place: "Palermo"},
+		{ID: 2, Name: "Bea", Phone: "11", Place: "Palermo"},
+		{ID: 3, Name: "Cleo", Phone: "11", Place: "Belgrano"},
+	}
+	current := make([]schemas.Provider, 1, 4)
+	current[0] = schemas.Provider{Name: "Ana", Phone: "11", Place: "Belgrano"}
+
+	approved := map[string]bool{
+		providerKey(previous[0]): true,
+		providerKey(previous[2]): true,
+	}
+
+	kept := withApproved(previous, current, approved)
+
+	require.Equal(t, []schemas.Provider{current[0], previous[2]}, kept)
+	require.Empty(t, current[:2][1].Name, "the caller's backing array was written")
+	require.Equal(t, current, withApproved(previous, current, nil))
+}
@@ -1,21 +1,13 @@
 package providersCommon
 
 import (
-	"fmt"
 	"log"
-	"net/http"
 
-	"github.com/PuerkitoBio/goquery"
 	"sarasa/schemas"
 )
 
 func GetDetails(providerLink string, source schemas.Source, getDetailsFn CustomGetDetailsFn) (schemas.Provider, error) {
-	resp, err := http.Get(providerLink)
-	if err != nil {
-		fmt.Printf("Error on request: %s", err)
-	}
-
-	doc, err := goquery.NewDocumentFromReader(resp.Body)
+	doc, err := fetchDocument(providerLink)
 	if err != nil {
 		return schemas.Provider{}, err
 	}
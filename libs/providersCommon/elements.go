@@ -2,7 +2,6 @@ package providersCommon
 
 import (
 	"log"
-	"net/http"
 
 	"github.com/PuerkitoBio/goquery"
 	"sarasa/schemas"
@@ -11,12 +10,7 @@ import (
 type getElementLinkFn func(s *goquery.Selection) string
 
 func GetElements(url, selector string, limit int, getElementLinkFn getElementLinkFn, providerDetailsLink chan string, results chan schemas.Provider) ([]schemas.Provider, error) {
-	resp, err := http.Get(url)
-	if err != nil {
-		return nil, err
-	}
-
-	doc, err := goquery.NewDocumentFromReader(resp.Body)
+	doc, err := fetchDocument(url)
 	if err != nil {
 		return nil, err
 	}
@@ -0,0 +1,92 @@
+package providersCommon
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"sarasa/libs/circuitBreaker"
+	"sarasa/libs/errorHandling"
+	"sarasa/libs/retryHandling"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+// FetchPolicy retries scraping requests failing on network errors, rate
+// limiting or server errors.
+var FetchPolicy = retryHandling.Policy{
+	MaxAttempts:  4,
+	MaxElapsed:   time.Minute,
+	InitialDelay: time.Second,
+	MaxDelay:     15 * time.Second,
+	Jitter:       0.3,
+	OnRetry:      retryHandling.LogRetry,
+}
+
+// sourceBreakers has one circuit breaker per scraped host, so a site that is
+// down fails fast instead of tying up the workers pool.
+var sourceBreakers = circuitBreaker.NewRegistry(circuitBreaker.Settings{
+	FailureThreshold: 5,
+	OpenTimeout:      2 * time.Minute,
+})
+
+// sourceBreaker returns the breaker of rawURL's host.
+func sourceBreaker(rawURL string) *circuitBreaker.Breaker {
+	host := rawURL
+	if u, err := url.Parse(rawURL); err == nil && u.Host != "" {
+		host = u.Host
+	}
+
+	return sourceBreakers.Get(host)
+}
+
+// fetchDocument gets and parses a page with FetchPolicy, through the host's
+// circuit breaker. Requests are aborted when the drain deadline passes on
+// shutdown.
+func fetchDocument(url string) (*goquery.Document, error) {
+	var doc *goquery.Document
+
+	breaker := sourceBreaker(url)
+
+	err := FetchPolicy.Do(lifecycleManager.WorkContext(), func(ctx context.Context) error {
+		return breaker.Do(func() error {
+			var err error
+			doc, err = getDocument(ctx, url)
+
+			return err
+		})
+	})
+
+	return doc, err
+}
+
+func getDocument(ctx context.Context, url string) (*goquery.Document, error) {
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, errorHandling.NewPermanent(err)
+	}
+
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		return nil, errorHandling.Wrapf(errorHandling.DependencyDown, err, "providersCommon/fetchDocument - Fail to get %s", url)
+	}
+
+	defer response.Body.Close()
+
+	if response.StatusCode == http.StatusTooManyRequests || response.StatusCode >= http.StatusInternalServerError {
+		return nil, errorHandling.NewTransient(fmt.Errorf("providersCommon/fetchDocument - Fail to get %s, error: status %d", url, response.StatusCode))
+	}
+
+	if response.StatusCode != http.StatusOK {
+		return nil, errorHandling.NewPermanent(fmt.Errorf("providersCommon/fetchDocument - Fail to get %s, error: status %d", url, response.StatusCode))
+	}
+
+	doc, err := goquery.NewDocumentFromReader(response.Body)
+	if err != nil {
+		return nil, errorHandling.Wrapf(errorHandling.Transient, err, "providersCommon/fetchDocument - Fail to read %s", url)
+	}
+
+	return doc, nil
+}
@@ -1,11 +1,14 @@
 package providersCommon
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"log"
 	"time"
 
-	"sarasa/libs/signals"
+	"sarasa/libs/circuitBreaker"
+	"sarasa/libs/lifecycle"
 
 	"sarasa/libs/configHandling"
 
@@ -23,10 +26,29 @@ var configuration schemas.Config
 
 var influxSingleton influxdb.Client
 var rabbitMQSingleton rabbitMQ.Client
+var healthSingleton lifecycle.Health
+var lifecycleManager = lifecycle.NewManager(lifecycle.DefaultDrainTimeout)
+
+// lastRun beats every time a scrape is published.
+var lastRun lifecycle.Heartbeat
+
+// processUUID tags every provider_process point of this process.
+var processUUID = uuid.New().String()
+
+// consumerDone is closed when the refresh consumer stops.
+var consumerDone = make(chan struct{})
 
 var providerDetailsLink chan string
 var results chan schemas.Provider
 
+// workerStops holds one channel per running scrap worker, closed to stop it
+// when the pool shrinks.
+var workerStops []chan struct{}
+
+// configUpdates carries the configurations received from the config server
+// to the refresh consumer, which applies them between refreshes.
+var configUpdates = make(chan schemas.Config, 1)
+
 type CustomGetDetailsFn func(doc *goquery.Document, source schemas.Source) schemas.Provider
 type CustomGetDetailsLinkFn func(s *goquery.Selection) string
 
@@ -37,12 +59,23 @@ type ProviderProcessor struct {
 	CustomGetDetailsLinkFn
 }
 
-func (pd ProviderProcessor) scrapWorker() {
-	for link := range providerDetailsLink {
+func (pd ProviderProcessor) scrapWorker(stop <-chan struct{}) {
+	for {
+		var link string
+
+		select {
+		case <-stop:
+			return
+		case link = <-providerDetailsLink:
+		}
+
 		details, err := GetDetails(link, configuration.Provider.Source, pd.CustomGetDetailsFn)
 
+		// GetElements waits for one result per link, so a failed scrape still
+		// sends an empty provider, which core skips.
 		if err != nil {
-			log.Printf("Failed getting details - error: %s", err)
+			errorHandling.Report(err, "Failed getting details of "+link)
+			results <- schemas.Provider{}
 			continue
 		}
 
@@ -52,28 +85,101 @@ func (pd ProviderProcessor) scrapWorker() {
 	}
 }
 
+// resizeWorkers starts or stops scrap workers until count are running.
+func (pd ProviderProcessor) resizeWorkers(count int) {
+	for len(workerStops) < count {
+		stop := make(chan struct{})
+		workerStops = append(workerStops, stop)
+
+		go pd.scrapWorker(stop)
+	}
+
+	for len(workerStops) > count {
+		close(workerStops[len(workerStops)-1])
+		workerStops = workerStops[:len(workerStops)-1]
+	}
+}
+
+// selector returns the listing elements selector, the configured one when
+// set.
+func (pd ProviderProcessor) selector() string {
+	if configuration.Provider.Selector != "" {
+		return configuration.Provider.Selector
+	}
+
+	return pd.Selector
+}
+
+// applyConfig applies the provider settings of a new configuration. The
+// clients' settings need a restart and are left as they are.
+func (pd ProviderProcessor) applyConfig(c schemas.Config) {
+	previous := configuration.Provider
+	configuration.Provider = c.Provider
+
+	if previous.ScrapWorkersCount != c.Provider.ScrapWorkersCount {
+		log.Printf("Scrap workers: %d -> %d", len(workerStops), c.Provider.ScrapWorkersCount)
+		pd.resizeWorkers(c.Provider.ScrapWorkersCount)
+	}
+
+	if previous.Selector != c.Provider.Selector {
+		log.Printf("Selector: %q", pd.selector())
+	}
+
+	if c.RabbitMQ != configuration.RabbitMQ || c.Influx != configuration.Influx || c.Health != configuration.Health {
+		log.Printf("Clients configuration changed, restart %s to apply it", pd.ServiceName)
+	}
+}
+
 func (pd ProviderProcessor) initialize() {
 	errorHandling.FailOnError(
-		configHandling.LoadConfig(&configuration, pd.ServiceName), "Could not get configuration")
-
-	log.Printf("Configuration fetched %v", configuration)
+		configHandling.LoadConfig(&configuration, pd.ServiceName,
+			configHandling.Health, configHandling.Influx, configHandling.RabbitMQ, configHandling.Provider),
+		"Could not get configuration")
 
 	/**
 	 * RabbitMQ Singleton
 	 */
 	errorHandling.FailOnError(
 		rabbitMQSingleton.Init(configuration.RabbitMQ), "Could not initialize rabbitMQSingleton")
+	lifecycleManager.AddService(&rabbitMQSingleton)
 
 	/**
 	 * InfluxDB Singleton
 	 */
 	errorHandling.LogOnError(
 		influxSingleton.Init(configuration.Influx), "Could not initialize InfluxSingleton")
+	lifecycleManager.AddService(&influxSingleton)
+
+	influxSingleton.ObserveService(pd.ServiceName)
+
+	/**
+	 * Health checks
+	 */
+	healthSingleton.AddReadinessCheck("lifecycle", lifecycleManager.ReadyCheck)
+	healthSingleton.AddReadinessCheck("config", configHandling.LoadedCheck)
+	healthSingleton.AddReadinessCheck("rabbitMQ", lifecycle.ErrorCheck(rabbitMQSingleton.Ping))
+	healthSingleton.AddOptionalCheck("influxDB", lifecycle.ErrorCheck(influxSingleton.Ping))
+	healthSingleton.AddOptionalCheck("lastRun", lastRun.Check(0))
+	healthSingleton.AddOptionalCheck("sourceBreakers", sourceBreakers.HealthCheck)
+	healthSingleton.AddOptionalCheck("influxDBBreaker", influxSingleton.Breaker().HealthCheck)
+	healthSingleton.AddLivenessCheck("consumer", func(ctx context.Context) (string, error) {
+		select {
+		case <-consumerDone:
+			return "", errors.New("refresh consumer stopped")
+		default:
+			return "", nil
+		}
+	})
+
+	go func() {
+		errorHandling.LogOnError(healthSingleton.Serve(configuration.Health), "Health server stopped")
+	}()
+	lifecycleManager.AddService(&healthSingleton)
 
 	/**
 	 * Signal handling
 	 */
-	signals.SignalHandler(rabbitMQSingleton, influxSingleton)
+	lifecycleManager.HandleSignals()
 
 	/*
 	 * Workers pool
@@ -81,29 +187,27 @@ func (pd ProviderProcessor) initialize() {
 	providerDetailsLink = make(chan string, 200)
 	results = make(chan schemas.Provider, 200)
 
-	for w := 1; w <= configuration.Provider.ScrapWorkersCount; w++ {
-		go pd.scrapWorker()
-	}
+	pd.resizeWorkers(configuration.Provider.ScrapWorkersCount)
+
+	/**
+	 * Configuration changes
+	 */
+	lifecycleManager.Go(func(ctx context.Context) {
+		configHandling.Watch(ctx, pd.ServiceName, func(c schemas.Config) {
+			select {
+			case <-configUpdates:
+			default:
+			}
+
+			configUpdates <- c
+		})
+	})
 }
 
 func (pd ProviderProcessor) Run() {
 
 	pd.initialize()
 
-	defer func() {
-		errorHandling.LogOnError(
-			rabbitMQSingleton.Connection.Close(), "Error closing rabbitmq connection - error: %s")
-	}()
-	defer func() {
-		errorHandling.LogOnError(
-			rabbitMQSingleton.Channel.Close(), "Error closing rabbitmq channel")
-	}()
-
-	var influxTags map[string]string
-	var influxFields map[string]interface{}
-
-	influxTags = map[string]string{"run_uuid": uuid.New().String(), "source": configuration.Provider.Source.Url}
-
 	/**
 	 * Declaring queue to produce
 	 */
@@ -128,51 +232,171 @@ func (pd ProviderProcessor) Run() {
 		"Failed to bind queue")
 
 	refreshQueueMessages, err := rabbitMQSingleton.Channel.Consume(
-		refreshQueue.Name, "", true, false, false, false, nil,
+		refreshQueue.Name, pd.ServiceName, true, false, false, false, nil,
 	)
 	errorHandling.FailOnError(err, "Failed to create consumer")
 
-	forever := make(chan bool)
-
-	go func() {
-		for range refreshQueueMessages {
-			startTime := time.Now()
-
-			providers, err := GetElements(
-				configuration.Provider.Source.Url, pd.Selector,
-				configuration.Provider.ProvidersCount,
-				func(s *goquery.Selection) string {
-					return pd.CustomGetDetailsLinkFn(s)
-				},
-				providerDetailsLink, results)
-			errorHandling.FailOnError(err, "Failed to get providers")
-
-			body, err := json.Marshal(providers)
-			errorHandling.FailOnError(err, "Failed to marshal providers")
-
-			errorHandling.FailOnError(
-				rabbitMQSingleton.Channel.Publish(
-					"", providersQueue.Name, false, false, amqp.Publishing{
-						ContentType: "text/json",
-						Body:        body,
-					}), "Failed to publish provider message")
-
-			providersCount := len(providers)
-
-			influxFields = map[string]interface{}{
-				"elapsed":      time.Since(startTime).Milliseconds(),
-				"providerSent": providersCount,
-				"success":      true,
+	lifecycleManager.AddStopper("refresh consumer", func(ctx context.Context) error {
+		return rabbitMQSingleton.Channel.Cancel(pd.ServiceName, false)
+	})
+
+	// A scrape in progress when the consumer is cancelled is finished and
+	// published, within the drain deadline. Configuration changes are applied
+	// between refreshes.
+	lifecycleManager.Go(func(ctx context.Context) {
+		defer close(consumerDone)
+
+		for {
+			select {
+			case d, ok := <-refreshQueueMessages:
+				if !ok {
+					return
+				}
+
+				pd.refresh(ctx, providersQueue.Name, d)
+			case c := <-configUpdates:
+				pd.applyConfig(c)
 			}
-
-			go errorHandling.LogOnError(
-				influxSingleton.Send("provider_process", influxTags, influxFields),
-				"Could not write to InfluxDB")
-
-			log.Printf("End. Got %d providers in %s\n", providersCount, time.Since(startTime))
 		}
-	}()
+	})
 
 	log.Printf("Waiting for messages. To exit press CTRL+C")
-	<-forever
+	lifecycleManager.Wait()
+}
+
+// refresh scrapes the source for a refresh request and publishes the
+// providers to queue.
+func (pd ProviderProcessor) refresh(ctx context.Context, queue string, d amqp.Delivery) {
+	if !isRefreshForSource(d.Body, configuration.Provider.Source.Url) {
+		return
+	}
+
+	startTime := time.Now()
+
+	// While the source is down refreshes aren't scraped, core records
+	// them as unavailable runs instead.
+	if _, err := sourceBreaker(configuration.Provider.Source.Url).HealthCheck(ctx); err != nil {
+		pd.shortCircuit(queue, pd.influxTags(), startTime, err)
+		return
+	}
+
+	providers, err := GetElements(
+		configuration.Provider.Source.Url, pd.selector(),
+		configuration.Provider.ProvidersCount,
+		func(s *goquery.Selection) string {
+			return pd.CustomGetDetailsLinkFn(s)
+		},
+		providerDetailsLink, results)
+	if errors.Is(err, circuitBreaker.ErrOpen) {
+		pd.shortCircuit(queue, pd.influxTags(), startTime, err)
+		return
+	}
+
+	if err != nil {
+		pd.reportFailure(pd.influxTags(), startTime, err, "Failed to get providers")
+		return
+	}
+
+	body, err := json.Marshal(providers)
+	if err != nil {
+		pd.reportFailure(pd.influxTags(), startTime, errorHandling.NewPermanent(err), "Failed to marshal providers")
+		return
+	}
+
+	err = publishProviders(queue, body, runHeaders(startTime))
+	if err != nil {
+		pd.reportFailure(pd.influxTags(), startTime, err, "Failed to publish provider message")
+		return
+	}
+
+	lastRun.Beat()
+
+	providersCount := len(providers)
+
+	influxFields := map[string]interface{}{
+		"elapsed":      time.Since(startTime).Milliseconds(),
+		"providerSent": providersCount,
+		"success":      true,
+	}
+
+	errorHandling.LogOnError(
+		influxSingleton.Send("provider_process", pd.influxTags(), influxFields),
+		"Could not write to InfluxDB")
+
+	log.Printf("End. Got %d providers in %s\n", providersCount, time.Since(startTime))
+}
+
+// influxTags tag the provider_process points with the current source.
+func (pd ProviderProcessor) influxTags() map[string]string {
+	return map[string]string{"run_uuid": processUUID, "source": configuration.Provider.Source.Url}
+}
+
+// runHeaders identify the run of a providers message for core.
+func runHeaders(startTime time.Time) amqp.Table {
+	return amqp.Table{
+		schemas.RunUUIDHeader:      uuid.New().String(),
+		schemas.RunStartedAtHeader: startTime.Format(time.RFC3339Nano),
+		schemas.RunSourceHeader:    configuration.Provider.Source.Url,
+	}
+}
+
+func publishProviders(queue string, body []byte, headers amqp.Table) error {
+	return rabbitMQSingleton.Retry(lifecycleManager.WorkContext(), func(ctx context.Context) error {
+		return rabbitMQSingleton.Channel.Publish(
+			"", queue, false, false, amqp.Publishing{
+				ContentType: "text/json",
+				Headers:     headers,
+				Body:        body,
+			})
+	})
+}
+
+// shortCircuit answers a refresh that wasn't scraped because the source's
+// circuit breaker is open with an empty providers message, so core records
+// the run as unavailable.
+func (pd ProviderProcessor) shortCircuit(queue string, influxTags map[string]string, startTime time.Time, err error) {
+	log.Printf("Skipping refresh of %s - error: %s", configuration.Provider.Source.Url, err)
+
+	headers := runHeaders(startTime)
+	headers[schemas.RunErrorHeader] = err.Error()
+
+	errorHandling.Report(
+		publishProviders(queue, []byte("[]"), headers), "Failed to publish short-circuited run")
+
+	errorHandling.LogOnError(
+		influxSingleton.Send("provider_process", influxTags, map[string]interface{}{
+			"elapsed":        time.Since(startTime).Milliseconds(),
+			"success":        false,
+			"shortCircuited": true,
+		}),
+		"Could not write to InfluxDB")
+}
+
+// reportFailure reports a refresh that couldn't be completed. The consumer
+// moves on to the next refresh request.
+func (pd ProviderProcessor) reportFailure(influxTags map[string]string, startTime time.Time, err error, msg string) {
+	errorHandling.Report(err, msg)
+
+	errorHandling.LogOnError(
+		influxSingleton.Send("provider_process", influxTags, map[string]interface{}{
+			"elapsed": time.Since(startTime).Milliseconds(),
+			"success": false,
+		}),
+		"Could not write to InfluxDB")
+}
+
+// isRefreshForSource tells whether a refresh message targets source. Empty
+// bodies and requests without a source target every provider.
+func isRefreshForSource(body []byte, source string) bool {
+	if len(body) == 0 {
+		return true
+	}
+
+	var request schemas.RefreshRequest
+	if err := json.Unmarshal(body, &request); err != nil {
+		log.Printf("Ignoring malformed refresh request - error: %s", err)
+		return false
+	}
+
+	return request.Source == "" || request.Source == source
 }
@@ -0,0 +1,132 @@
+package providersExport
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"sarasa/schemas"
+)
+
+const (
+	FormatCSV   = "csv"
+	FormatJSONL = "jsonl"
+	FormatXLSX  = "xlsx"
+)
+
+var columns = []string{"id", "name", "phone", "place", "source", "link", "updatedAt", "pics"}
+
+// PageFn returns the providers page after cursor, an empty cursor being the
+// first page. Both the postgres and the showcase clients can back it.
+type PageFn func(ctx context.Context, cursor string) (schemas.ProvidersPage, error)
+
+// Writer encodes providers one at a time. Close must be called to flush
+// the output.
+type Writer interface {
+	Write(provider schemas.Provider) error
+	Close() error
+}
+
+func NewWriter(format string, w io.Writer) (Writer, error) {
+	switch format {
+	case FormatCSV:
+		return newCSVWriter(w)
+	case FormatJSONL:
+		return &jsonlWriter{encoder: json.NewEncoder(w)}, nil
+	case FormatXLSX:
+		return newXLSXWriter(w)
+	default:
+		return nil, fmt.Errorf("unknown export format %q", format)
+	}
+}
+
+func ContentType(format string) string {
+	switch format {
+	case FormatCSV:
+		return "text/csv; charset=utf-8"
+	case FormatJSONL:
+		return "application/x-ndjson"
+	case FormatXLSX:
+		return "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"
+	default:
+		return "application/octet-stream"
+	}
+}
+
+// Export writes every provider returned by pages to w, one page at a time.
+func Export(ctx context.Context, w io.Writer, format string, pages PageFn) error {
+	writer, err := NewWriter(format, w)
+	if err != nil {
+		return err
+	}
+
+	cursor := ""
+	for {
+		page, err := pages(ctx, cursor)
+		if err != nil {
+			return err
+		}
+
+		for _, provider := range page.Providers {
+			if err := writer.Write(provider); err != nil {
+				return err
+			}
+		}
+
+		if page.NextCursor == "" {
+			return writer.Close()
+		}
+
+		cursor = page.NextCursor
+	}
+}
+
+func row(provider schemas.Provider) []string {
+	return []string{
+		strconv.Itoa(provider.ID),
+		provider.Name,
+		provider.Phone,
+		provider.Place,
+		provider.Source,
+		provider.Link,
+		provider.UpdatedAt.Format(time.RFC3339),
+		strings.Join(provider.Pics, " "),
+	}
+}
+
+type csvWriter struct {
+	writer *csv.Writer
+}
+
+func newCSVWriter(w io.Writer) (*csvWriter, error) {
+	writer := csv.NewWriter(w)
+
+	return &csvWriter{writer: writer}, writer.Write(columns)
+}
+
+func (w *csvWriter) Write(provider schemas.Provider) error {
+	return w.writer.Write(row(provider))
+}
+
+func (w *csvWriter) Close() error {
+	w.writer.Flush()
+
+	return w.writer.Error()
+}
+
+type jsonlWriter struct {
+	encoder *json.Encoder
+}
+
+func (w *jsonlWriter) Write(provider schemas.Provider) error {
+	return w.encoder.Encode(provider)
+}
+
+func (w *jsonlWriter) Close() error {
+	return nil
+}
@@ -1,9 +1,14 @@
 package rabbitMQ
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"log"
+	"time"
 
+	"sarasa/libs/errorHandling"
+	"sarasa/libs/retryHandling"
 	"sarasa/schemas"
 
 	"github.com/streadway/amqp"
@@ -16,6 +21,18 @@ type Client struct {
 
 var lastrc schemas.RabbitMQConfig
 
+// DialPolicy retries connecting while the broker is starting.
+var DialPolicy = retryHandling.Policy{
+	MaxElapsed:   time.Minute,
+	InitialDelay: time.Second,
+	MaxDelay:     10 * time.Second,
+	Jitter:       0.2,
+	OnRetry:      retryHandling.LogRetry,
+}
+
+// RetryPolicy is used by Retry. Its BeforeRetry is replaced by a reconnect.
+var RetryPolicy = retryHandling.DefaultPolicy
+
 func (rabbitmq *Client) Init(rc schemas.RabbitMQConfig) error {
 	log.Println("Initializing RabbitMQ client...")
 
@@ -23,7 +40,12 @@ func (rabbitmq *Client) Init(rc schemas.RabbitMQConfig) error {
 
 	url := fmt.Sprintf("amqp://%s:%s@%s:%d/", rc.User, rc.Password, rc.Host, rc.Port)
 
-	rabbitmq.Connection, err = amqp.Dial(url)
+	err = DialPolicy.Do(context.Background(), func(ctx context.Context) error {
+		var err error
+		rabbitmq.Connection, err = amqp.Dial(url)
+
+		return err
+	})
 	if err != nil {
 		return err
 	}
@@ -43,16 +65,54 @@ func (rabbitmq *Client) Restart() error {
 	return rabbitmq.Init(lastrc)
 }
 
-func (rabbitmq Client) Close() error {
-	rabbitmq.Channel.Close()
-	rabbitmq.Channel = nil
+func (rabbitmq *Client) Close() error {
+	if rabbitmq.Channel != nil {
+		rabbitmq.Channel.Close()
+		rabbitmq.Channel = nil
+	}
+
+	if rabbitmq.Connection == nil {
+		return nil
+	}
 
-	rabbitmq.Connection.Close()
+	err := rabbitmq.Connection.Close()
 	rabbitmq.Connection = nil
 
-	return nil
+	if errors.Is(err, amqp.ErrClosed) {
+		return nil
+	}
+
+	return err
 }
 
 func (rabbitmq Client) String() string {
 	return "RabbitMQ"
 }
+
+// Retry runs f with RetryPolicy, reconnecting before retrying when the
+// broker connection or channel was lost.
+func (rabbitmq *Client) Retry(ctx context.Context, f func(ctx context.Context) error) error {
+	policy := RetryPolicy
+	policy.BeforeRetry = func(ctx context.Context, attempt int, err error) error {
+		if errorHandling.CategoryOf(err) != errorHandling.DependencyDown {
+			return nil
+		}
+
+		return rabbitmq.Restart()
+	}
+
+	return policy.Do(ctx, f)
+}
+
+// Ping fails when the connection to the broker is gone.
+func (rabbitmq *Client) Ping(ctx context.Context) error {
+	if rabbitmq.Connection == nil || rabbitmq.Channel == nil {
+		return errors.New("not connected")
+	}
+
+	if rabbitmq.Connection.IsClosed() {
+		return errors.New("connection closed")
+	}
+
+	return nil
+}
@@ -0,0 +1,32 @@
+package rabbitMQ
+
+import (
+	"errors"
+
+	"sarasa/libs/errorHandling"
+
+	"github.com/streadway/amqp"
+)
+
+func init() {
+	errorHandling.RegisterClassifier(classify)
+}
+
+// classify maps broker errors to errorHandling categories. A closed
+// connection or channel means the broker is unreachable; other errors are
+// transient when the broker says the channel can recover.
+func classify(err error) (errorHandling.Category, bool) {
+	var amqpErr *amqp.Error
+	if !errors.As(err, &amqpErr) {
+		return errorHandling.Unknown, false
+	}
+
+	switch {
+	case amqpErr == amqp.ErrClosed, amqpErr.Code == amqp.ConnectionForced, amqpErr.Code == amqp.FrameError:
+		return errorHandling.DependencyDown, true
+	case amqpErr.Recover:
+		return errorHandling.Transient, true
+	}
+
+	return errorHandling.Permanent, true
+}
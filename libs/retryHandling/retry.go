@@ -1,39 +1,201 @@
 package retryHandling
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"log"
+	"math/rand"
 	"time"
 
 	"sarasa/libs/errorHandling"
 )
 
-type TryFunctionFn func() error
-type PreFunctionFn func() error
+// Predicate tells whether an error is worth retrying.
+type Predicate func(err error) bool
 
-func Try(f TryFunctionFn, retryConditions []error, preFn PreFunctionFn, retryCount int, sleepBetweenRetry time.Duration) error {
-	var err error
+// Policy describes how an operation is retried. The zero value runs the
+// operation once; start from DefaultPolicy or one of the client libraries'
+// policies and override what's needed.
+type Policy struct {
+	// MaxAttempts bounds the number of runs, including the first one. Zero
+	// means no bound other than MaxElapsed.
+	MaxAttempts int
+	// MaxElapsed bounds the time spent retrying, sleeps included. Zero means
+	// no bound other than MaxAttempts.
+	MaxElapsed time.Duration
 
-	if retryCount <= 0 {
-		return nil
+	// InitialDelay is the sleep before the first retry. Every following
+	// sleep is multiplied by Multiplier, 2 when unset, up to MaxDelay.
+	InitialDelay time.Duration
+	MaxDelay     time.Duration
+	Multiplier   float64
+	// Jitter randomizes every sleep by up to that fraction of it, e.g. 0.2
+	// sleeps between 80% and 120% of the delay.
+	Jitter float64
+
+	// RetryIf tells which errors are retried, IsRetryable when nil.
+	RetryIf Predicate
+
+	// BeforeRetry runs before every retry, e.g. to reconnect. An error from
+	// it is logged and the retry goes on.
+	BeforeRetry func(ctx context.Context, attempt int, err error) error
+	// OnRetry is called before sleeping for a retry, with the attempt that
+	// failed and the upcoming sleep.
+	OnRetry func(attempt int, delay time.Duration, err error)
+	// OnGiveUp is called when the last error is returned.
+	OnGiveUp func(attempts int, elapsed time.Duration, err error)
+}
+
+// DefaultPolicy suits calls to the services' dependencies: a few quick
+// retries of retryable errors.
+var DefaultPolicy = Policy{
+	MaxAttempts:  4,
+	MaxElapsed:   30 * time.Second,
+	InitialDelay: 200 * time.Millisecond,
+	MaxDelay:     5 * time.Second,
+	Multiplier:   2,
+	Jitter:       0.2,
+	OnRetry:      LogRetry,
+}
+
+// IsRetryable retries the errors errorHandling classifies as transient or
+// dependency down.
+func IsRetryable(err error) bool {
+	return errorHandling.IsRetryable(err)
+}
+
+// Is retries errors matching any of targets through errors.Is.
+func Is(targets ...error) Predicate {
+	return func(err error) bool {
+		for _, target := range targets {
+			if errors.Is(err, target) {
+				return true
+			}
+		}
+
+		return false
 	}
+}
 
-	if err = f(); nil != err {
-		for ; retryCount > 0; retryCount-- {
-			for _, e := range retryConditions {
-				if err == e {
-					log.Printf("Retry number: %d - Waiting: %d ms", retryCount, sleepBetweenRetry.Milliseconds())
+// As retries errors whose chain holds a T.
+func As[T error]() Predicate {
+	return func(err error) bool {
+		var target T
 
-					time.Sleep(sleepBetweenRetry)
+		return errors.As(err, &target)
+	}
+}
 
-					errorHandling.LogOnError(preFn(), "Failed to restart ")
+// Any retries errors matching any of predicates.
+func Any(predicates ...Predicate) Predicate {
+	return func(err error) bool {
+		for _, predicate := range predicates {
+			if predicate(err) {
+				return true
+			}
+		}
+
+		return false
+	}
+}
+
+// LogRetry is an OnRetry callback logging every retry.
+func LogRetry(attempt int, delay time.Duration, err error) {
+	log.Printf("Attempt %d failed, retrying in %d ms - error: %s", attempt, delay.Milliseconds(), err)
+}
+
+// Do runs f until it succeeds, returns an error the policy doesn't retry,
+// runs out of attempts or time, or ctx is done. It returns nil on success
+// and the last error of f otherwise.
+func (policy Policy) Do(ctx context.Context, f func(ctx context.Context) error) error {
+	retryIf := policy.RetryIf
+	if retryIf == nil {
+		retryIf = IsRetryable
+	}
+
+	start := time.Now()
+	delay := policy.InitialDelay
+
+	for attempt := 1; ; attempt++ {
+		err := f(ctx)
+		if err == nil {
+			return nil
+		}
 
-					errorHandling.FailOnError(f(),
-						fmt.Sprintf("After retry ExchangeDeclare"))
-				}
+		if !policy.shouldRetry(ctx, attempt, start, delay, retryIf, err) {
+			if policy.OnGiveUp != nil {
+				policy.OnGiveUp(attempt, time.Since(start), err)
 			}
+
+			return err
 		}
+
+		sleep := policy.jitter(delay)
+
+		if policy.OnRetry != nil {
+			policy.OnRetry(attempt, sleep, err)
+		}
+
+		timer := time.NewTimer(sleep)
+
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+
+			if policy.OnGiveUp != nil {
+				policy.OnGiveUp(attempt, time.Since(start), err)
+			}
+
+			return err
+		case <-timer.C:
+		}
+
+		if policy.BeforeRetry != nil {
+			errorHandling.LogOnError(
+				policy.BeforeRetry(ctx, attempt, err), fmt.Sprintf("Failed preparing retry %d", attempt))
+		}
+
+		delay = policy.next(delay)
+	}
+}
+
+func (policy Policy) shouldRetry(ctx context.Context, attempt int, start time.Time, delay time.Duration, retryIf Predicate, err error) bool {
+	if ctx.Err() != nil || !retryIf(err) {
+		return false
+	}
+
+	if policy.MaxAttempts > 0 && attempt >= policy.MaxAttempts {
+		return false
+	}
+
+	if policy.MaxAttempts <= 0 && policy.MaxElapsed <= 0 {
+		return false
+	}
+
+	return policy.MaxElapsed <= 0 || time.Since(start)+delay <= policy.MaxElapsed
+}
+
+func (policy Policy) next(delay time.Duration) time.Duration {
+	multiplier := policy.Multiplier
+	if multiplier <= 0 {
+		multiplier = 2
 	}
 
-	return err
+	next := time.Duration(float64(delay) * multiplier)
+	if policy.MaxDelay > 0 && next > policy.MaxDelay {
+		next = policy.MaxDelay
+	}
+
+	return next
+}
+
+func (policy Policy) jitter(delay time.Duration) time.Duration {
+	if policy.Jitter <= 0 || delay <= 0 {
+		return delay
+	}
+
+	spread := float64(delay) * policy.Jitter
+
+	return time.Duration(float64(delay) - spread + rand.Float64()*2*spread)
 }
@@ -0,0 +1,232 @@
+package showcaseClient
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"sarasa/schemas"
+)
+
+// Client talks to showcase_server following its openapi.json, so services
+// can read the catalog without connecting to Postgres.
+type Client struct {
+	baseURL    string
+	apiKey     string
+	httpClient *http.Client
+}
+
+// APIError is returned for every non 2xx response.
+type APIError struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("showcase server responded %d: %s", e.StatusCode, e.Message)
+}
+
+// ListProvidersParams mirrors the query parameters of GET /providers. Zero
+// values are not sent.
+type ListProvidersParams struct {
+	Zone         string
+	Source       string
+	Query        string
+	HasPics      *bool
+	UpdatedSince time.Time
+	Sort         string
+	Cursor       string
+	Limit        int
+}
+
+// New returns a client authenticating with apiKey. A nil httpClient gets a
+// default one with a 10 seconds timeout.
+func New(baseURL, apiKey string, httpClient *http.Client) *Client {
+	if httpClient == nil {
+		httpClient = &http.Client{Timeout: 10 * time.Second}
+	}
+
+	return &Client{baseURL: strings.TrimSuffix(baseURL, "/"), apiKey: apiKey, httpClient: httpClient}
+}
+
+func (c *Client) ListProviders(ctx context.Context, params ListProvidersParams) (schemas.ProvidersPage, error) {
+	query := params.values()
+
+	if params.Cursor != "" {
+		query.Set("cursor", params.Cursor)
+	}
+
+	if params.Limit != 0 {
+		query.Set("limit", strconv.Itoa(params.Limit))
+	}
+
+	page := schemas.ProvidersPage{}
+
+	header, err := c.getWithHeader(ctx, "/providers", query, &page.Providers)
+	if err != nil {
+		return page, err
+	}
+
+	page.NextCursor = header.Get("X-Next-Cursor")
+
+	return page, nil
+}
+
+// values are the filters of params as query parameters.
+func (params ListProvidersParams) values() url.Values {
+	query := url.Values{}
+
+	if params.Zone != "" {
+		query.Set("zone", params.Zone)
+	}
+
+	if params.Source != "" {
+		query.Set("source", params.Source)
+	}
+
+	if params.Query != "" {
+		query.Set("q", params.Query)
+	}
+
+	if params.HasPics != nil {
+		query.Set("hasPics", strconv.FormatBool(*params.HasPics))
+	}
+
+	if !params.UpdatedSince.IsZero() {
+		query.Set("updatedSince", params.UpdatedSince.Format(time.RFC3339))
+	}
+
+	if params.Sort != "" {
+		query.Set("sort", params.Sort)
+	}
+
+	return query
+}
+
+// ExportProviders streams every provider matching the filters of params,
+// Cursor and Limit aside, to w in format, csv, jsonl or xlsx. It needs a key
+// with the export scope, and an httpClient whose timeout leaves room for
+// the whole export.
+func (c *Client) ExportProviders(ctx context.Context, w io.Writer, format string, params ListProvidersParams) error {
+	query := params.values()
+	query.Set("format", format)
+
+	resp, err := c.do(ctx, "/export", query, "*/*")
+	if err != nil {
+		return err
+	}
+
+	defer resp.Body.Close()
+
+	_, err = io.Copy(w, resp.Body)
+
+	return err
+}
+
+// ListAllProviders follows the next cursor until the last page.
+func (c *Client) ListAllProviders(ctx context.Context, params ListProvidersParams) ([]schemas.Provider, error) {
+	providers := make([]schemas.Provider, 0)
+
+	for {
+		page, err := c.ListProviders(ctx, params)
+		if err != nil {
+			return nil, err
+		}
+
+		providers = append(providers, page.Providers...)
+
+		if page.NextCursor == "" {
+			return providers, nil
+		}
+
+		params.Cursor = page.NextCursor
+	}
+}
+
+func (c *Client) GetProvider(ctx context.Context, providerID int) (schemas.Provider, error) {
+	var provider schemas.Provider
+
+	return provider, c.get(ctx, fmt.Sprintf("/providers/%d", providerID), nil, &provider)
+}
+
+func (c *Client) GetProviderPics(ctx context.Context, providerID int) ([]string, error) {
+	var pics []string
+
+	return pics, c.get(ctx, fmt.Sprintf("/providers/%d/pics", providerID), nil, &pics)
+}
+
+func (c *Client) ListZones(ctx context.Context) ([]schemas.ZoneSummary, error) {
+	var zones []schemas.ZoneSummary
+
+	return zones, c.get(ctx, "/zones", nil, &zones)
+}
+
+func (c *Client) ListSources(ctx context.Context) ([]schemas.SourceSummary, error) {
+	var sources []schemas.SourceSummary
+
+	return sources, c.get(ctx, "/sources", nil, &sources)
+}
+
+func (c *Client) get(ctx context.Context, path string, query url.Values, out interface{}) error {
+	_, err := c.getWithHeader(ctx, path, query, out)
+
+	return err
+}
+
+// getWithHeader decodes the JSON response into out and returns its headers.
+func (c *Client) getWithHeader(ctx context.Context, path string, query url.Values, out interface{}) (http.Header, error) {
+	resp, err := c.do(ctx, path, query, "application/json")
+	if err != nil {
+		return nil, err
+	}
+
+	defer resp.Body.Close()
+
+	return resp.Header, json.NewDecoder(resp.Body).Decode(out)
+}
+
+// do sends a GET request and returns the response when it's a 2xx one,
+// which callers must close.
+func (c *Client) do(ctx context.Context, path string, query url.Values, accept string) (*http.Response, error) {
+	u := c.baseURL + path
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("Accept", accept)
+	if c.apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+c.apiKey)
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		defer resp.Body.Close()
+
+		var body struct {
+			Error string `json:"error"`
+		}
+
+		if err := json.NewDecoder(resp.Body).Decode(&body); err != nil || body.Error == "" {
+			body.Error = http.StatusText(resp.StatusCode)
+		}
+
+		return nil, &APIError{StatusCode: resp.StatusCode, Message: body.Error}
+	}
+
+	return resp, nil
+}
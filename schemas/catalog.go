@@ -0,0 +1,21 @@
+package schemas
+
+import "time"
+
+type ProvidersPage struct {
+	Providers  []Provider `json:"providers"`
+	NextCursor string     `json:"nextCursor,omitempty"`
+}
+
+type ZoneSummary struct {
+	ID             int    `json:"id"`
+	Name           string `json:"name"`
+	ProvidersCount int    `json:"providersCount"`
+}
+
+type SourceSummary struct {
+	ID             int        `json:"id"`
+	Name           string     `json:"name"`
+	ProvidersCount int        `json:"providersCount"`
+	LastSavedAt    *time.Time `json:"lastSavedAt"`
+}
@@ -6,12 +6,17 @@ type Config struct {
 	Postgres PostgresConfig `json:"postgres"`
 	Provider ProviderConfig `json:"provider"`
 	Telegram TelegramConfig `json:"telegram"`
+	Showcase ShowcaseConfig `json:"showcase_server"`
+	Health   HealthConfig   `json:"health"`
 }
 
 type ProviderConfig struct {
 	ProvidersCount    int    `json:"providersCount"`
 	ScrapWorkersCount int    `json:"scrapWorkersCount"`
 	Source            Source `json:"source"`
+	// Selector overrides the provider's built-in selector of the listing
+	// elements when set.
+	Selector string `json:"selector"`
 }
 
 type Source struct {
@@ -24,6 +29,11 @@ type PostgresConfig struct {
 	Password string `json:"password"`
 	Host     string `json:"host"`
 	Database string `json:"database"`
+
+	MaxOpenConns           int `json:"maxOpenConns"`
+	MaxIdleConns           int `json:"maxIdleConns"`
+	ConnMaxLifetimeSeconds int `json:"connMaxLifetimeSeconds"`
+	ConnMaxIdleTimeSeconds int `json:"connMaxIdleTimeSeconds"`
 }
 
 type RabbitMQConfig struct {
@@ -37,8 +47,33 @@ type InfluxConfig struct {
 	Enabled  bool   `json:"enabled"`
 	Url      string `json:"url"`
 	Database string `json:"database"`
+
+	// Points are written by batches of BatchSize, or every FlushIntervalMs,
+	// from a buffer of BufferSize points. Zero values use the defaults.
+	BatchSize       int `json:"batchSize"`
+	FlushIntervalMs int `json:"flushIntervalMs"`
+	BufferSize      int `json:"bufferSize"`
 }
 
 type TelegramConfig struct {
 	Token string `json:"token"`
 }
+
+type ShowcaseConfig struct {
+	// AdminKey is accepted as an admin API key, so the first keys can be
+	// minted. Empty by default, set it from a secret reference such as
+	// ${env:SHOWCASE_ADMIN_KEY} and empty it again once real keys exist.
+	AdminKey                  string `json:"adminKey"`
+	DefaultRateLimitPerMinute int    `json:"defaultRateLimitPerMinute"`
+	// FailedAuthPerMinute bounds the invalid API keys a client IP can
+	// present, each one costing a Postgres lookup. Zero disables the limit.
+	FailedAuthPerMinute int `json:"failedAuthPerMinute"`
+	// TrustedProxies may set X-Forwarded-For, e.g. a load balancer's
+	// network. Client IPs are taken from the connection when empty.
+	TrustedProxies []string `json:"trustedProxies"`
+}
+
+type HealthConfig struct {
+	// Port serving /healthz and /readyz.
+	Port int `json:"port"`
+}
@@ -0,0 +1,49 @@
+package schemas
+
+import "time"
+
+// ProvidersSavedExchange is the fanout exchange core publishes a
+// ProvidersSavedEvent to after storing the providers of a source.
+const ProvidersSavedExchange = "providers_saved"
+
+// RunStatusExchange is the fanout exchange core publishes a RunStatusEvent
+// to every time it finishes processing a scrape.
+const RunStatusExchange = "run_status"
+
+const (
+	ProviderCreated = "created"
+	ProviderUpdated = "updated"
+	ProviderRemoved = "removed"
+)
+
+type ProvidersSavedEvent struct {
+	Source         string           `json:"source"`
+	ProvidersCount int              `json:"providersCount"`
+	SavedAt        time.Time        `json:"savedAt"`
+	Changes        []ProviderChange `json:"changes"`
+}
+
+// ProviderChange is one of ProviderCreated, ProviderUpdated or
+// ProviderRemoved. Removed providers carry their last stored values.
+type ProviderChange struct {
+	Type     string   `json:"type"`
+	Provider Provider `json:"provider"`
+}
+
+const (
+	RunSaved   = "saved"
+	RunSkipped = "skipped"
+	RunFailed  = "failed"
+	// RunSourceUnavailable runs weren't scraped because the source's circuit
+	// breaker is open.
+	RunSourceUnavailable = "source_unavailable"
+)
+
+type RunStatusEvent struct {
+	Source                 string    `json:"source"`
+	Status                 string    `json:"status"`
+	ReceivedProvidersCount int       `json:"receivedProvidersCount"`
+	InvalidProvidersCount  int       `json:"invalidProvidersCount"`
+	Error                  string    `json:"error,omitempty"`
+	At                     time.Time `json:"at"`
+}
@@ -1,11 +1,14 @@
 package schemas
 
+import "time"
+
 type Provider struct {
-	ID     int      `json:"id"`
-	Name   string   `json:"name"`
-	Link   string   `json:"link"`
-	Phone  string   `json:"phone"`
-	Place  string   `json:"place"`
-	Source string   `json:"source"`
-	Pics   []string `json:"pics"`
+	ID        int       `json:"id"`
+	Name      string    `json:"name"`
+	Link      string    `json:"link"`
+	Phone     string    `json:"phone"`
+	Place     string    `json:"place"`
+	Source    string    `json:"source"`
+	Pics      []string  `json:"pics"`
+	UpdatedAt time.Time `json:"updatedAt"`
 }
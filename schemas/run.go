@@ -0,0 +1,55 @@
+package schemas
+
+import "time"
+
+// Run is a scrape of one source as processed by core. Status is one of
+// RunSaved, RunSkipped, RunFailed or RunSourceUnavailable.
+type Run struct {
+	ID                     int                   `json:"id"`
+	UUID                   string                `json:"uuid"`
+	Source                 string                `json:"source"`
+	Status                 string                `json:"status"`
+	ReceivedProvidersCount int                   `json:"receivedProvidersCount"`
+	InvalidProvidersCount  int                   `json:"invalidProvidersCount"`
+	SavedProvidersCount    int                   `json:"savedProvidersCount"`
+	InvalidReasons         map[string]int        `json:"invalidReasons"`
+	Error                  string                `json:"error,omitempty"`
+	StartedAt              time.Time             `json:"startedAt"`
+	FinishedAt             time.Time             `json:"finishedAt"`
+	DurationMs             int64                 `json:"durationMs"`
+	Quarantined            []QuarantinedProvider `json:"quarantined,omitempty"`
+}
+
+const (
+	QuarantinePending  = "pending"
+	QuarantineApproved = "approved"
+	QuarantineRejected = "rejected"
+)
+
+// QuarantinedProvider is an invalid provider kept for review instead of
+// being dropped.
+type QuarantinedProvider struct {
+	ID        int        `json:"id"`
+	RunID     int        `json:"runId"`
+	Provider  Provider   `json:"provider"`
+	Reason    string     `json:"reason"`
+	Status    string     `json:"status"`
+	CreatedAt time.Time  `json:"createdAt"`
+	DecidedAt *time.Time `json:"decidedAt,omitempty"`
+}
+
+// RefreshRequest is the body of messages on the "refresh" exchange. An empty
+// body or Source asks every provider to scrape.
+type RefreshRequest struct {
+	Source string `json:"source,omitempty"`
+}
+
+// Headers set by providers on the messages they publish to the "providers"
+// queue. RunErrorHeader is only set on the empty messages sent when a refresh
+// was short-circuited.
+const (
+	RunUUIDHeader      = "run_uuid"
+	RunStartedAtHeader = "started_at"
+	RunSourceHeader    = "source"
+	RunErrorHeader     = "run_error"
+)
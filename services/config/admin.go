@@ -0,0 +1,447 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"sarasa/libs/errorHandling"
+)
+
+// adminMu serializes the changes made through the admin API.
+var adminMu sync.Mutex
+
+const defaultAuditLimit = 100
+
+// serviceFile is the file holding the configuration of service in profile:
+// the base one or the profile's overlay.
+func serviceFile(profile, service string) string {
+	if profile == baseProfile {
+		return filepath.Join("services", service+".json")
+	}
+
+	return filepath.Join(profilesDir, profile, "services", service+".json")
+}
+
+// adminTarget returns the profile and service of an admin request, answering
+// 404 when either is unknown.
+func adminTarget(c *gin.Context) (profile, service string, ok bool) {
+	profile = c.DefaultQuery("profile", baseProfile)
+	service = c.Param("service")
+
+	if err := checkProfile(profile); err != nil {
+		c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return "", "", false
+	}
+
+	config, err := readConfigFile(profile)
+	if err != nil {
+		errorHandling.Report(err, "Failed reading config.json")
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "can't read config.json"})
+		return "", "", false
+	}
+
+	for _, s := range config.Services {
+		if s == service {
+			return profile, service, true
+		}
+	}
+
+	c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("unknown service %q in profile %s", service, profile)})
+
+	return "", "", false
+}
+
+// getServiceFile serves GET /admin/services/:service?profile=, the file as
+// saved, secret references unresolved and plaintext secrets redacted.
+func getServiceFile(c *gin.Context) {
+	profile, service, ok := adminTarget(c)
+	if !ok {
+		return
+	}
+
+	content, err := readJSON(serviceFile(profile, service))
+	if err != nil {
+		adminError(c, err, "Failed reading "+service+" configuration")
+		return
+	}
+
+	latest, err := latestRevision(profile, service)
+	if err != nil {
+		adminError(c, err, "Failed reading "+service+" revisions")
+		return
+	}
+
+	if content == nil {
+		content = map[string]interface{}{}
+	}
+
+	c.Header("ETag", strconv.Quote(strconv.Itoa(latest)))
+	c.JSON(http.StatusOK, redactSecrets(content, ""))
+}
+
+// putServiceFile serves PUT /admin/services/:service?profile=, replacing the
+// file with the body.
+func putServiceFile(c *gin.Context) {
+	profile, service, ok := adminTarget(c)
+	if !ok {
+		return
+	}
+
+	content, ok := bindObject(c)
+	if !ok {
+		return
+	}
+
+	saveServiceFile(c, profile, service, "put", false, func(current interface{}) interface{} {
+		return content
+	})
+}
+
+// patchServiceFile serves PATCH /admin/services/:service?profile=, applying
+// the body to the file as a JSON merge patch.
+func patchServiceFile(c *gin.Context) {
+	profile, service, ok := adminTarget(c)
+	if !ok {
+		return
+	}
+
+	patch, ok := bindObject(c)
+	if !ok {
+		return
+	}
+
+	saveServiceFile(c, profile, service, "patch", false, func(current interface{}) interface{} {
+		return mergePatch(current, patch)
+	})
+}
+
+// rollbackServiceFile serves POST
+// /admin/services/:service/rollback?profile=&revision=, saving the content of
+// an earlier revision as a new one.
+func rollbackServiceFile(c *gin.Context) {
+	profile, service, ok := adminTarget(c)
+	if !ok {
+		return
+	}
+
+	number, err := strconv.Atoi(c.Query("revision"))
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "revision must be a revision number"})
+		return
+	}
+
+	r, err := readRevision(profile, service, number)
+	if err != nil {
+		adminError(c, err, "Failed reading revision")
+		return
+	}
+
+	saveServiceFile(c, profile, service, fmt.Sprintf("rollback to %d", number), true, func(current interface{}) interface{} {
+		return r.Content
+	})
+}
+
+// saveServiceFile saves the content update returns for the current file as
+// a new revision, once validated and audited. New secrets must be secret
+// references; only restoring a revision, which may be the file as it was
+// before the admin API, can bring plaintext ones back. An If-Match header
+// must hold the quoted latest revision, from the ETag of getServiceFile.
+func saveServiceFile(c *gin.Context, profile, service, action string, restoring bool, update func(current interface{}) interface{}) {
+	adminMu.Lock()
+	defer adminMu.Unlock()
+
+	file := serviceFile(profile, service)
+
+	current, err := readJSON(file)
+	if err != nil {
+		adminError(c, err, "Failed reading "+service+" configuration")
+		return
+	}
+
+	latest, err := latestRevision(profile, service)
+	if err != nil {
+		adminError(c, err, "Failed reading "+service+" revisions")
+		return
+	}
+
+	if ifMatch := c.GetHeader("If-Match"); ifMatch != "" && ifMatch != strconv.Quote(strconv.Itoa(latest)) {
+		c.AbortWithStatusJSON(http.StatusPreconditionFailed, gin.H{"error": fmt.Sprintf("revision %d is the latest one", latest)})
+		return
+	}
+
+	content := update(current)
+
+	changes := diffJSON(current, content)
+	if len(changes) == 0 {
+		c.JSON(http.StatusOK, gin.H{"revision": latest, "changes": changes})
+		return
+	}
+
+	if problems := plaintextSecrets(content, ""); len(problems) > 0 && !restoring {
+		c.AbortWithStatusJSON(http.StatusUnprocessableEntity, gin.H{"error": "plaintext secrets", "problems": problems})
+		return
+	}
+
+	problems, err := validateChange(profile, service, content)
+	if err != nil {
+		adminError(c, err, "Failed validating "+service+" configuration")
+		return
+	}
+
+	if len(problems) > 0 {
+		c.AbortWithStatusJSON(http.StatusUnprocessableEntity, gin.H{"error": "invalid configuration", "problems": problems})
+		return
+	}
+
+	admin := c.GetString("admin")
+	now := time.Now().UTC()
+
+	// The file as it was before the first change through the API is kept as
+	// is, so it can be rolled back to. Revisions are redacted when served.
+	initial := latest == 0 && current != nil
+	if initial {
+		latest = 1
+	}
+
+	r := revision{Revision: latest + 1, Author: admin, Action: action, CreatedAt: now, Content: content}
+
+	// Audited first, so no change lands without its record.
+	if err := appendAudit(auditEntry{
+		Time:       now,
+		Author:     admin,
+		Action:     action,
+		Profile:    profile,
+		Service:    service,
+		Revision:   r.Revision,
+		Changes:    changes,
+		RemoteAddr: c.ClientIP(),
+	}); err != nil {
+		adminError(c, err, "Failed writing audit log")
+		return
+	}
+
+	if initial {
+		first := revision{Revision: 1, Author: "initial", Action: "initial", CreatedAt: now, Content: current}
+		if err := saveRevision(profile, service, first); err != nil {
+			adminError(c, err, "Failed saving "+service+" initial revision")
+			return
+		}
+	}
+
+	if err := saveRevision(profile, service, r); err != nil {
+		adminError(c, err, "Failed saving "+service+" revision")
+		return
+	}
+
+	if err := writeJSONFile(file, content); err != nil {
+		adminError(c, err, "Failed writing "+service+" configuration")
+		return
+	}
+
+	// Served right away rather than on the next watch tick.
+	_, err = configuration.reload()
+	errorHandling.Report(err, "Failed reloading configuration")
+
+	c.Header("ETag", strconv.Quote(strconv.Itoa(r.Revision)))
+	c.JSON(http.StatusOK, gin.H{"revision": r.Revision, "changes": changes})
+}
+
+// validateChange returns the problems content would add to the profiles it
+// affects: every profile for a base file, only its own for an overlay.
+// Problems already there aren't blamed on the change.
+func validateChange(profile, service string, content interface{}) ([]string, error) {
+	profiles := []string{profile}
+
+	if profile == baseProfile {
+		var err error
+		if profiles, err = listProfiles(); err != nil {
+			return nil, err
+		}
+	}
+
+	var problems []string
+
+	for _, p := range profiles {
+		var merged interface{}
+
+		switch {
+		case profile == baseProfile && p == baseProfile:
+			merged = content
+		case profile == baseProfile:
+			overlay, err := readJSON(serviceFile(p, service))
+			if err != nil {
+				return nil, err
+			}
+
+			merged = overlayJSON(content, overlay)
+		default:
+			base, err := readJSON(serviceFile(baseProfile, service))
+			if err != nil {
+				return nil, err
+			}
+
+			merged = overlayJSON(base, content)
+		}
+
+		before, err := validateFiles(p, nil)
+		if err != nil {
+			return nil, err
+		}
+
+		after, err := validateFiles(p, map[string]interface{}{service: merged})
+		if err != nil {
+			return nil, err
+		}
+
+		existing := make(map[string]bool, len(before))
+		for _, problem := range before {
+			existing[problem] = true
+		}
+
+		for _, problem := range after {
+			if existing[problem] {
+				continue
+			}
+
+			if p != baseProfile {
+				problem = "[" + p + "] " + problem
+			}
+
+			problems = append(problems, problem)
+		}
+	}
+
+	return problems, nil
+}
+
+// listServiceRevisions serves GET /admin/services/:service/revisions?profile=.
+func listServiceRevisions(c *gin.Context) {
+	profile, service, ok := adminTarget(c)
+	if !ok {
+		return
+	}
+
+	revisions, err := listRevisions(profile, service)
+	if err != nil {
+		adminError(c, err, "Failed reading "+service+" revisions")
+		return
+	}
+
+	c.JSON(http.StatusOK, revisions)
+}
+
+// getServiceRevision serves GET
+// /admin/services/:service/revisions/:revision?profile=.
+func getServiceRevision(c *gin.Context) {
+	profile, service, ok := adminTarget(c)
+	if !ok {
+		return
+	}
+
+	number, err := strconv.Atoi(c.Param("revision"))
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "revision must be a revision number"})
+		return
+	}
+
+	r, err := readRevision(profile, service, number)
+	if err != nil {
+		adminError(c, err, "Failed reading revision")
+		return
+	}
+
+	r.Content = redactSecrets(r.Content, "")
+	c.JSON(http.StatusOK, r)
+}
+
+// diffServiceRevisions serves GET
+// /admin/services/:service/diff?profile=&from=&to=, the changes between two
+// revisions, by default the latest one and the one before.
+func diffServiceRevisions(c *gin.Context) {
+	profile, service, ok := adminTarget(c)
+	if !ok {
+		return
+	}
+
+	latest, err := latestRevision(profile, service)
+	if err != nil {
+		adminError(c, err, "Failed reading "+service+" revisions")
+		return
+	}
+
+	to, err := strconv.Atoi(c.DefaultQuery("to", strconv.Itoa(latest)))
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "to must be a revision number"})
+		return
+	}
+
+	from, err := strconv.Atoi(c.DefaultQuery("from", strconv.Itoa(to-1)))
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "from must be a revision number"})
+		return
+	}
+
+	fromRevision, err := readRevision(profile, service, from)
+	if err != nil {
+		adminError(c, err, "Failed reading revision")
+		return
+	}
+
+	toRevision, err := readRevision(profile, service, to)
+	if err != nil {
+		adminError(c, err, "Failed reading revision")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"from":    from,
+		"to":      to,
+		"changes": diffJSON(fromRevision.Content, toRevision.Content),
+	})
+}
+
+// getAuditLog serves GET /admin/audit?service=&limit=, newest first.
+func getAuditLog(c *gin.Context) {
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", strconv.Itoa(defaultAuditLimit)))
+	if err != nil || limit <= 0 {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "limit must be a positive number"})
+		return
+	}
+
+	entries, err := readAudit(c.Query("service"), limit)
+	if err != nil {
+		adminError(c, err, "Failed reading audit log")
+		return
+	}
+
+	c.JSON(http.StatusOK, entries)
+}
+
+// bindObject decodes the body, which must be a JSON object.
+func bindObject(c *gin.Context) (map[string]interface{}, bool) {
+	var content map[string]interface{}
+
+	if err := json.NewDecoder(c.Request.Body).Decode(&content); err != nil || content == nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "body must be a JSON object"})
+		return nil, false
+	}
+
+	return content, true
+}
+
+func adminError(c *gin.Context, err error, msg string) {
+	if errors.Is(err, errRevisionNotFound) {
+		c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+
+	errorHandling.Report(err, msg)
+	c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": msg})
+}
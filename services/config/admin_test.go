@@ -0,0 +1,76 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/require"
+)
+
+// newAdminRouter serves the admin API from a temporary directory holding a
+// telegram configuration with a plaintext token, as written before the API.
+func newAdminRouter(t *testing.T) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	t.Chdir(t.TempDir())
+
+	require.NoError(t, os.WriteFile("config.json", []byte(`{"services": ["telegram"]}`), 0o644))
+	require.NoError(t, os.Mkdir("services", 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join("services", "telegram.json"), []byte(`{"token": "plain-token"}`), 0o644))
+
+	configuration = newConfigStore()
+
+	r := gin.New()
+	admin := r.Group("/admin", func(c *gin.Context) { c.Set("admin", "tester") })
+	admin.PUT("/services/:service", putServiceFile)
+	admin.GET("/services/:service/revisions/:revision", getServiceRevision)
+	admin.POST("/services/:service/rollback", rollbackServiceFile)
+
+	return r
+}
+
+func serveAdmin(r http.Handler, method, target, body string) *httptest.ResponseRecorder {
+	request := httptest.NewRequest(method, target, strings.NewReader(body))
+	recorder := httptest.NewRecorder()
+	r.ServeHTTP(recorder, request)
+
+	return recorder
+}
+
+func TestRollbackToInitialRevision(t *testing.T) {
+	r := newAdminRouter(t)
+
+	recorder := serveAdmin(r, http.MethodPut, "/admin/services/telegram", `{"token": "${env:TELEGRAM_BOT_TOKEN}"}`)
+	require.Equal(t, http.StatusOK, recorder.Code, recorder.Body.String())
+	require.JSONEq(t, `{"revision": 2, "changes": [{"path": "token", "op": "changed", "from": "***", "to": "${env:TELEGRAM_BOT_TOKEN}"}]}`, recorder.Body.String())
+
+	// Served redacted, stored as it was.
+	recorder = serveAdmin(r, http.MethodGet, "/admin/services/telegram/revisions/1", "")
+	require.Equal(t, http.StatusOK, recorder.Code)
+
+	var served revision
+	require.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &served))
+	require.Equal(t, map[string]interface{}{"token": "***"}, served.Content)
+
+	// New plaintext secrets are still refused.
+	recorder = serveAdmin(r, http.MethodPut, "/admin/services/telegram", `{"token": "other-token"}`)
+	require.Equal(t, http.StatusUnprocessableEntity, recorder.Code)
+
+	recorder = serveAdmin(r, http.MethodPost, "/admin/services/telegram/rollback?revision=1", "")
+	require.Equal(t, http.StatusOK, recorder.Code, recorder.Body.String())
+
+	var response struct {
+		Revision int `json:"revision"`
+	}
+	require.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &response))
+	require.Equal(t, 3, response.Revision)
+
+	content, err := readJSON(serviceFile(baseProfile, "telegram"))
+	require.NoError(t, err)
+	require.Equal(t, map[string]interface{}{"token": "plain-token"}, content)
+}
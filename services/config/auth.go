@@ -0,0 +1,218 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"sarasa/libs/errorHandling"
+)
+
+// tokensFile maps every service to the hex SHA-256 of its token, e.g.
+// `printf %s "$TOKEN" | sha256sum`. Services send their token, the
+// CONFIG_SERVER_TOKEN environment variable, as a bearer token. Without the
+// file every configuration is refused, unless the server is insecure, see
+// `config token` to create it.
+const tokensFile = "tokens.json"
+
+// adminsFile maps every administrator to the hex SHA-256 of their token,
+// like tokensFile. Without it the admin API is disabled.
+const adminsFile = "admins.json"
+
+// serviceTokens maps names, of services or administrators, to the SHA-256
+// of their token. A nil map authorizes nobody.
+type serviceTokens map[string][]byte
+
+func readTokens() (serviceTokens, error) {
+	return readTokenFile(tokensFile)
+}
+
+// readTokenFile reads a file of hex SHA-256 tokens, nil when it doesn't
+// exist.
+func readTokenFile(file string) (serviceTokens, error) {
+	content, err := os.ReadFile(file)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("config/readTokenFile - Fail to read %s, error: %w", file, err)
+	}
+
+	var hexTokens map[string]string
+	if err := json.Unmarshal(content, &hexTokens); err != nil {
+		return nil, fmt.Errorf("config/readTokenFile - Fail to decode %s, error: %w", file, err)
+	}
+
+	tokens := make(serviceTokens, len(hexTokens))
+	for name, hexToken := range hexTokens {
+		hash, err := hex.DecodeString(hexToken)
+		if err != nil || len(hash) != sha256.Size {
+			return nil, fmt.Errorf("config/readTokenFile - Fail to decode the token of %s in %s, it must be a hex SHA-256", name, file)
+		}
+
+		tokens[name] = hash
+	}
+
+	return tokens, nil
+}
+
+// owner returns the name token belongs to.
+func (tokens serviceTokens) owner(token string) (string, bool) {
+	if token == "" {
+		return "", false
+	}
+
+	hash := sha256.Sum256([]byte(token))
+
+	for name, expected := range tokens {
+		if subtle.ConstantTimeCompare(hash[:], expected) == 1 {
+			return name, true
+		}
+	}
+
+	return "", false
+}
+
+func (tokens serviceTokens) authorize(serviceName, token string) bool {
+	expected, ok := tokens[serviceName]
+	if !ok || token == "" {
+		return false
+	}
+
+	hash := sha256.Sum256([]byte(token))
+
+	return subtle.ConstantTimeCompare(hash[:], expected) == 1
+}
+
+// requireServiceToken only lets through requests carrying the token of the
+// service they ask the configuration of.
+func requireServiceToken(c *gin.Context) {
+	serviceName := c.Query("serviceName")
+	token := strings.TrimPrefix(c.GetHeader("Authorization"), "Bearer ")
+
+	if configuration.authorize(serviceName, token) {
+		c.Next()
+		return
+	}
+
+	log.Printf("Refused configuration of %q to %s", serviceName, c.ClientIP())
+
+	if token == "" {
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "missing service token"})
+		return
+	}
+
+	c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "invalid token for " + serviceName})
+}
+
+// requireAdmin only lets through requests carrying an administrator's
+// token, whose name is set as "admin" in the context.
+func requireAdmin(c *gin.Context) {
+	admins, err := readTokenFile(adminsFile)
+	if err != nil {
+		errorHandling.Report(err, "Failed reading administrators")
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "can't read administrators"})
+		return
+	}
+
+	if admins == nil {
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "admin API disabled, no " + adminsFile})
+		return
+	}
+
+	token := strings.TrimPrefix(c.GetHeader("Authorization"), "Bearer ")
+
+	admin, ok := admins.owner(token)
+	if !ok {
+		log.Printf("Refused admin request %s %s to %s", c.Request.Method, c.Request.URL.Path, c.ClientIP())
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "invalid admin token"})
+		return
+	}
+
+	c.Set("admin", admin)
+	c.Next()
+}
+
+// unauthenticatedPolicy tells how requests are served without tokensFile.
+func unauthenticatedPolicy() string {
+	if *insecure {
+		return "configurations are served without authentication (insecure)"
+	}
+
+	return "every configuration is refused"
+}
+
+// tokenCommand runs `config token [service...]`: it generates a token for
+// the given services, every service of config.json by default, saves their
+// hashes to tokensFile and prints them as <SERVICE>_CONFIG_SERVER_TOKEN
+// variables, for docker-compose's .env. It returns the exit code.
+func tokenCommand(services []string) int {
+	config, err := readConfigFile(baseProfile)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Could not read configuration: %s\n", err)
+		return 2
+	}
+
+	listed := make(map[string]bool, len(config.Services))
+	for _, s := range config.Services {
+		listed[s] = true
+	}
+
+	if len(services) == 0 {
+		services = config.Services
+	}
+
+	hexTokens := make(map[string]string)
+	if content, err := os.ReadFile(tokensFile); err == nil {
+		if err := json.Unmarshal(content, &hexTokens); err != nil {
+			fmt.Fprintf(os.Stderr, "Could not decode %s: %s\n", tokensFile, err)
+			return 2
+		}
+	} else if !errors.Is(err, os.ErrNotExist) {
+		fmt.Fprintf(os.Stderr, "Could not read %s: %s\n", tokensFile, err)
+		return 2
+	}
+
+	tokens := make(map[string]string, len(services))
+
+	for _, s := range services {
+		if !listed[s] {
+			fmt.Fprintf(os.Stderr, "Unknown service %q\n", s)
+			return 2
+		}
+
+		raw := make([]byte, 32)
+		if _, err := rand.Read(raw); err != nil {
+			fmt.Fprintf(os.Stderr, "Could not generate token: %s\n", err)
+			return 2
+		}
+
+		token := base64.RawURLEncoding.EncodeToString(raw)
+		hash := sha256.Sum256([]byte(token))
+
+		tokens[s] = token
+		hexTokens[s] = hex.EncodeToString(hash[:])
+	}
+
+	if err := writeJSONFile(tokensFile, hexTokens); err != nil {
+		fmt.Fprintf(os.Stderr, "Could not write %s: %s\n", tokensFile, err)
+		return 2
+	}
+
+	for _, s := range services {
+		fmt.Printf("%s_CONFIG_SERVER_TOKEN=%s\n", strings.ToUpper(s), tokens[s])
+	}
+
+	return 0
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	"sarasa/libs/configHandling"
+)
+
+// change is a difference between two versions of a configuration, at a
+// dotted path. Secrets are redacted.
+type change struct {
+	Path string      `json:"path"`
+	Op   string      `json:"op"`
+	From interface{} `json:"from,omitempty"`
+	To   interface{} `json:"to,omitempty"`
+}
+
+// diffJSON lists the changes from a to b, objects compared key by key.
+func diffJSON(a, b interface{}) []change {
+	changes := []change{}
+	diffAt("", a, b, &changes)
+
+	return changes
+}
+
+func diffAt(path string, a, b interface{}, changes *[]change) {
+	aMap, aIsMap := a.(map[string]interface{})
+	bMap, bIsMap := b.(map[string]interface{})
+
+	if aIsMap && bIsMap {
+		keys := make(map[string]bool, len(aMap)+len(bMap))
+		for key := range aMap {
+			keys[key] = true
+		}
+
+		for key := range bMap {
+			keys[key] = true
+		}
+
+		sorted := make([]string, 0, len(keys))
+		for key := range keys {
+			sorted = append(sorted, key)
+		}
+
+		sort.Strings(sorted)
+
+		for _, key := range sorted {
+			diffAt(joinPath(path, key), aMap[key], bMap[key], changes)
+		}
+
+		return
+	}
+
+	switch {
+	case reflect.DeepEqual(a, b):
+	case a == nil:
+		*changes = append(*changes, change{Path: path, Op: "added", To: redactValue(path, b)})
+	case b == nil:
+		*changes = append(*changes, change{Path: path, Op: "removed", From: redactValue(path, a)})
+	default:
+		*changes = append(*changes, change{Path: path, Op: "changed", From: redactValue(path, a), To: redactValue(path, b)})
+	}
+}
+
+// redactValue hides the values of secret fields, unless they're secret
+// references.
+func redactValue(path string, value interface{}) interface{} {
+	if isPlaintextSecret(path, value) {
+		return configHandling.Redacted
+	}
+
+	return value
+}
+
+// isPlaintextSecret tells whether value, at path, is a set secret that isn't
+// a secret reference.
+func isPlaintextSecret(path string, value interface{}) bool {
+	if value == nil || value == "" || !configHandling.IsSecret(path[strings.LastIndex(path, ".")+1:]) {
+		return false
+	}
+
+	s, ok := value.(string)
+
+	return !ok || !secretReference.MatchString(s)
+}
+
+// redactSecrets returns a copy of value with its plaintext secrets redacted.
+func redactSecrets(value interface{}, path string) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		redacted := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			redacted[key] = redactSecrets(item, joinPath(path, key))
+		}
+
+		return redacted
+	case []interface{}:
+		redacted := make([]interface{}, len(v))
+		for i, item := range v {
+			redacted[i] = redactSecrets(item, joinPath(path, fmt.Sprint(i)))
+		}
+
+		return redacted
+	}
+
+	return redactValue(path, value)
+}
+
+// plaintextSecrets lists the secrets of value that aren't secret references,
+// which the admin API refuses to save.
+func plaintextSecrets(value interface{}, path string) []string {
+	var problems []string
+
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for _, key := range sortedKeys(v) {
+			problems = append(problems, plaintextSecrets(v[key], joinPath(path, key))...)
+		}
+	case []interface{}:
+		for i, item := range v {
+			problems = append(problems, plaintextSecrets(item, joinPath(path, fmt.Sprint(i)))...)
+		}
+	default:
+		if isPlaintextSecret(path, v) {
+			problems = append(problems, path+": secrets must be references such as ${env:NAME} or ${enc:...}, see `config encrypt`")
+		}
+	}
+
+	return problems
+}
+
+// mergePatch applies a JSON merge patch (RFC 7386) to target: objects are
+// merged key by key, null removes a key and any other value replaces the
+// target one. Neither is modified.
+func mergePatch(target, patch interface{}) interface{} {
+	patchMap, ok := patch.(map[string]interface{})
+	if !ok {
+		return patch
+	}
+
+	targetMap, ok := target.(map[string]interface{})
+	if !ok {
+		targetMap = map[string]interface{}{}
+	}
+
+	merged := make(map[string]interface{}, len(targetMap)+len(patchMap))
+	for key, value := range targetMap {
+		merged[key] = value
+	}
+
+	for key, value := range patchMap {
+		if value == nil {
+			delete(merged, key)
+			continue
+		}
+
+		merged[key] = mergePatch(targetMap[key], value)
+	}
+
+	return merged
+}
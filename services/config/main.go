@@ -1,120 +1,333 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
+	"log"
 	"net/http"
 	"os"
+	"path/filepath"
+	"strconv"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"sarasa/libs/errorHandling"
+	"sarasa/libs/lifecycle"
+	"sarasa/schemas"
 )
 
-var configurationMap map[string]map[string]interface{}
+var configuration = newConfigStore()
+var healthSingleton lifecycle.Health
+var lifecycleManager = lifecycle.NewManager(lifecycle.DefaultDrainTimeout)
 
-func init() {
-	configurationMap = make(map[string]map[string]interface{})
+// defaultProfile is served when a request doesn't ask for a profile.
+var defaultProfile = flag.String("profile", envOr("CONFIG_PROFILE", baseProfile),
+	"profile served by default, base or a directory of profiles/")
 
-	errorHandling.FailOnError(getConfig(), "Failed getting configuration")
+// insecure serves every configuration to anyone when there's no tokens.json,
+// for local development only.
+var insecure = flag.Bool("insecure", envBool("CONFIG_SERVER_INSECURE"),
+	"serve configurations without authentication when there's no "+tokensFile)
+
+const (
+	defaultWatchTimeout = 30 * time.Second
+	maxWatchTimeout     = 60 * time.Second
+)
+
+func initialize() {
+	errorHandling.FailOnError(checkProfile(*defaultProfile), "Invalid default profile")
+
+	_, err := configuration.reload()
+	errorHandling.FailOnError(err, "Failed getting configuration")
+
+	if _, err := os.Stat(tokensFile); err != nil {
+		if !*insecure {
+			errorHandling.FailOnError(fmt.Errorf("no %s, create it with `config token` or run with -insecure", tokensFile), "Refusing to serve configurations")
+		}
+
+		log.Printf("No %s, %s", tokensFile, unauthenticatedPolicy())
+	}
+
+	/**
+	 * Health checks
+	 */
+	var healthConfig schemas.HealthConfig
+
+	rawHealthConfig, err := getServiceConfig(*defaultProfile, "health")
+	errorHandling.FailOnError(err, "Failed to retrieve health configuration")
+
+	if rawHealthConfig != nil {
+		healthJSON, err := json.Marshal(rawHealthConfig)
+		errorHandling.FailOnError(err, "Failed to encode health configuration")
+		errorHandling.FailOnError(json.Unmarshal(healthJSON, &healthConfig), "Failed to decode health configuration")
+	}
+
+	healthSingleton.AddReadinessCheck("lifecycle", lifecycleManager.ReadyCheck)
+	healthSingleton.AddReadinessCheck("config", func(ctx context.Context) (string, error) {
+		profiles, version, loadedAt := configuration.status()
+		if profiles == 0 {
+			return "", errors.New("no service configuration loaded")
+		}
+
+		return fmt.Sprintf("%d profiles configured, version %d loaded at %s",
+			profiles, version, loadedAt.UTC().Format(time.RFC3339)), nil
+	})
+
+	go func() {
+		errorHandling.LogOnError(healthSingleton.Serve(healthConfig), "Health server stopped")
+	}()
+	lifecycleManager.AddService(&healthSingleton)
+
+	/**
+	 * Signal handling
+	 */
+	lifecycleManager.HandleSignals()
 }
 
 func main() {
+	flag.Parse()
+
+	if flag.NArg() > 0 {
+		switch flag.Arg(0) {
+		case "validate":
+			os.Exit(validateCommand())
+		case "encrypt":
+			os.Exit(encryptCommand())
+		case "token":
+			os.Exit(tokenCommand(flag.Args()[1:]))
+		default:
+			fmt.Fprintf(os.Stderr, "Unknown command %q, expected validate, encrypt or token\n", flag.Arg(0))
+			os.Exit(2)
+		}
+	}
+
+	initialize()
+
+	lifecycleManager.Go(configuration.watch)
+
 	r := gin.Default()
-	r.GET("/", func(c *gin.Context) {
-		serviceName := c.Query("serviceName")
+	r.GET("/", requireServiceToken, getServiceConfiguration)
+	r.GET("/watch", requireServiceToken, watchServiceConfiguration)
 
-		c.JSON(200, configurationMap[serviceName])
-	})
+	admin := r.Group("/admin", requireAdmin)
+	admin.GET("/services/:service", getServiceFile)
+	admin.PUT("/services/:service", putServiceFile)
+	admin.PATCH("/services/:service", patchServiceFile)
+	admin.GET("/services/:service/revisions", listServiceRevisions)
+	admin.GET("/services/:service/revisions/:revision", getServiceRevision)
+	admin.GET("/services/:service/diff", diffServiceRevisions)
+	admin.POST("/services/:service/rollback", rollbackServiceFile)
+	admin.GET("/audit", getAuditLog)
+
+	server := &http.Server{Addr: ":8090", Handler: r}
+	lifecycleManager.AddStopper("HTTP server", server.Shutdown)
+
+	go func() {
+		err := server.ListenAndServe()
+		if !errors.Is(err, http.ErrServerClosed) {
+			errorHandling.FailOnError(err, "HTTP server stopped")
+		}
+	}()
+
+	log.Printf("Serving on %s", server.Addr)
+	lifecycleManager.Wait()
+}
+
+// getServiceConfiguration serves GET /?serviceName=&profile=, answering 304
+// when If-None-Match holds the current ETag and 404 for unknown services and
+// profiles.
+func getServiceConfiguration(c *gin.Context) {
+	config, version, _, err := configuration.get(requestProfile(c), c.Query("serviceName"))
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+
+	writeServiceConfig(c, config, version)
+}
+
+// requestProfile is the profile asked for, the default one when unset.
+func requestProfile(c *gin.Context) string {
+	if profile := c.Query("profile"); profile != "" {
+		return profile
+	}
+
+	return *defaultProfile
+}
+
+// watchServiceConfiguration serves GET /watch?serviceName=&profile=&timeout=,
+// a long poll answering as soon as the service configuration differs from the one
+// in If-None-Match, or 304 once timeout seconds (30 by default, at most 60)
+// have passed without changes.
+func watchServiceConfiguration(c *gin.Context) {
+	serviceName := c.Query("serviceName")
+	profile := requestProfile(c)
+	etag := c.GetHeader("If-None-Match")
+
+	timeout := defaultWatchTimeout
+	if raw := c.Query("timeout"); raw != "" {
+		seconds, err := strconv.Atoi(raw)
+		if err != nil || seconds <= 0 {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "timeout must be a positive number of seconds"})
+			return
+		}
+
+		timeout = time.Duration(seconds) * time.Second
+		if timeout > maxWatchTimeout {
+			timeout = maxWatchTimeout
+		}
+	}
+
+	deadline := time.NewTimer(timeout)
+	defer deadline.Stop()
 
-	r.Run(":8090")
+	for {
+		config, version, changed, err := configuration.get(profile, serviceName)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+
+		if config.ETag != etag {
+			writeServiceConfig(c, config, version)
+			return
+		}
+
+		select {
+		case <-changed:
+		case <-deadline.C:
+			c.Header("ETag", config.ETag)
+			c.Status(http.StatusNotModified)
+			return
+		case <-c.Request.Context().Done():
+			return
+		case <-lifecycleManager.Context().Done():
+			c.Status(http.StatusServiceUnavailable)
+			return
+		}
+	}
 }
 
-func index(w http.ResponseWriter, req *http.Request) {
-	errorHandling.LogOnError(req.ParseForm(), "Failed to parse request form")
+func writeServiceConfig(c *gin.Context, config serviceConfig, version int64) {
+	if config.Err != nil {
+		c.AbortWithStatusJSON(http.StatusServiceUnavailable, gin.H{"error": "configuration unavailable"})
+		return
+	}
 
-	serviceName := req.Form.Get("serviceName")
+	c.Header("ETag", config.ETag)
+	c.Header("X-Config-Version", strconv.FormatInt(version, 10))
+	c.Header("Cache-Control", "no-cache")
 
-	configJSON, err := json.Marshal(configurationMap[serviceName])
-	errorHandling.FailOnError(err, "Failed to write to response")
+	if c.GetHeader("If-None-Match") == config.ETag {
+		c.Status(http.StatusNotModified)
+		return
+	}
 
-	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	c.Data(http.StatusOK, "application/json; charset=utf-8", config.Body)
+}
 
-	_, err = w.Write(configJSON)
-	errorHandling.FailOnError(err, "Failed to write to response")
+// configFile is config.json: the services, what each one depends on and
+// the name a service gets its own configuration under.
+type configFile struct {
+	Services     []string            `json:"services"`
+	Dependencies map[string][]string `json:"dependencies"`
+	Aliases      map[string]string   `json:"aliases"`
 }
 
-func getConfig() error {
-	file, err := os.Open("config.json")
+func readConfigFile(profile string) (configFile, error) {
+	var config configFile
+
+	value, err := readProfileJSON(profile, "config.json")
 	if err != nil {
-		return err
+		return config, err
 	}
 
-	defer func() {
-		errorHandling.LogOnError(
-			file.Close(), "Error closing config file")
-	}()
+	if value == nil {
+		return config, fmt.Errorf("config.json not found")
+	}
 
-	var config struct {
-		Services     []string            `json:"services"`
-		Dependencies map[string][]string `json:"dependencies"`
-		Aliases      map[string]string   `json:"aliases"`
+	raw, err := json.Marshal(value)
+	if err != nil {
+		return config, err
+	}
+
+	if err := json.Unmarshal(raw, &config); err != nil {
+		return config, fmt.Errorf("error decoding config: %v", err)
 	}
 
-	err = json.NewDecoder(file).Decode(&config)
+	return config, nil
+}
+
+// getConfig builds the configuration of every service of profile from
+// config.json and services/*.json, with the profile's overlays.
+func getConfig(profile string) (map[string]map[string]interface{}, error) {
+	config, err := readConfigFile(profile)
 	if err != nil {
-		return fmt.Errorf("error decoding config: %v", err)
+		return nil, err
 	}
 
 	configMap := make(map[string]interface{})
 	for _, s := range config.Services {
-		sConfig, err := getServiceConfig(s)
-		errorHandling.FailOnError(err, fmt.Sprintf("Failed to retrieve service \"%s\" configuration", s))
+		sConfig, err := getServiceConfig(profile, s)
+		if err != nil {
+			return nil, fmt.Errorf("failed to retrieve service \"%s\" configuration: %w", s, err)
+		}
 
 		configMap[s] = sConfig
 	}
 
-	for s, di := range config.Dependencies {
-		if c, ok := configMap[s]; ok && c != nil {
-			if a, ok := config.Aliases[s]; ok {
-				configurationMap[s] = map[string]interface{}{a: c}
-			} else {
-				configurationMap[s] = map[string]interface{}{s: c}
-			}
+	return mergeConfig(config, configMap)
+}
+
+// mergeConfig gives every listed service its own configuration, under its
+// alias when it has one, and the configuration of its dependencies. Missing
+// configurations are left out rather than sent as null.
+func mergeConfig(config configFile, configMap map[string]interface{}) (map[string]map[string]interface{}, error) {
+	configurationMap := make(map[string]map[string]interface{}, len(config.Services))
+
+	for _, s := range config.Services {
+		serviceMap := make(map[string]interface{})
+		section := serviceSection(config, s)
+
+		if c := configMap[s]; c != nil {
+			serviceMap[section] = c
 		}
 
-		for _, s2 := range di {
-			if len(configurationMap[s]) == 0 {
-				configurationMap[s] = map[string]interface{}{s2: configMap[s2]}
-			} else {
-				configurationMap[s][s2] = configMap[s2]
+		for _, dependency := range config.Dependencies[s] {
+			if dependency == section {
+				return nil, fmt.Errorf("config/mergeConfig - Fail to merge %s configuration, error: dependency %s collides with its own configuration", s, dependency)
+			}
+
+			if c := configMap[dependency]; c != nil {
+				serviceMap[dependency] = c
 			}
 		}
+
+		configurationMap[s] = serviceMap
 	}
 
-	return nil
+	return configurationMap, nil
 }
 
-func getServiceConfig(serviceName string) (interface{}, error) {
-	file, err := os.Open(fmt.Sprintf("services/%s.json", serviceName))
-	if os.IsNotExist(err) {
-		return nil, nil
+func envOr(name, fallback string) string {
+	if value := os.Getenv(name); value != "" {
+		return value
 	}
 
-	if err != nil {
-		return nil, err
-	}
-
-	defer func() {
-		errorHandling.LogOnError(
-			file.Close(), "Error closing config file")
-	}()
+	return fallback
+}
 
-	var serviceConfig interface{}
+// envBool reads a boolean environment variable, false when unset or
+// invalid.
+func envBool(name string) bool {
+	value, _ := strconv.ParseBool(os.Getenv(name))
 
-	err = json.NewDecoder(file).Decode(&serviceConfig)
-	if err != nil {
-		return nil, err
-	}
+	return value
+}
 
-	return serviceConfig, nil
+func getServiceConfig(profile, serviceName string) (interface{}, error) {
+	return readProfileJSON(profile, filepath.Join("services", serviceName+".json"))
 }
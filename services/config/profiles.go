@@ -0,0 +1,123 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"sarasa/libs/errorHandling"
+)
+
+// baseProfile is config.json and services/*.json without any overlay.
+const baseProfile = "base"
+
+// profilesDir holds one directory per profile, e.g. profiles/prod. A
+// profile's config.json and services/*.json are merged over the base ones:
+// objects are merged key by key, any other value, lists included, replaces
+// the base one.
+const profilesDir = "profiles"
+
+// listProfiles returns the base profile and every profile directory.
+func listProfiles() ([]string, error) {
+	entries, err := os.ReadDir(profilesDir)
+	if errors.Is(err, os.ErrNotExist) {
+		return []string{baseProfile}, nil
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("config/listProfiles - Fail to read %s, error: %w", profilesDir, err)
+	}
+
+	profiles := []string{baseProfile}
+	for _, entry := range entries {
+		if entry.IsDir() && entry.Name() != baseProfile {
+			profiles = append(profiles, entry.Name())
+		}
+	}
+
+	sort.Strings(profiles[1:])
+
+	return profiles, nil
+}
+
+// readJSON decodes file into a generic value, nil when the file doesn't
+// exist.
+func readJSON(file string) (interface{}, error) {
+	content, err := os.ReadFile(file)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	var value interface{}
+	if err := json.Unmarshal(content, &value); err != nil {
+		return nil, fmt.Errorf("error decoding %s: %w", file, err)
+	}
+
+	return value, nil
+}
+
+// readProfileJSON decodes the base file and merges the profile's overlay of
+// it, if any, over it.
+func readProfileJSON(profile, file string) (interface{}, error) {
+	value, err := readJSON(file)
+	if err != nil {
+		return nil, err
+	}
+
+	if profile == baseProfile {
+		return value, nil
+	}
+
+	overlay, err := readJSON(filepath.Join(profilesDir, profile, file))
+	if err != nil {
+		return nil, err
+	}
+
+	return overlayJSON(value, overlay), nil
+}
+
+// overlayJSON returns overlay merged over base. Neither is modified.
+func overlayJSON(base, overlay interface{}) interface{} {
+	if overlay == nil {
+		return base
+	}
+
+	baseMap, baseIsMap := base.(map[string]interface{})
+	overlayMap, overlayIsMap := overlay.(map[string]interface{})
+
+	if !baseIsMap || !overlayIsMap {
+		return overlay
+	}
+
+	merged := make(map[string]interface{}, len(baseMap)+len(overlayMap))
+	for key, value := range baseMap {
+		merged[key] = value
+	}
+
+	for key, value := range overlayMap {
+		merged[key] = overlayJSON(baseMap[key], value)
+	}
+
+	return merged
+}
+
+// checkProfile fails for profiles without a directory.
+func checkProfile(profile string) error {
+	if profile == baseProfile {
+		return nil
+	}
+
+	info, err := os.Stat(filepath.Join(profilesDir, profile))
+	if err != nil || !info.IsDir() || filepath.Base(profile) != profile {
+		return errorHandling.NewInvalidInput(fmt.Errorf("unknown profile %q", profile))
+	}
+
+	return nil
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// revisionsDir keeps every saved version of the files changed through the
+// admin API, as revisions/<profile>/<service>/<revision>.json.
+const revisionsDir = "revisions"
+
+// auditFile logs every change made through the admin API, one JSON entry
+// per line.
+const auditFile = "audit.log"
+
+var errRevisionNotFound = errors.New("revision not found")
+
+// revision is a saved version of a service configuration file.
+type revision struct {
+	Revision  int         `json:"revision"`
+	Author    string      `json:"author"`
+	Action    string      `json:"action"`
+	CreatedAt time.Time   `json:"createdAt"`
+	Content   interface{} `json:"content,omitempty"`
+}
+
+// auditEntry records who changed what.
+type auditEntry struct {
+	Time       time.Time `json:"time"`
+	Author     string    `json:"author"`
+	Action     string    `json:"action"`
+	Profile    string    `json:"profile"`
+	Service    string    `json:"service"`
+	Revision   int       `json:"revision"`
+	Changes    []change  `json:"changes"`
+	RemoteAddr string    `json:"remoteAddr"`
+}
+
+func revisionDir(profile, service string) string {
+	return filepath.Join(revisionsDir, profile, service)
+}
+
+// listRevisions returns the revisions of a service file, oldest first,
+// without their content.
+func listRevisions(profile, service string) ([]revision, error) {
+	files, err := filepath.Glob(filepath.Join(revisionDir(profile, service), "*.json"))
+	if err != nil {
+		return nil, err
+	}
+
+	revisions := make([]revision, 0, len(files))
+	for _, file := range files {
+		number, err := strconv.Atoi(strings.TrimSuffix(filepath.Base(file), ".json"))
+		if err != nil {
+			continue
+		}
+
+		r, err := readRevision(profile, service, number)
+		if err != nil {
+			return nil, err
+		}
+
+		r.Content = nil
+		revisions = append(revisions, r)
+	}
+
+	sort.Slice(revisions, func(i, j int) bool { return revisions[i].Revision < revisions[j].Revision })
+
+	return revisions, nil
+}
+
+func readRevision(profile, service string, number int) (revision, error) {
+	var r revision
+
+	content, err := os.ReadFile(filepath.Join(revisionDir(profile, service), fmt.Sprintf("%d.json", number)))
+	if errors.Is(err, os.ErrNotExist) {
+		return r, fmt.Errorf("%w: %d", errRevisionNotFound, number)
+	}
+
+	if err != nil {
+		return r, err
+	}
+
+	if err := json.Unmarshal(content, &r); err != nil {
+		return r, fmt.Errorf("config/readRevision - Fail to decode revision %d of %s, error: %w", number, service, err)
+	}
+
+	return r, nil
+}
+
+// latestRevision returns the number of the last revision, 0 when there's
+// none.
+func latestRevision(profile, service string) (int, error) {
+	revisions, err := listRevisions(profile, service)
+	if err != nil || len(revisions) == 0 {
+		return 0, err
+	}
+
+	return revisions[len(revisions)-1].Revision, nil
+}
+
+func saveRevision(profile, service string, r revision) error {
+	dir := revisionDir(profile, service)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+
+	return writeJSONFile(filepath.Join(dir, fmt.Sprintf("%d.json", r.Revision)), r)
+}
+
+// writeJSONFile replaces file atomically, so the watcher never reads half of
+// it.
+func writeJSONFile(file string, value interface{}) error {
+	content, err := json.MarshalIndent(value, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(file), 0o755); err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(file), "."+filepath.Base(file)+".*")
+	if err != nil {
+		return err
+	}
+
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(append(content, '\n')); err != nil {
+		tmp.Close()
+		return err
+	}
+
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), file)
+}
+
+func appendAudit(entry auditEntry) error {
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	file, err := os.OpenFile(auditFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+
+	if _, err := file.Write(append(line, '\n')); err != nil {
+		file.Close()
+		return err
+	}
+
+	return file.Close()
+}
+
+// readAudit returns the last limit entries of the audit log matching
+// service, newest first. An empty service matches every entry.
+func readAudit(service string, limit int) ([]auditEntry, error) {
+	file, err := os.Open(auditFile)
+	if errors.Is(err, os.ErrNotExist) {
+		return []auditEntry{}, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer file.Close()
+
+	var entries []auditEntry
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+
+	for scanner.Scan() {
+		var entry auditEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			continue
+		}
+
+		if service == "" || entry.Service == service {
+			entries = append(entries, entry)
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	if len(entries) > limit {
+		entries = entries[len(entries)-limit:]
+	}
+
+	for i, j := 0, len(entries)-1; i < j; i, j = i+1, j-1 {
+		entries[i], entries[j] = entries[j], entries[i]
+	}
+
+	return entries, nil
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// secretsKeyEnv holds the base64 AES-256 key decrypting ${enc:...} values.
+const secretsKeyEnv = "CONFIG_SECRETS_KEY"
+
+// secretReference matches a string value standing for a secret, resolved
+// when the configuration is loaded:
+//   - ${env:NAME} is the NAME environment variable of the config server
+//   - ${file:/path} is the content of the file, without trailing newlines
+//   - ${enc:BASE64} is decrypted with the CONFIG_SECRETS_KEY key, see
+//     `config encrypt`
+var secretReference = regexp.MustCompile(`^\$\{(env|file|enc):(.+)\}$`)
+
+// resolveSecrets returns a copy of value with every secret reference
+// replaced by the secret, path naming value in errors. value is shared
+// between services and left as is.
+func resolveSecrets(value interface{}, path string) (interface{}, error) {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		resolved := make(map[string]interface{}, len(v))
+
+		for key, item := range v {
+			r, err := resolveSecrets(item, joinPath(path, key))
+			if err != nil {
+				return nil, err
+			}
+
+			resolved[key] = r
+		}
+
+		return resolved, nil
+	case []interface{}:
+		resolved := make([]interface{}, len(v))
+
+		for i, item := range v {
+			r, err := resolveSecrets(item, joinPath(path, fmt.Sprint(i)))
+			if err != nil {
+				return nil, err
+			}
+
+			resolved[i] = r
+		}
+
+		return resolved, nil
+	case string:
+		match := secretReference.FindStringSubmatch(v)
+		if match == nil {
+			return v, nil
+		}
+
+		secret, err := resolveSecret(match[1], match[2])
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+
+		return secret, nil
+	}
+
+	return value, nil
+}
+
+func joinPath(path, key string) string {
+	if path == "" {
+		return key
+	}
+
+	return path + "." + key
+}
+
+func resolveSecret(kind, reference string) (string, error) {
+	switch kind {
+	case "env":
+		secret, ok := os.LookupEnv(reference)
+		if !ok {
+			return "", fmt.Errorf("environment variable %s isn't set", reference)
+		}
+
+		return secret, nil
+	case "file":
+		content, err := os.ReadFile(reference)
+		if err != nil {
+			return "", fmt.Errorf("can't read secret file, error: %w", err)
+		}
+
+		return strings.TrimRight(string(content), "\r\n"), nil
+	case "enc":
+		return decryptSecret(reference)
+	}
+
+	return "", fmt.Errorf("unknown secret kind %q", kind)
+}
+
+// checkSecretReferences lists the strings of value looking like a secret
+// reference without being a valid one, e.g. ${vault:x}. It doesn't resolve
+// them, the secrets may only exist where the config server runs.
+func checkSecretReferences(value interface{}, path string) []string {
+	var problems []string
+
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for _, key := range sortedKeys(v) {
+			problems = append(problems, checkSecretReferences(v[key], path+"."+key)...)
+		}
+	case []interface{}:
+		for i, item := range v {
+			problems = append(problems, checkSecretReferences(item, fmt.Sprintf("%s.%d", path, i))...)
+		}
+	case string:
+		if strings.HasPrefix(v, "${") && !secretReference.MatchString(v) {
+			problems = append(problems, fmt.Sprintf("%s: invalid secret reference, expected ${env:NAME}, ${file:/path} or ${enc:BASE64}", path))
+		}
+	}
+
+	return problems
+}
+
+func secretsCipher() (cipher.AEAD, error) {
+	encoded := os.Getenv(secretsKeyEnv)
+	if encoded == "" {
+		return nil, fmt.Errorf("%s isn't set", secretsKeyEnv)
+	}
+
+	key, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil || len(key) != 32 {
+		return nil, fmt.Errorf("%s must be 32 bytes encoded in base64", secretsKeyEnv)
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
+
+// encryptSecret returns the ${enc:...} reference of secret, AES-GCM
+// encrypted with the CONFIG_SECRETS_KEY key.
+func encryptSecret(secret []byte) (string, error) {
+	aead, err := secretsCipher()
+	if err != nil {
+		return "", err
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return "", err
+	}
+
+	sealed := aead.Seal(nonce, nonce, secret, nil)
+
+	return "${enc:" + base64.StdEncoding.EncodeToString(sealed) + "}", nil
+}
+
+func decryptSecret(encoded string) (string, error) {
+	aead, err := secretsCipher()
+	if err != nil {
+		return "", err
+	}
+
+	sealed, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil || len(sealed) < aead.NonceSize() {
+		return "", fmt.Errorf("encrypted secret isn't valid base64")
+	}
+
+	nonce, ciphertext := sealed[:aead.NonceSize()], sealed[aead.NonceSize():]
+
+	secret, err := aead.Open(nil, nonce, ciphertext, nil)
+	if err != nil {
+		return "", fmt.Errorf("can't decrypt secret, wrong %s?", secretsKeyEnv)
+	}
+
+	return string(secret), nil
+}
+
+// encryptCommand runs `config encrypt`: it prints the ${enc:...} reference
+// of the secret read from stdin and returns the exit code.
+func encryptCommand() int {
+	secret, err := io.ReadAll(os.Stdin)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Could not read secret: %s\n", err)
+		return 2
+	}
+
+	reference, err := encryptSecret([]byte(strings.TrimRight(string(secret), "\r\n")))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Could not encrypt secret: %s\n", err)
+		return 2
+	}
+
+	fmt.Println(reference)
+
+	return 0
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func newSecretsKey(t *testing.T) string {
+	key := make([]byte, 32)
+	_, err := rand.Read(key)
+	require.NoError(t, err)
+
+	return base64.StdEncoding.EncodeToString(key)
+}
+
+func TestResolveSecrets(t *testing.T) {
+	dir := t.TempDir()
+	secretFile := filepath.Join(dir, "password")
+	require.NoError(t, os.WriteFile(secretFile, []byte("from-file\n"), 0o600))
+
+	key := newSecretsKey(t)
+	t.Setenv(secretsKeyEnv, key)
+	t.Setenv("TEST_TELEGRAM_TOKEN", "from-env")
+
+	encrypted, err := encryptSecret([]byte("from-enc"))
+	require.NoError(t, err)
+
+	tests := map[string]struct {
+		value    string
+		key      string
+		expected string
+		err      string
+	}{
+		"plain values are kept": {
+			value:    "localhost",
+			expected: "localhost",
+		},
+		"unknown kinds aren't references": {
+			value:    "${vault:token}",
+			expected: "${vault:token}",
+		},
+		"environment variable": {
+			value:    "${env:TEST_TELEGRAM_TOKEN}",
+			expected: "from-env",
+		},
+		"missing environment variable": {
+			value: "${env:TEST_MISSING_TOKEN}",
+			err:   "postgres.password: environment variable TEST_MISSING_TOKEN isn't set",
+		},
+		"file without its trailing newline": {
+			value:    "${file:" + secretFile + "}",
+			expected: "from-file",
+		},
+		"missing file": {
+			value: "${file:" + filepath.Join(dir, "missing") + "}",
+			err:   "postgres.password: can't read secret file, error: open " + filepath.Join(dir, "missing") + ": no such file or directory",
+		},
+		"encrypted": {
+			value:    encrypted,
+			expected: "from-enc",
+		},
+		"encrypted without key": {
+			value: encrypted,
+			key:   "-",
+			err:   "postgres.password: CONFIG_SECRETS_KEY isn't set",
+		},
+		"encrypted with a short key": {
+			value: encrypted,
+			key:   base64.StdEncoding.EncodeToString([]byte("short")),
+			err:   "postgres.password: CONFIG_SECRETS_KEY must be 32 bytes encoded in base64",
+		},
+		"encrypted with another key": {
+			value: encrypted,
+			key:   newSecretsKey(t),
+			err:   "postgres.password: can't decrypt secret, wrong CONFIG_SECRETS_KEY?",
+		},
+		"encrypted value isn't base64": {
+			value: "${enc:not base64}",
+			err:   "postgres.password: encrypted secret isn't valid base64",
+		},
+		"encrypted value is shorter than the nonce": {
+			value: "${enc:" + base64.StdEncoding.EncodeToString([]byte("x")) + "}",
+			err:   "postgres.password: encrypted secret isn't valid base64",
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			switch tt.key {
+			case "":
+			case "-":
+				t.Setenv(secretsKeyEnv, "")
+			default:
+				t.Setenv(secretsKeyEnv, tt.key)
+			}
+
+			value := map[string]interface{}{"postgres": map[string]interface{}{"password": tt.value}}
+
+			resolved, err := resolveSecrets(value, "")
+			if tt.err != "" {
+				require.EqualError(t, err, tt.err)
+				return
+			}
+
+			require.NoError(t, err)
+			require.Equal(t, map[string]interface{}{"postgres": map[string]interface{}{"password": tt.expected}}, resolved)
+
+			// The shared configuration keeps its references.
+			require.Equal(t, tt.value, value["postgres"].(map[string]interface{})["password"])
+		})
+	}
+}
+
+func TestResolveSecretsInLists(t *testing.T) {
+	t.Setenv("TEST_RABBIT_PASSWORD", "secret")
+
+	resolved, err := resolveSecrets(map[string]interface{}{
+		"hosts": []interface{}{"${env:TEST_RABBIT_PASSWORD}", float64(5672)},
+	}, "")
+	require.NoError(t, err)
+	require.Equal(t, map[string]interface{}{"hosts": []interface{}{"secret", float64(5672)}}, resolved)
+
+	_, err = resolveSecrets([]interface{}{"ok", "${env:TEST_MISSING_PASSWORD}"}, "rabbit")
+	require.EqualError(t, err, "rabbit.1: environment variable TEST_MISSING_PASSWORD isn't set")
+}
+
+func TestCheckSecretReferences(t *testing.T) {
+	problems := checkSecretReferences(map[string]interface{}{
+		"token":    "${vault:telegram}",
+		"password": "${env:POSTGRES_PASSWORD}",
+		"hosts":    []interface{}{"${file:}", "localhost"},
+	}, "telegram")
+
+	require.Equal(t, []string{
+		"telegram.hosts.0: invalid secret reference, expected ${env:NAME}, ${file:/path} or ${enc:BASE64}",
+		"telegram.token: invalid secret reference, expected ${env:NAME}, ${file:/path} or ${enc:BASE64}",
+	}, problems)
+}
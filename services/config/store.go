@@ -0,0 +1,275 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"sarasa/libs/errorHandling"
+)
+
+// watchInterval is how often the configuration files are checked for
+// changes.
+const watchInterval = 2 * time.Second
+
+// serviceConfig is the configuration served to a service, already encoded.
+// ETag changes whenever the configuration does. Err is set instead of Body
+// when the configuration can't be served, e.g. a secret is missing.
+type serviceConfig struct {
+	Body []byte
+	ETag string
+	Err  error
+}
+
+// profileConfig holds the configuration of every service of a profile.
+type profileConfig map[string]serviceConfig
+
+// configStore holds the configuration of every service in every profile.
+// It's replaced as a whole on reload, so a service never gets half of a
+// change.
+type configStore struct {
+	mu          sync.RWMutex
+	profiles    map[string]profileConfig
+	tokens      serviceTokens
+	version     int64
+	loadedAt    time.Time
+	fingerprint string
+	changed     chan struct{}
+}
+
+func newConfigStore() *configStore {
+	return &configStore{
+		profiles: make(map[string]profileConfig),
+		changed:  make(chan struct{}),
+	}
+}
+
+func newServiceConfig(body []byte) serviceConfig {
+	sum := sha256.Sum256(body)
+
+	return serviceConfig{Body: body, ETag: `"` + hex.EncodeToString(sum[:8]) + `"`}
+}
+
+// errUnknown is returned by get for unknown profiles and services.
+var errUnknown = errors.New("unknown")
+
+// get returns the configuration of serviceName in profile, the store version
+// and a channel closed on the next reload.
+func (store *configStore) get(profile, serviceName string) (serviceConfig, int64, <-chan struct{}, error) {
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+
+	services, ok := store.profiles[profile]
+	if !ok {
+		return serviceConfig{}, store.version, store.changed, fmt.Errorf("%w profile %q", errUnknown, profile)
+	}
+
+	config, ok := services[serviceName]
+	if !ok {
+		return serviceConfig{}, store.version, store.changed, fmt.Errorf("%w service %q in profile %s", errUnknown, serviceName, profile)
+	}
+
+	return config, store.version, store.changed, nil
+}
+
+// authorize tells whether token gives access to serviceName's
+// configuration. Without tokens.json only an insecure server lets anyone
+// through.
+func (store *configStore) authorize(serviceName, token string) bool {
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+
+	if store.tokens == nil {
+		return *insecure
+	}
+
+	return store.tokens.authorize(serviceName, token)
+}
+
+func (store *configStore) status() (profiles int, version int64, loadedAt time.Time) {
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+
+	return len(store.profiles), store.version, store.loadedAt
+}
+
+// reload reads the configuration files again when they changed since the
+// last load. On error the current configuration is kept.
+func (store *configStore) reload() (bool, error) {
+	fingerprint, err := filesFingerprint()
+	if err != nil {
+		return false, err
+	}
+
+	store.mu.RLock()
+	unchanged := fingerprint == store.fingerprint
+	store.mu.RUnlock()
+
+	if unchanged {
+		return false, nil
+	}
+
+	profileNames, err := listProfiles()
+	if err != nil {
+		return false, err
+	}
+
+	profiles := make(map[string]profileConfig, len(profileNames))
+	for _, profile := range profileNames {
+		services, err := loadProfile(profile)
+		if err != nil {
+			return false, fmt.Errorf("config/reload - Fail to load profile %s, error: %w", profile, err)
+		}
+
+		profiles[profile] = services
+	}
+
+	// Services refuse invalid configurations themselves, the problems are
+	// only logged here so the others still get theirs.
+	problems, err := validateProfiles(profileNames)
+	if err != nil {
+		return false, err
+	}
+
+	for _, problem := range problems {
+		log.Printf("Configuration problem: %s", problem)
+	}
+
+	tokens, err := readTokens()
+	if err != nil {
+		return false, err
+	}
+
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	for profile, services := range profiles {
+		for name, config := range services {
+			if store.profiles[profile][name].ETag != config.ETag {
+				log.Printf("Configuration of %s in profile %s changed", name, profile)
+			}
+		}
+	}
+
+	if tokens == nil && store.tokens != nil {
+		log.Printf("%s removed, %s", tokensFile, unauthenticatedPolicy())
+	}
+
+	store.profiles = profiles
+	store.tokens = tokens
+	store.fingerprint = fingerprint
+	store.version++
+	store.loadedAt = time.Now()
+
+	close(store.changed)
+	store.changed = make(chan struct{})
+
+	return true, nil
+}
+
+// loadProfile builds and encodes the configuration of every service of
+// profile. A service whose secrets can't be resolved can't start, the others
+// still get their configuration.
+func loadProfile(profile string) (profileConfig, error) {
+	configurationMap, err := getConfig(profile)
+	if err != nil {
+		return nil, err
+	}
+
+	services := make(profileConfig, len(configurationMap))
+	for name, config := range configurationMap {
+		resolved, err := resolveSecrets(map[string]interface{}(config), "")
+		if err != nil {
+			errorHandling.Report(err, fmt.Sprintf("Failed resolving %s secrets in profile %s", name, profile))
+			services[name] = serviceConfig{ETag: `"unavailable"`, Err: err}
+
+			continue
+		}
+
+		body, err := json.Marshal(resolved)
+		if err != nil {
+			return nil, fmt.Errorf("config/loadProfile - Fail to encode %s configuration, error: %w", name, err)
+		}
+
+		services[name] = newServiceConfig(body)
+	}
+
+	return services, nil
+}
+
+// watch reloads the configuration every watchInterval until ctx is done.
+func (store *configStore) watch(ctx context.Context) {
+	ticker := time.NewTicker(watchInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			reloaded, err := store.reload()
+			if err != nil {
+				errorHandling.Report(err, "Failed reloading configuration, keeping the current one")
+				continue
+			}
+
+			if reloaded {
+				_, version, _ := store.status()
+				log.Printf("Configuration reloaded, version %d", version)
+			}
+		}
+	}
+}
+
+// filesFingerprint hashes config.json, tokens.json, every services/*.json
+// and every profile's overlays, so edits, new files and removed files are all noticed.
+// Secrets aren't hashed: a changed secret is picked up with the next change
+// of the files.
+func filesFingerprint() (string, error) {
+	files, err := filepath.Glob("services/*.json")
+	if err != nil {
+		return "", err
+	}
+
+	overlays, err := filepath.Glob(filepath.Join(profilesDir, "*", "*.json"))
+	if err != nil {
+		return "", err
+	}
+
+	serviceOverlays, err := filepath.Glob(filepath.Join(profilesDir, "*", "services", "*.json"))
+	if err != nil {
+		return "", err
+	}
+
+	files = append(files, "config.json")
+	files = append(files, overlays...)
+	files = append(files, serviceOverlays...)
+
+	if _, err := os.Stat(tokensFile); err == nil {
+		files = append(files, tokensFile)
+	}
+	sort.Strings(files)
+
+	hash := sha256.New()
+
+	for _, file := range files {
+		content, err := os.ReadFile(file)
+		if err != nil {
+			return "", fmt.Errorf("config/filesFingerprint - Fail to read %s, error: %w", file, err)
+		}
+
+		fmt.Fprintf(hash, "%s\x00%d\x00", file, len(content))
+		hash.Write(content)
+	}
+
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
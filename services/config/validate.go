@@ -0,0 +1,256 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"sarasa/libs/configHandling"
+	"sarasa/schemas"
+)
+
+// validateCommand runs `config validate`: it checks config.json and
+// services/*.json of every profile offline, prints every problem and returns
+// the exit code.
+func validateCommand() int {
+	profiles, err := listProfiles()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Could not validate configuration: %s\n", err)
+		return 2
+	}
+
+	problems, err := validateProfiles(profiles)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Could not validate configuration: %s\n", err)
+		return 2
+	}
+
+	if len(problems) == 0 {
+		fmt.Println("Configuration is valid")
+		return 0
+	}
+
+	fmt.Printf("Configuration has %d problems:\n", len(problems))
+	for _, problem := range problems {
+		fmt.Printf("  - %s\n", problem)
+	}
+
+	return 1
+}
+
+// validateProfiles validates every profile, prefixing the problems of the
+// profiles other than base with their name.
+func validateProfiles(profiles []string) ([]string, error) {
+	var problems []string
+
+	for _, profile := range profiles {
+		profileProblems, err := validateFiles(profile, nil)
+		if err != nil {
+			return nil, fmt.Errorf("profile %s: %w", profile, err)
+		}
+
+		for _, problem := range profileProblems {
+			if profile != baseProfile {
+				problem = "[" + profile + "] " + problem
+			}
+
+			problems = append(problems, problem)
+		}
+	}
+
+	return problems, nil
+}
+
+// validateFiles checks the dependency and alias graph of a profile's
+// config.json, then the configuration every service gets against the
+// sections it depends on. overrides replace the configuration of services,
+// e.g. to validate a change before saving it.
+func validateFiles(profile string, overrides map[string]interface{}) ([]string, error) {
+	config, err := readConfigFile(profile)
+	if err != nil {
+		return nil, err
+	}
+
+	problems := validateGraph(config)
+
+	configMap := make(map[string]interface{})
+	for _, s := range config.Services {
+		sConfig, err := getServiceConfig(profile, s)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("services/%s.json: %s", s, err))
+			continue
+		}
+
+		if override, ok := overrides[s]; ok {
+			sConfig = override
+		}
+
+		configMap[s] = sConfig
+		problems = append(problems, checkSecretReferences(sConfig, "services/"+s+".json")...)
+	}
+
+	if profile == baseProfile {
+		problems = append(problems, validateTokens(config)...)
+	}
+
+	configurationMap, err := mergeConfig(config, configMap)
+	if err != nil {
+		return append(problems, err.Error()), nil
+	}
+
+	for _, s := range config.Services {
+		sections := append([]string{serviceSection(config, s)}, config.Dependencies[s]...)
+
+		for _, problem := range validateService(configurationMap[s], sections) {
+			problems = append(problems, s+": "+problem)
+		}
+	}
+
+	return problems, nil
+}
+
+// validateTokens checks tokens.json only has tokens of configured services.
+func validateTokens(config configFile) []string {
+	tokens, err := readTokens()
+	if err != nil {
+		return []string{err.Error()}
+	}
+
+	listed := make(map[string]bool, len(config.Services))
+	for _, s := range config.Services {
+		listed[s] = true
+	}
+
+	var problems []string
+
+	for _, s := range sortedKeys(tokens) {
+		if !listed[s] {
+			problems = append(problems, fmt.Sprintf("%s: %s has a token but isn't listed in services", tokensFile, s))
+		}
+	}
+
+	return problems
+}
+
+// validateGraph checks that config.json only refers to listed services and
+// that every dependency has a configuration file.
+func validateGraph(config configFile) []string {
+	var problems []string
+
+	listed := make(map[string]bool, len(config.Services))
+	for _, s := range config.Services {
+		if listed[s] {
+			problems = append(problems, fmt.Sprintf("services: %s is listed twice", s))
+		}
+
+		listed[s] = true
+	}
+
+	for _, s := range sortedKeys(config.Dependencies) {
+		if !listed[s] {
+			problems = append(problems, fmt.Sprintf("dependencies: %s isn't listed in services", s))
+		}
+
+		seen := make(map[string]bool)
+
+		for _, dependency := range config.Dependencies[s] {
+			switch {
+			case dependency == s:
+				problems = append(problems, fmt.Sprintf("dependencies: %s depends on itself", s))
+			case seen[dependency]:
+				problems = append(problems, fmt.Sprintf("dependencies: %s depends on %s twice", s, dependency))
+			case !listed[dependency]:
+				problems = append(problems, fmt.Sprintf("dependencies: %s depends on %s, which isn't listed in services", s, dependency))
+			case !fileExists(dependency):
+				problems = append(problems, fmt.Sprintf("dependencies: %s depends on %s, which has no services/%s.json", s, dependency, dependency))
+			}
+
+			seen[dependency] = true
+		}
+
+		if alias, ok := config.Aliases[s]; ok && seen[alias] {
+			problems = append(problems, fmt.Sprintf("aliases: %s is aliased to its dependency %s", s, alias))
+		}
+	}
+
+	for _, s := range sortedKeys(config.Aliases) {
+		if !listed[s] {
+			problems = append(problems, fmt.Sprintf("aliases: %s isn't listed in services", s))
+		}
+
+		if config.Aliases[s] == "" {
+			problems = append(problems, fmt.Sprintf("aliases: %s has an empty alias", s))
+		}
+	}
+
+	files, err := filepath.Glob("services/*.json")
+	if err != nil {
+		return append(problems, err.Error())
+	}
+
+	for _, file := range files {
+		if s := strings.TrimSuffix(filepath.Base(file), ".json"); !listed[s] {
+			problems = append(problems, fmt.Sprintf("%s: %s isn't listed in services, the file is ignored", file, s))
+		}
+	}
+
+	return problems
+}
+
+// validateService decodes a service's merged configuration like
+// configHandling.LoadConfig does and validates its sections.
+func validateService(merged map[string]interface{}, sections []string) []string {
+	body, err := json.Marshal(merged)
+	if err != nil {
+		return []string{err.Error()}
+	}
+
+	var c schemas.Config
+	if err := json.Unmarshal(body, &c); err != nil {
+		return []string{fmt.Sprintf("can't be decoded: %s", err)}
+	}
+
+	err = configHandling.Validate(c, sections...)
+
+	var validationErr *configHandling.ValidationError
+	if !errors.As(err, &validationErr) {
+		return nil
+	}
+
+	problems := make([]string, 0, len(validationErr.Problems))
+	for _, problem := range validationErr.Problems {
+		problems = append(problems, problem.String())
+	}
+
+	return problems
+}
+
+// serviceSection is the section a service gets its own configuration in.
+func serviceSection(config configFile, s string) string {
+	if alias, ok := config.Aliases[s]; ok {
+		return alias
+	}
+
+	return s
+}
+
+func fileExists(s string) bool {
+	_, err := os.Stat(fmt.Sprintf("services/%s.json", s))
+
+	return err == nil
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+
+	sort.Strings(keys)
+
+	return keys
+}
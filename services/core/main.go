@@ -1,11 +1,13 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"log"
 	"time"
 
-	"sarasa/libs/signals"
+	"sarasa/libs/lifecycle"
 
 	"sarasa/libs/configHandling"
 
@@ -25,6 +27,19 @@ var configuration schemas.Config
 var postgresSingleton postgres.Client
 var influxSingleton influxdb.Client
 var rabbitMQSingleton rabbitMQ.Client
+var healthSingleton lifecycle.Health
+var lifecycleManager = lifecycle.NewManager(lifecycle.DefaultDrainTimeout)
+
+// consumerTag identifies the providers consumer so it can be cancelled on
+// shutdown.
+const consumerTag = "core"
+
+// lastRun beats every time a providers list is saved.
+var lastRun lifecycle.Heartbeat
+
+// consumerDone is closed when the providers consumer stops, e.g. because the
+// broker went away.
+var consumerDone = make(chan struct{})
 
 var availableZones map[string]int
 var availableSources map[string]int
@@ -32,18 +47,22 @@ var availableSources map[string]int
 func init() {
 	var err error
 
-	errorHandling.FailOnError(configHandling.LoadConfig(&configuration, "core"), "Failed to load configuration")
+	errorHandling.FailOnError(
+		configHandling.LoadConfig(&configuration, "core",
+			configHandling.Health, configHandling.Influx, configHandling.Postgres, configHandling.RabbitMQ),
+		"Failed to load configuration")
 
 	/**
 	 * Postgres Singleton
 	 */
 	errorHandling.FailOnError(
 		postgresSingleton.Init(configuration.Postgres), "Could not initialize PostgresSingleton")
+	lifecycleManager.AddService(&postgresSingleton)
 
-	availableZones, err = postgresSingleton.GetZones()
+	availableZones, err = postgresSingleton.GetZones(context.Background())
 	errorHandling.FailOnError(err, "Could not load zones")
 
-	availableSources, err = postgresSingleton.GetSources()
+	availableSources, err = postgresSingleton.GetSources(context.Background())
 	errorHandling.FailOnError(err, "Could not load sources")
 
 	/**
@@ -51,24 +70,53 @@ func init() {
 	 */
 	errorHandling.FailOnError(
 		rabbitMQSingleton.Init(configuration.RabbitMQ), "Could not initialize rabbitMQSingleton")
+	lifecycleManager.AddService(&rabbitMQSingleton)
 
 	/**
 	 * InfluxDB Singleton
 	 */
 	errorHandling.LogOnError(
 		influxSingleton.Init(configuration.Influx), "Could not initialize InfluxSingleton")
+	lifecycleManager.AddService(&influxSingleton)
+
+	postgresSingleton.SetQueryObserver(influxSingleton.QueryObserver("core"))
+
+	influxSingleton.ObserveService("core")
+
+	/**
+	 * Health checks
+	 */
+	healthSingleton.AddReadinessCheck("lifecycle", lifecycleManager.ReadyCheck)
+	healthSingleton.AddReadinessCheck("config", configHandling.LoadedCheck)
+	healthSingleton.AddReadinessCheck("postgres", lifecycle.ErrorCheck(postgresSingleton.Ping))
+	healthSingleton.AddReadinessCheck("rabbitMQ", lifecycle.ErrorCheck(rabbitMQSingleton.Ping))
+	healthSingleton.AddOptionalCheck("influxDB", lifecycle.ErrorCheck(influxSingleton.Ping))
+	healthSingleton.AddOptionalCheck("lastRun", lastRun.Check(0))
+	healthSingleton.AddOptionalCheck("postgresBreaker", postgresSingleton.Breaker().HealthCheck)
+	healthSingleton.AddOptionalCheck("influxDBBreaker", influxSingleton.Breaker().HealthCheck)
+	healthSingleton.AddLivenessCheck("consumer", func(ctx context.Context) (string, error) {
+		select {
+		case <-consumerDone:
+			return "", errors.New("providers consumer stopped")
+		default:
+			return "", nil
+		}
+	})
+
+	go func() {
+		errorHandling.LogOnError(healthSingleton.Serve(configuration.Health), "Health server stopped")
+	}()
+	lifecycleManager.AddService(&healthSingleton)
 
 	/**
 	 * Signal handling
 	 */
-	signals.SignalHandler(rabbitMQSingleton, postgresSingleton, influxSingleton)
+	lifecycleManager.HandleSignals()
 }
 
 func main() {
 	var err error
 
-	forever := make(chan bool)
-
 	influxTags := map[string]string{"run_uuid": uuid.New().String()}
 	influxFields := map[string]interface{}{}
 
@@ -86,6 +134,16 @@ func main() {
 		"Failed to publish refresh message",
 	)
 
+	errorHandling.FailOnError(
+		rabbitMQSingleton.Channel.ExchangeDeclare(
+			schemas.ProvidersSavedExchange, "fanout", true, false, false, false, nil),
+		"Failed to declare providers saved exchange")
+
+	errorHandling.FailOnError(
+		rabbitMQSingleton.Channel.ExchangeDeclare(
+			schemas.RunStatusExchange, "fanout", true, false, false, false, nil),
+		"Failed to declare run status exchange")
+
 	/**
 	 * Declaring queue to consume
 	 */
@@ -95,11 +153,19 @@ func main() {
 	errorHandling.FailOnError(err, "Failed to declare queue")
 
 	messages, err := rabbitMQSingleton.Channel.Consume(
-		providersQueue.Name, "", true, false, false, false, nil,
+		providersQueue.Name, consumerTag, true, false, false, false, nil,
 	)
 	errorHandling.FailOnError(err, "Failed to start consumer")
 
-	go func() {
+	lifecycleManager.AddStopper("providers consumer", func(ctx context.Context) error {
+		return rabbitMQSingleton.Channel.Cancel(consumerTag, false)
+	})
+
+	// Once cancelled, the consumer still gets the deliveries already sent by
+	// the broker and the loop ends when they are processed.
+	lifecycleManager.Go(func(ctx context.Context) {
+		defer close(consumerDone)
+
 		var err error
 		var providers []schemas.Provider
 		var sanitizedProviders []schemas.Provider
@@ -109,7 +175,7 @@ func main() {
 
 			err = json.Unmarshal(d.Body, &providers)
 			if err != nil {
-				errorHandling.LogOnError(err, "Fail to unmarshal message")
+				errorHandling.Report(errorHandling.NewInvalidInput(err), "Fail to unmarshal message")
 				continue
 			}
 
@@ -117,35 +183,122 @@ func main() {
 
 			log.Printf("Received %d providers", receivedProvidersCount)
 
-			invalidProvidersCount := 0
+			run := newRun(d, startTime)
+			run.ReceivedProvidersCount = receivedProvidersCount
+
+			var decisions postgres.QuarantineDecisions
+
+			if receivedProvidersCount > 0 {
+				run.Source = providers[0].Source
+
+				// Without the decisions every invalid provider is
+				// quarantined, SaveRun still skipping the decided ones.
+				decisions, err = postgresSingleton.GetQuarantineDecisions(lifecycleManager.WorkContext(), run.Source)
+				if err != nil {
+					errorHandling.Report(err, "Could not get quarantine decisions")
+				}
+			}
+
+			// Providers send an empty message with the reason when they
+			// didn't scrape, e.g. because the source is down.
+			if runErr, ok := d.Headers[schemas.RunErrorHeader].(string); ok && runErr != "" {
+				finishRun(run, schemas.RunSourceUnavailable, errors.New(runErr))
+				continue
+			}
+
 			for i := 0; i < receivedProvidersCount; i++ {
-				if isProviderInvalid(providers[i]) {
-					invalidProvidersCount++
+				reason := invalidProviderReason(providers[i])
+				decision := decisions.Of(providers[i])
+
+				// Approved providers are saved despite being invalid, as long
+				// as they still have a zone to be listed in.
+				if reason == "" || decision == schemas.QuarantineApproved && providers[i].Place != "" {
+					sanitizedProviders = append(sanitizedProviders, providers[i])
 					continue
 				}
 
-				sanitizedProviders = append(sanitizedProviders, providers[i])
+				run.InvalidProvidersCount++
+				run.InvalidReasons[reason]++
+
+				// Scrapers return empty providers for the ones they ignore on
+				// purpose, those aren't worth a review. Neither are the
+				// rejected ones.
+				if decision != schemas.QuarantineRejected && (providers[i].Name != "" || providers[i].Phone != "") {
+					run.Quarantined = append(run.Quarantined, schemas.QuarantinedProvider{
+						Provider: providers[i],
+						Reason:   reason,
+					})
+				}
 			}
 
-			log.Printf("Detected %d invalid providers", invalidProvidersCount)
+			log.Printf("Detected %d invalid providers", run.InvalidProvidersCount)
 
 			if len(sanitizedProviders) == 0 {
+				finishRun(run, schemas.RunSkipped, nil)
+
 				continue
 			}
 
 			influxTags["source"] = sanitizedProviders[0].Source
 			influxFields["providersCount"] = len(sanitizedProviders)
 
-			err = postgresSingleton.SaveProvidersList(sanitizedProviders, availableZones, availableSources)
-			errorHandling.FailOnError(err, "Error saving providers")
+			var changes []schemas.ProviderChange
+
+			// Saving is retried on transient failures, e.g. a deadlock or a
+			// dropped connection.
+			err = postgresSingleton.Retry(lifecycleManager.WorkContext(), func(ctx context.Context) error {
+				var err error
+				changes, err = postgresSingleton.SaveProvidersList(ctx, sanitizedProviders, availableZones, availableSources)
+
+				return err
+			})
+			if err != nil {
+				errorHandling.Report(err, "Error saving providers")
+				finishRun(run, schemas.RunFailed, err)
+
+				sanitizedProviders = nil
+
+				errorHandling.LogOnError(
+					influxSingleton.Send("core_process", influxTags, map[string]interface{}{
+						"receivedProvidersCount": receivedProvidersCount,
+						"elapsed":                time.Since(startTime).Milliseconds(),
+						"success":                false,
+					}),
+					"Could not write to InfluxDB")
+
+				continue
+			}
+
+			run.SavedProvidersCount = len(sanitizedProviders)
+
+			savedEvent := schemas.ProvidersSavedEvent{
+				Source:         sanitizedProviders[0].Source,
+				ProvidersCount: len(sanitizedProviders),
+				SavedAt:        time.Now(),
+				Changes:        changes,
+			}
 
 			sanitizedProviders = nil
 
-			availableZones, err = postgresSingleton.GetZones()
-			errorHandling.FailOnError(err, "Could not load zones")
+			// On failure the previous maps are kept, SaveProvidersList
+			// creates whatever zone or source they miss.
+			if zones, err := postgresSingleton.GetZones(lifecycleManager.WorkContext()); err != nil {
+				errorHandling.Report(err, "Could not reload zones")
+			} else {
+				availableZones = zones
+			}
+
+			if sources, err := postgresSingleton.GetSources(lifecycleManager.WorkContext()); err != nil {
+				errorHandling.Report(err, "Could not reload sources")
+			} else {
+				availableSources = sources
+			}
 
-			availableSources, err = postgresSingleton.GetSources()
-			errorHandling.FailOnError(err, "Could not load sources")
+			errorHandling.Report(
+				publishProvidersSaved(savedEvent), "Failed to publish providers saved event")
+
+			finishRun(run, schemas.RunSaved, nil)
+			lastRun.Beat()
 
 			influxFields = map[string]interface{}{
 				"receivedProvidersCount": receivedProvidersCount,
@@ -153,50 +306,115 @@ func main() {
 				"success":                true,
 			}
 
-			go errorHandling.LogOnError(
+			errorHandling.LogOnError(
 				influxSingleton.Send("core_process", influxTags, influxFields),
 				"Could not write to InfluxDB")
 
 			log.Printf("End. Elapsed time %s\n", time.Since(startTime))
 		}
-	}()
+	})
 
 	log.Printf("Waiting for messages. To exit press CTRL+C")
-	<-forever
+	lifecycleManager.Wait()
+}
+
+func publishProvidersSaved(event schemas.ProvidersSavedEvent) error {
+	return publishEvent(schemas.ProvidersSavedExchange, event)
+}
+
+// newRun starts a run for a providers message, using the run uuid and start
+// time set by the provider when present.
+func newRun(d amqp.Delivery, receivedAt time.Time) schemas.Run {
+	run := schemas.Run{
+		UUID:           uuid.New().String(),
+		StartedAt:      receivedAt,
+		InvalidReasons: make(map[string]int),
+	}
+
+	if runUUID, ok := d.Headers[schemas.RunUUIDHeader].(string); ok && runUUID != "" {
+		run.UUID = runUUID
+	}
+
+	if source, ok := d.Headers[schemas.RunSourceHeader].(string); ok {
+		run.Source = source
+	}
+
+	if startedAt, ok := d.Headers[schemas.RunStartedAtHeader].(string); ok {
+		if t, err := time.Parse(time.RFC3339Nano, startedAt); err == nil {
+			run.StartedAt = t
+		}
+	}
+
+	return run
+}
+
+// finishRun stores the run and announces its status.
+func finishRun(run schemas.Run, status string, err error) {
+	run.Status = status
+	run.FinishedAt = time.Now()
+
+	if err != nil {
+		run.Error = err.Error()
+	}
+
+	_, saveErr := postgresSingleton.SaveRun(lifecycleManager.WorkContext(), run)
+	errorHandling.Report(saveErr, "Failed to save run")
+
+	errorHandling.Report(
+		publishEvent(schemas.RunStatusExchange, schemas.RunStatusEvent{
+			Source:                 run.Source,
+			Status:                 run.Status,
+			ReceivedProvidersCount: run.ReceivedProvidersCount,
+			InvalidProvidersCount:  run.InvalidProvidersCount,
+			Error:                  run.Error,
+			At:                     run.FinishedAt,
+		}),
+		"Failed to publish run status event")
+}
+
+func publishEvent(exchange string, event interface{}) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	return rabbitMQSingleton.Retry(lifecycleManager.WorkContext(), func(ctx context.Context) error {
+		return rabbitMQSingleton.Channel.Publish(
+			exchange, "", false, false, amqp.Publishing{
+				ContentType: "application/json",
+				Body:        body,
+			})
+	})
 }
 
-func isProviderInvalid(provider schemas.Provider) bool {
-	status := false
+// invalidProviderReason returns why the provider can't be saved, or an empty
+// string when it's valid.
+func invalidProviderReason(provider schemas.Provider) string {
 	reason := ""
 
 	if len(provider.Phone) != 10 {
 		reason = "phone.length != 10"
-		status = true
 	}
 
 	if provider.Phone == "vacaciones" {
 		reason = "phone = 'vacaciones"
-		status = true
 	}
 
 	if provider.Name == "" {
 		reason = "name empty"
-		status = true
 	}
 
 	if provider.Place == "" {
 		reason = "place empty"
-		status = true
 	}
 
 	if len(provider.Pics) == 0 {
 		reason = "no pics"
-		status = true
 	}
 
-	if status {
+	if reason != "" {
 		log.Printf("invalid provider: %#v - %s", provider, reason)
 	}
 
-	return status
+	return reason
 }
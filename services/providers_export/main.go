@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+
+	"sarasa/libs/providersExport"
+	"sarasa/libs/showcaseClient"
+)
+
+// Exports the providers catalog through GET /export of showcase_server, e.g.:
+//
+//	providers_export -format xlsx -zone Palermo -out palermo.xlsx
+func main() {
+	if err := run(); err != nil {
+		fmt.Fprintf(os.Stderr, "Could not export providers: %s\n", err)
+		os.Exit(1)
+	}
+}
+
+func run() (err error) {
+	server := flag.String("server", envOr("SHOWCASE_SERVER_URL", "http://localhost:8080"), "showcase_server base URL")
+	apiKey := flag.String("api-key", os.Getenv("SHOWCASE_API_KEY"), "API key with the export scope")
+	format := flag.String("format", providersExport.FormatCSV, "csv, jsonl or xlsx")
+	out := flag.String("out", "", "output file, stdout when empty")
+
+	var params showcaseClient.ListProvidersParams
+	flag.StringVar(&params.Zone, "zone", "", "only providers of this zone")
+	flag.StringVar(&params.Source, "source", "", "only providers of this source")
+	flag.StringVar(&params.Query, "q", "", "name search")
+	flag.StringVar(&params.Sort, "sort", "", "id, name or updated, \"-\" prefix for descending")
+	flag.Parse()
+
+	var w io.Writer = os.Stdout
+	if *out != "" {
+		var f *os.File
+
+		f, err = os.Create(*out)
+		if err != nil {
+			return err
+		}
+
+		// A failed export leaves no partial file behind.
+		defer func() {
+			err = errors.Join(err, f.Close())
+			if err != nil {
+				os.Remove(*out)
+			}
+		}()
+
+		w = f
+	}
+
+	// Exports stream for as long as the catalog takes, so there's no timeout
+	// besides the server's.
+	client := showcaseClient.New(*server, *apiKey, &http.Client{})
+
+	return client.ExportProviders(context.Background(), w, *format, params)
+}
+
+func envOr(key, fallback string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+
+	return fallback
+}
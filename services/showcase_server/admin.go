@@ -0,0 +1,167 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/streadway/amqp"
+	"sarasa/libs/postgres"
+	"sarasa/schemas"
+)
+
+const (
+	defaultRunsLimit = 50
+	maxRunsLimit     = 200
+)
+
+// triggerRefresh serves POST /admin/refresh. An optional JSON body
+// {"source": "..."} limits the refresh to a single source.
+func triggerRefresh(c *gin.Context) {
+	var request schemas.RefreshRequest
+	if err := c.ShouldBindJSON(&request); err != nil && !errors.Is(err, io.EOF) {
+		abortWithError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	body, err := json.Marshal(request)
+	if err != nil {
+		abortWithError(c, http.StatusInternalServerError, errors.New("could not request refresh"))
+		return
+	}
+
+	err = rabbitMQSingleton.Retry(c.Request.Context(), func(ctx context.Context) error {
+		return rabbitMQSingleton.Channel.Publish(
+			"refresh", "", false, false, amqp.Publishing{ContentType: "application/json", Body: body})
+	})
+	if err != nil {
+		log.Printf("Could not publish refresh message - error: %s", err)
+		abortWithError(c, http.StatusServiceUnavailable, errors.New("could not request refresh"))
+		return
+	}
+
+	c.JSON(http.StatusAccepted, request)
+}
+
+// listRuns serves GET /admin/runs?source=&limit=.
+func listRuns(c *gin.Context) {
+	limit := defaultRunsLimit
+	if v, ok := c.GetQuery("limit"); ok {
+		var err error
+
+		limit, err = strconv.Atoi(v)
+		if err != nil || limit <= 0 || limit > maxRunsLimit {
+			abortWithError(c, http.StatusBadRequest, errors.New("limit must be between 1 and "+strconv.Itoa(maxRunsLimit)))
+			return
+		}
+	}
+
+	runs, err := postgresSingleton.ListRuns(c.Request.Context(), c.Query("source"), limit)
+	if err != nil {
+		log.Printf("Could not list runs - error: %s", err)
+		abortWithError(c, http.StatusInternalServerError, errors.New("could not list runs"))
+		return
+	}
+
+	c.JSON(http.StatusOK, runs)
+}
+
+// getRun serves GET /admin/runs/:id, quarantined providers included.
+func getRun(c *gin.Context) {
+	runID, ok := positiveIDParam(c)
+	if !ok {
+		return
+	}
+
+	run, err := postgresSingleton.GetRun(c.Request.Context(), runID)
+	if errors.Is(err, postgres.ErrNotFound) {
+		abortWithError(c, http.StatusNotFound, errors.New("run not found"))
+		return
+	}
+
+	if err != nil {
+		log.Printf("Could not get run %d - error: %s", runID, err)
+		abortWithError(c, http.StatusInternalServerError, errors.New("could not get run"))
+		return
+	}
+
+	c.JSON(http.StatusOK, run)
+}
+
+// approveQuarantined serves POST /admin/quarantine/:id/approve.
+func approveQuarantined(c *gin.Context) {
+	quarantinedID, ok := positiveIDParam(c)
+	if !ok {
+		return
+	}
+
+	provider, err := postgresSingleton.ApproveQuarantined(c.Request.Context(), quarantinedID)
+	if !quarantineDecided(c, quarantinedID, err) {
+		return
+	}
+
+	// Let every replica refresh its cache and live subscribers know.
+	publishErr := publishProvidersSaved(schemas.ProvidersSavedEvent{
+		Source:  provider.Source,
+		SavedAt: time.Now(),
+		Changes: []schemas.ProviderChange{{Type: schemas.ProviderCreated, Provider: provider}},
+	})
+	if publishErr != nil {
+		log.Printf("Could not publish providers saved event - error: %s", publishErr)
+	}
+
+	c.JSON(http.StatusOK, provider)
+}
+
+// rejectQuarantined serves POST /admin/quarantine/:id/reject.
+func rejectQuarantined(c *gin.Context) {
+	quarantinedID, ok := positiveIDParam(c)
+	if !ok {
+		return
+	}
+
+	err := postgresSingleton.RejectQuarantined(c.Request.Context(), quarantinedID)
+	if !quarantineDecided(c, quarantinedID, err) {
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// quarantineDecided answers the error of an approve or reject, if any.
+func quarantineDecided(c *gin.Context, quarantinedID int, err error) bool {
+	switch {
+	case err == nil:
+		return true
+	case errors.Is(err, postgres.ErrNotFound):
+		abortWithError(c, http.StatusNotFound, errors.New("quarantined provider not found"))
+	case errors.Is(err, postgres.ErrAlreadyDecided):
+		abortWithError(c, http.StatusConflict, err)
+	case errors.Is(err, postgres.ErrNoPlace):
+		abortWithError(c, http.StatusBadRequest, errors.New("quarantined provider has no place, it can only be rejected"))
+	default:
+		log.Printf("Could not decide quarantined provider %d - error: %s", quarantinedID, err)
+		abortWithError(c, http.StatusInternalServerError, errors.New("could not decide quarantined provider"))
+	}
+
+	return false
+}
+
+func publishProvidersSaved(event schemas.ProvidersSavedEvent) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	return rabbitMQSingleton.Channel.Publish(
+		schemas.ProvidersSavedExchange, "", false, false, amqp.Publishing{
+			ContentType: "application/json",
+			Body:        body,
+		})
+}
@@ -0,0 +1,461 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"sarasa/libs/postgres"
+)
+
+const (
+	scopeRead   = "read"
+	scopeExport = "export"
+	scopeAdmin  = "admin"
+
+	apiKeyContextKey = "apiKey"
+	apiKeyCacheTTL   = time.Minute
+)
+
+var validScopes = map[string]bool{scopeRead: true, scopeExport: true, scopeAdmin: true}
+
+// bootstrapKeyID identifies the configured admin key in rate limiting, which
+// applies to it like to any key without its own limit.
+const bootstrapKeyID = 0
+
+// apiKeyCache avoids a Postgres round trip per request. Entries live for
+// apiKeyCacheTTL, so a key revoked on another replica stops working within
+// that time; revocations on this one apply immediately.
+type apiKeyCache struct {
+	mu   sync.Mutex
+	keys map[string]cachedAPIKey
+}
+
+type cachedAPIKey struct {
+	key     postgres.APIKey
+	expires time.Time
+}
+
+var apiKeys = &apiKeyCache{keys: make(map[string]cachedAPIKey)}
+
+func (cache *apiKeyCache) get(keyHash string) (postgres.APIKey, bool) {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	cached, ok := cache.keys[keyHash]
+	if !ok || time.Now().After(cached.expires) {
+		delete(cache.keys, keyHash)
+		return postgres.APIKey{}, false
+	}
+
+	return cached.key, true
+}
+
+func (cache *apiKeyCache) set(keyHash string, key postgres.APIKey) {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	cache.keys[keyHash] = cachedAPIKey{key: key, expires: time.Now().Add(apiKeyCacheTTL)}
+}
+
+func (cache *apiKeyCache) forget(id int) {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	for keyHash, cached := range cache.keys {
+		if cached.key.ID == id {
+			delete(cache.keys, keyHash)
+		}
+	}
+}
+
+func hashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+
+	return hex.EncodeToString(sum[:])
+}
+
+func generateAPIKey() (string, error) {
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(raw), nil
+}
+
+// presentedAPIKey reads the key from "Authorization: Bearer", X-API-Key or,
+// for feed readers and EventSource which can't set headers, apiKey in the
+// query string.
+func presentedAPIKey(c *gin.Context) string {
+	if bearer := c.GetHeader("Authorization"); strings.HasPrefix(bearer, "Bearer ") {
+		return strings.TrimSpace(strings.TrimPrefix(bearer, "Bearer "))
+	}
+
+	if key := c.GetHeader("X-API-Key"); key != "" {
+		return key
+	}
+
+	return c.Query("apiKey")
+}
+
+// redactAPIKey hides the value of apiKey in requestURI, keeping the other
+// query parameters in their order.
+func redactAPIKey(requestURI string) string {
+	path, rawQuery, ok := strings.Cut(requestURI, "?")
+	if !ok {
+		return requestURI
+	}
+
+	params := strings.Split(rawQuery, "&")
+	for i, param := range params {
+		name, _, _ := strings.Cut(param, "=")
+		if name, err := url.QueryUnescape(name); err == nil && name == "apiKey" {
+			params[i] = "apiKey=***"
+		}
+	}
+
+	return path + "?" + strings.Join(params, "&")
+}
+
+// accessLogFormatter is gin's default access log line with the API key
+// redacted, as feed readers and EventSource present it in the query string.
+func accessLogFormatter(param gin.LogFormatterParams) string {
+	var statusColor, methodColor, resetColor string
+	if param.IsOutputColor() {
+		statusColor = param.StatusCodeColor()
+		methodColor = param.MethodColor()
+		resetColor = param.ResetColor()
+	}
+
+	if param.Latency > time.Minute {
+		param.Latency = param.Latency.Truncate(time.Second)
+	}
+
+	return fmt.Sprintf("[GIN] %v |%s %3d %s| %13v | %15s |%s %-7s %s %#v\n%s",
+		param.TimeStamp.Format("2006/01/02 - 15:04:05"),
+		statusColor, param.StatusCode, resetColor,
+		param.Latency,
+		param.ClientIP,
+		methodColor, param.Method, resetColor,
+		redactAPIKey(param.Path),
+		param.ErrorMessage,
+	)
+}
+
+func authenticate(c *gin.Context, presented string) (postgres.APIKey, error) {
+	adminKey := configuration.Showcase.AdminKey
+	if adminKey != "" && subtle.ConstantTimeCompare([]byte(presented), []byte(adminKey)) == 1 {
+		return postgres.APIKey{ID: bootstrapKeyID, Name: "bootstrap", Scopes: []string{scopeAdmin}}, nil
+	}
+
+	keyHash := hashAPIKey(presented)
+	if key, ok := apiKeys.get(keyHash); ok {
+		return key, nil
+	}
+
+	key, err := postgresSingleton.GetActiveAPIKey(c.Request.Context(), keyHash)
+	if err != nil {
+		return key, err
+	}
+
+	apiKeys.set(keyHash, key)
+
+	return key, nil
+}
+
+func hasScope(key postgres.APIKey, scope string) bool {
+	for _, s := range key.Scopes {
+		if s == scope || s == scopeAdmin {
+			return true
+		}
+	}
+
+	return false
+}
+
+// requireScope authenticates the request, checks the key has scope (admin
+// keys have every scope) and applies the key's rate limit.
+func requireScope(scope string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		presented := presentedAPIKey(c)
+		if presented == "" {
+			c.Header("WWW-Authenticate", `Bearer realm="showcase"`)
+			abortWithError(c, http.StatusUnauthorized, errors.New("missing API key"))
+			return
+		}
+
+		failedLimit := configuration.Showcase.FailedAuthPerMinute
+		if failedLimit > 0 {
+			if retryAfter := failedAuths.retryAfter(c.ClientIP(), failedLimit); retryAfter > 0 {
+				c.Header("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+				abortWithError(c, http.StatusTooManyRequests, errors.New("too many invalid API keys"))
+				return
+			}
+		}
+
+		key, err := authenticate(c, presented)
+		if errors.Is(err, postgres.ErrNotFound) {
+			if failedLimit > 0 {
+				failedAuths.fail(c.ClientIP(), failedLimit)
+			}
+
+			c.Header("WWW-Authenticate", `Bearer realm="showcase", error="invalid_token"`)
+			abortWithError(c, http.StatusUnauthorized, errors.New("invalid API key"))
+			return
+		}
+
+		if err != nil {
+			log.Printf("Could not check API key - error: %s", err)
+			abortWithError(c, http.StatusInternalServerError, errors.New("could not check API key"))
+			return
+		}
+
+		if !hasScope(key, scope) {
+			abortWithError(c, http.StatusForbidden, errors.New("API key lacks the "+scope+" scope"))
+			return
+		}
+
+		limit := key.RateLimitPerMinute
+		if limit == 0 {
+			limit = configuration.Showcase.DefaultRateLimitPerMinute
+		}
+
+		if limit > 0 {
+			allowed, remaining, retryAfter := rateLimiter.allow(key.ID, limit)
+
+			c.Header("X-RateLimit-Limit", strconv.Itoa(limit))
+			c.Header("X-RateLimit-Remaining", strconv.Itoa(remaining))
+
+			if !allowed {
+				c.Header("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+				abortWithError(c, http.StatusTooManyRequests, errors.New("rate limit exceeded"))
+				return
+			}
+		}
+
+		c.Set(apiKeyContextKey, key)
+		c.Next()
+	}
+}
+
+// keyRateLimiter is a token bucket per API key, refilled continuously at
+// limit tokens per minute with a burst of limit.
+type keyRateLimiter struct {
+	mu      sync.Mutex
+	buckets map[int]*tokenBucket
+}
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+var rateLimiter = &keyRateLimiter{buckets: make(map[int]*tokenBucket)}
+
+func (limiter *keyRateLimiter) allow(keyID, limit int) (bool, int, time.Duration) {
+	limiter.mu.Lock()
+	defer limiter.mu.Unlock()
+
+	now := time.Now()
+
+	bucket, ok := limiter.buckets[keyID]
+	if !ok {
+		bucket = &tokenBucket{tokens: float64(limit), last: now}
+		limiter.buckets[keyID] = bucket
+	}
+
+	bucket.refill(limit, now)
+
+	if bucket.tokens < 1 {
+		return false, 0, bucket.wait(limit)
+	}
+
+	bucket.tokens--
+
+	return true, int(bucket.tokens), 0
+}
+
+// refill adds the tokens earned since the last refill, up to limit.
+func (bucket *tokenBucket) refill(limit int, now time.Time) {
+	bucket.tokens = math.Min(float64(limit), bucket.tokens+now.Sub(bucket.last).Seconds()*float64(limit)/60)
+	bucket.last = now
+}
+
+// wait is the time until the bucket holds a token again.
+func (bucket *tokenBucket) wait(limit int) time.Duration {
+	return time.Duration((1 - bucket.tokens) / (float64(limit) / 60) * float64(time.Second))
+}
+
+// maxFailedAuthClients bounds the client IPs tracked by failedAuthLimiter.
+const maxFailedAuthClients = 10000
+
+// failedAuthLimiter is a token bucket per client IP spent by invalid API
+// keys, so guessing keys can't turn into a Postgres lookup per request.
+type failedAuthLimiter struct {
+	mu      sync.Mutex
+	buckets map[string]*tokenBucket
+}
+
+var failedAuths = &failedAuthLimiter{buckets: make(map[string]*tokenBucket)}
+
+// retryAfter is how long ip has to wait before presenting a key again, zero
+// when it may do it now.
+func (limiter *failedAuthLimiter) retryAfter(ip string, limit int) time.Duration {
+	limiter.mu.Lock()
+	defer limiter.mu.Unlock()
+
+	bucket, ok := limiter.buckets[ip]
+	if !ok {
+		return 0
+	}
+
+	bucket.refill(limit, time.Now())
+
+	if bucket.tokens >= 1 {
+		return 0
+	}
+
+	return bucket.wait(limit)
+}
+
+// fail spends a token of ip for an invalid key.
+func (limiter *failedAuthLimiter) fail(ip string, limit int) {
+	limiter.mu.Lock()
+	defer limiter.mu.Unlock()
+
+	now := time.Now()
+
+	bucket, ok := limiter.buckets[ip]
+	if !ok {
+		if len(limiter.buckets) >= maxFailedAuthClients {
+			limiter.prune(limit, now)
+		}
+
+		bucket = &tokenBucket{tokens: float64(limit), last: now}
+		limiter.buckets[ip] = bucket
+	}
+
+	bucket.refill(limit, now)
+	bucket.tokens = math.Max(0, bucket.tokens-1)
+}
+
+// prune forgets the clients whose bucket refilled, or every client when
+// none did.
+func (limiter *failedAuthLimiter) prune(limit int, now time.Time) {
+	for ip, bucket := range limiter.buckets {
+		bucket.refill(limit, now)
+
+		if bucket.tokens >= float64(limit) {
+			delete(limiter.buckets, ip)
+		}
+	}
+
+	if len(limiter.buckets) >= maxFailedAuthClients {
+		limiter.buckets = make(map[string]*tokenBucket)
+	}
+}
+
+type createAPIKeyRequest struct {
+	Name               string   `json:"name" binding:"required"`
+	Scopes             []string `json:"scopes" binding:"required"`
+	RateLimitPerMinute int      `json:"rateLimitPerMinute"`
+}
+
+type createdAPIKey struct {
+	postgres.APIKey
+	Key string `json:"key"`
+}
+
+// createAPIKey serves POST /admin/keys. The plain key is only ever returned
+// in this response.
+func createAPIKey(c *gin.Context) {
+	var request createAPIKeyRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		abortWithError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	if len(request.Scopes) == 0 {
+		abortWithError(c, http.StatusBadRequest, errors.New("at least one scope is required"))
+		return
+	}
+
+	for _, scope := range request.Scopes {
+		if !validScopes[scope] {
+			abortWithError(c, http.StatusBadRequest, errors.New("unknown scope "+scope))
+			return
+		}
+	}
+
+	if request.RateLimitPerMinute < 0 {
+		abortWithError(c, http.StatusBadRequest, errors.New("rateLimitPerMinute can't be negative"))
+		return
+	}
+
+	plain, err := generateAPIKey()
+	if err != nil {
+		log.Printf("Could not generate API key - error: %s", err)
+		abortWithError(c, http.StatusInternalServerError, errors.New("could not create API key"))
+		return
+	}
+
+	key, err := postgresSingleton.CreateAPIKey(
+		c.Request.Context(), request.Name, hashAPIKey(plain), request.Scopes, request.RateLimitPerMinute)
+	if err != nil {
+		log.Printf("Could not store API key - error: %s", err)
+		abortWithError(c, http.StatusInternalServerError, errors.New("could not create API key"))
+		return
+	}
+
+	c.JSON(http.StatusCreated, createdAPIKey{APIKey: key, Key: plain})
+}
+
+// listAPIKeys serves GET /admin/keys.
+func listAPIKeys(c *gin.Context) {
+	keys, err := postgresSingleton.ListAPIKeys(c.Request.Context())
+	if err != nil {
+		log.Printf("Could not list API keys - error: %s", err)
+		abortWithError(c, http.StatusInternalServerError, errors.New("could not list API keys"))
+		return
+	}
+
+	c.JSON(http.StatusOK, keys)
+}
+
+// revokeAPIKey serves DELETE /admin/keys/:id.
+func revokeAPIKey(c *gin.Context) {
+	id, ok := positiveIDParam(c)
+	if !ok {
+		return
+	}
+
+	err := postgresSingleton.RevokeAPIKey(c.Request.Context(), id)
+	if errors.Is(err, postgres.ErrNotFound) {
+		abortWithError(c, http.StatusNotFound, errors.New("API key not found"))
+		return
+	}
+
+	if err != nil {
+		log.Printf("Could not revoke API key %d - error: %s", id, err)
+		abortWithError(c, http.StatusInternalServerError, errors.New("could not revoke API key"))
+		return
+	}
+
+	apiKeys.forget(id)
+
+	c.Status(http.StatusNoContent)
+}
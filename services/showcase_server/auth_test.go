@@ -0,0 +1,50 @@
+package main
+
+import (
+	"net/http"
+	"regexp"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/require"
+)
+
+const apiKeyQuery = "FROM api_keys WHERE key_hash = $1 AND revoked_at IS NULL"
+
+func TestRedactAPIKey(t *testing.T) {
+	tests := map[string]string{
+		"/zones":                                   "/zones",
+		"/feeds/zones/Palermo.rss?apiKey=secret":   "/feeds/zones/Palermo.rss?apiKey=***",
+		"/events?since=3&apiKey=secret&apiKey=two": "/events?since=3&apiKey=***&apiKey=***",
+		"/events?api%4Bey=secret&since=3":          "/events?apiKey=***&since=3",
+		"/providers?zone=Palermo":                  "/providers?zone=Palermo",
+	}
+
+	for requestURI, expected := range tests {
+		require.Equal(t, expected, redactAPIKey(requestURI), requestURI)
+	}
+}
+
+// Once a client IP spends its invalid keys, it's turned away without looking
+// up the keys it presents.
+func TestInvalidAPIKeysAreLimitedPerClient(t *testing.T) {
+	r, mock := newTestRouter(t)
+	configuration.Showcase.FailedAuthPerMinute = 2
+
+	expectQuery(mock, apiKeyQuery).WillReturnRows(sqlmock.NewRows(nil))
+	mock.ExpectQuery(regexp.QuoteMeta(apiKeyQuery)).WillReturnRows(sqlmock.NewRows(nil))
+
+	for i := 0; i < 2; i++ {
+		recorder := serve(r, http.MethodGet, "/zones", http.Header{"X-Api-Key": {"guess"}})
+		require.Equal(t, http.StatusUnauthorized, recorder.Code)
+	}
+
+	recorder := serve(r, http.MethodGet, "/zones", http.Header{"X-Api-Key": {"guess"}})
+	require.Equal(t, http.StatusTooManyRequests, recorder.Code)
+	require.NotEmpty(t, recorder.Header().Get("Retry-After"))
+	requireDocumented(t, http.MethodGet, "/zones", recorder)
+
+	// X-Forwarded-For isn't trusted, so it doesn't reset the limit.
+	recorder = serve(r, http.MethodGet, "/zones", http.Header{"X-Api-Key": {"guess"}, "X-Forwarded-For": {"203.0.113.9"}})
+	require.Equal(t, http.StatusTooManyRequests, recorder.Code)
+}
@@ -0,0 +1,263 @@
+package main
+
+import (
+	"bytes"
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Bounds of the responses kept by catalogCache, the least recently used are
+// evicted first. Larger responses aren't cached.
+const (
+	maxCachedResponses     = 1000
+	maxCachedBytes         = 64 << 20
+	maxCachedResponseBytes = 1 << 20
+)
+
+// catalogCache keeps rendered GET responses until core reports a new save.
+// The catalog version is derived from the last save time of every source, so
+// ETags stay stable across restarts and replicas while nothing is saved.
+type catalogCache struct {
+	mu           sync.Mutex
+	version      string
+	lastModified time.Time
+	// responses indexes the elements of lru, most recently used first.
+	responses map[string]*list.Element
+	lru       *list.List
+	size      int
+}
+
+type cachedResponse struct {
+	key         string
+	contentType string
+	header      http.Header
+	body        []byte
+}
+
+// cachedHeaders are the response headers set by handlers that are replayed
+// with a cached response.
+var cachedHeaders = []string{"Link", nextCursorHeader}
+
+var responseCache = newCatalogCache()
+
+func newCatalogCache() *catalogCache {
+	return &catalogCache{responses: make(map[string]*list.Element), lru: list.New()}
+}
+
+// refresh reloads the catalog version from Postgres and drops every cached
+// response. On failure the version is cleared, which disables caching until
+// the next successful refresh.
+func (cache *catalogCache) refresh(ctx context.Context) error {
+	sources, err := postgresSingleton.GetSourcesSummary(ctx)
+
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	cache.responses = make(map[string]*list.Element)
+	cache.lru.Init()
+	cache.size = 0
+	cache.version = ""
+	cache.lastModified = time.Time{}
+
+	if err != nil {
+		return err
+	}
+
+	hash := sha256.New()
+	for _, source := range sources {
+		savedAt := ""
+		if source.LastSavedAt != nil {
+			savedAt = source.LastSavedAt.UTC().Format(time.RFC3339Nano)
+
+			if source.LastSavedAt.After(cache.lastModified) {
+				cache.lastModified = *source.LastSavedAt
+			}
+		}
+
+		fmt.Fprintf(hash, "%d:%s:%d:%s\n", source.ID, source.Name, source.ProvidersCount, savedAt)
+	}
+
+	cache.version = hex.EncodeToString(hash.Sum(nil))
+
+	return nil
+}
+
+func (cache *catalogCache) snapshot() (string, time.Time) {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	return cache.version, cache.lastModified
+}
+
+func (cache *catalogCache) get(version, key string) (cachedResponse, bool) {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	if version != cache.version {
+		return cachedResponse{}, false
+	}
+
+	element, ok := cache.responses[key]
+	if !ok {
+		return cachedResponse{}, false
+	}
+
+	cache.lru.MoveToFront(element)
+
+	return element.Value.(cachedResponse), true
+}
+
+func (cache *catalogCache) set(version string, response cachedResponse) {
+	if len(response.body) > maxCachedResponseBytes {
+		return
+	}
+
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	// A save landed while the handler was running, the response may be stale.
+	if version != cache.version {
+		return
+	}
+
+	if element, ok := cache.responses[response.key]; ok {
+		cache.size -= len(element.Value.(cachedResponse).body)
+		cache.lru.Remove(element)
+	}
+
+	cache.responses[response.key] = cache.lru.PushFront(response)
+	cache.size += len(response.body)
+
+	for cache.lru.Len() > maxCachedResponses || cache.size > maxCachedBytes {
+		oldest := cache.lru.Back()
+		evicted := cache.lru.Remove(oldest).(cachedResponse)
+
+		delete(cache.responses, evicted.key)
+		cache.size -= len(evicted.body)
+	}
+}
+
+// catalogRequestURI is the request URI without the API key, its query sorted,
+// so every client shares the cached responses and none sees another's key.
+func catalogRequestURI(u *url.URL) string {
+	query := u.Query()
+	query.Del("apiKey")
+
+	if len(query) == 0 {
+		return u.EscapedPath()
+	}
+
+	return u.EscapedPath() + "?" + query.Encode()
+}
+
+// catalogETag is unique per catalog version and request key.
+func catalogETag(version, key string) string {
+	hash := sha256.Sum256([]byte(version + "\x00" + key))
+
+	return `"` + hex.EncodeToString(hash[:16]) + `"`
+}
+
+// cacheMiddleware adds ETag and Last-Modified headers to catalog responses,
+// answers conditional requests with 304 and serves repeated requests from
+// memory.
+func cacheMiddleware(c *gin.Context) {
+	version, lastModified := responseCache.snapshot()
+	if version == "" || c.Request.Method != http.MethodGet {
+		c.Next()
+		return
+	}
+
+	// Feeds embed absolute URLs, so the scheme and host are part of the key.
+	key := requestBaseURL(c.Request) + catalogRequestURI(c.Request.URL)
+	etag := catalogETag(version, key)
+
+	c.Header("ETag", etag)
+	c.Header("Cache-Control", "no-cache")
+	if !lastModified.IsZero() {
+		c.Header("Last-Modified", lastModified.UTC().Format(http.TimeFormat))
+	}
+
+	if notModified(c.Request, etag, lastModified) {
+		c.AbortWithStatus(http.StatusNotModified)
+		return
+	}
+
+	if response, ok := responseCache.get(version, key); ok {
+		for name, values := range response.header {
+			c.Writer.Header()[name] = values
+		}
+
+		c.Data(http.StatusOK, response.contentType, response.body)
+		c.Abort()
+		return
+	}
+
+	recorder := &bodyRecorder{ResponseWriter: c.Writer}
+	c.Writer = recorder
+
+	c.Next()
+
+	if recorder.Status() == http.StatusOK {
+		header := make(http.Header)
+		for _, name := range cachedHeaders {
+			if values := recorder.Header().Values(name); len(values) > 0 {
+				header[http.CanonicalHeaderKey(name)] = values
+			}
+		}
+
+		responseCache.set(version, cachedResponse{
+			key:         key,
+			contentType: recorder.Header().Get("Content-Type"),
+			header:      header,
+			body:        recorder.body.Bytes(),
+		})
+	}
+}
+
+func notModified(r *http.Request, etag string, lastModified time.Time) bool {
+	if inm := r.Header.Get("If-None-Match"); inm != "" {
+		for _, candidate := range strings.Split(inm, ",") {
+			candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+			if candidate == etag || candidate == "*" {
+				return true
+			}
+		}
+
+		return false
+	}
+
+	if ims := r.Header.Get("If-Modified-Since"); ims != "" && !lastModified.IsZero() {
+		since, err := http.ParseTime(ims)
+
+		return err == nil && !lastModified.Truncate(time.Second).After(since)
+	}
+
+	return false
+}
+
+type bodyRecorder struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+func (w *bodyRecorder) Write(b []byte) (int, error) {
+	w.body.Write(b)
+
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *bodyRecorder) WriteString(s string) (int, error) {
+	w.body.WriteString(s)
+
+	return w.ResponseWriter.WriteString(s)
+}
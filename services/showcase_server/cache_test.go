@@ -0,0 +1,49 @@
+package main
+
+import (
+	"net/url"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCatalogCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	cache := newCatalogCache()
+	cache.version = "v1"
+
+	for i := 0; i < maxCachedResponses; i++ {
+		cache.set("v1", cachedResponse{key: strconv.Itoa(i), body: []byte("[]")})
+	}
+
+	_, ok := cache.get("v1", "0")
+	require.True(t, ok)
+
+	cache.set("v1", cachedResponse{key: "new", body: []byte("[]")})
+
+	require.Equal(t, maxCachedResponses, cache.lru.Len())
+
+	_, ok = cache.get("v1", "0")
+	require.True(t, ok, "recently used response was evicted")
+
+	_, ok = cache.get("v1", "1")
+	require.False(t, ok, "least recently used response was kept")
+}
+
+func TestCatalogCacheSkipsLargeAndStaleResponses(t *testing.T) {
+	cache := newCatalogCache()
+	cache.version = "v2"
+
+	cache.set("v2", cachedResponse{key: "large", body: make([]byte, maxCachedResponseBytes+1)})
+	cache.set("v1", cachedResponse{key: "stale", body: []byte("[]")})
+
+	require.Zero(t, cache.lru.Len())
+	require.Zero(t, cache.size)
+}
+
+func TestCatalogRequestURIDropsAPIKey(t *testing.T) {
+	u, err := url.Parse("/providers?zone=Palermo&apiKey=secret&limit=10")
+	require.NoError(t, err)
+
+	require.Equal(t, "/providers?limit=10&zone=Palermo", catalogRequestURI(u))
+}
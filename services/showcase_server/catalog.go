@@ -0,0 +1,99 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"sarasa/libs/postgres"
+)
+
+// getProvider serves GET /providers/:id.
+func getProvider(c *gin.Context) {
+	providerID, ok := positiveIDParam(c)
+	if !ok {
+		return
+	}
+
+	provider, err := postgresSingleton.GetProvider(c.Request.Context(), providerID)
+	if errors.Is(err, postgres.ErrNotFound) {
+		abortWithError(c, http.StatusNotFound, errors.New("provider not found"))
+		return
+	}
+
+	if err != nil {
+		log.Printf("Could not get provider %d - error: %s", providerID, err)
+		abortWithError(c, http.StatusInternalServerError, errors.New("could not get provider"))
+		return
+	}
+
+	c.JSON(http.StatusOK, provider)
+}
+
+// getProviderPics serves GET /providers/:id/pics.
+func getProviderPics(c *gin.Context) {
+	providerID, ok := positiveIDParam(c)
+	if !ok {
+		return
+	}
+
+	pics, err := postgresSingleton.GetProviderPics(c.Request.Context(), providerID)
+	if err != nil {
+		log.Printf("Could not get pics of provider %d - error: %s", providerID, err)
+		abortWithError(c, http.StatusInternalServerError, errors.New("could not get provider pics"))
+		return
+	}
+
+	if len(pics) == 0 {
+		exists, err := postgresSingleton.ProviderExists(c.Request.Context(), providerID)
+		if err != nil {
+			log.Printf("Could not check provider %d - error: %s", providerID, err)
+			abortWithError(c, http.StatusInternalServerError, errors.New("could not get provider pics"))
+			return
+		}
+
+		if !exists {
+			abortWithError(c, http.StatusNotFound, errors.New("provider not found"))
+			return
+		}
+	}
+
+	c.JSON(http.StatusOK, pics)
+}
+
+// listZones serves GET /zones.
+func listZones(c *gin.Context) {
+	zones, err := postgresSingleton.GetZonesSummary(c.Request.Context())
+	if err != nil {
+		log.Printf("Could not list zones - error: %s", err)
+		abortWithError(c, http.StatusInternalServerError, errors.New("could not list zones"))
+		return
+	}
+
+	c.JSON(http.StatusOK, zones)
+}
+
+// listSources serves GET /sources.
+func listSources(c *gin.Context) {
+	sources, err := postgresSingleton.GetSourcesSummary(c.Request.Context())
+	if err != nil {
+		log.Printf("Could not list sources - error: %s", err)
+		abortWithError(c, http.StatusInternalServerError, errors.New("could not list sources"))
+		return
+	}
+
+	c.JSON(http.StatusOK, sources)
+}
+
+// positiveIDParam parses the :id path parameter, answering 400 when invalid.
+func positiveIDParam(c *gin.Context) (int, bool) {
+	providerID, err := strconv.Atoi(c.Param("id"))
+	if err != nil || providerID <= 0 {
+		abortWithError(c, http.StatusBadRequest, errors.New("id must be a positive integer"))
+		return 0, false
+	}
+
+	return providerID, true
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/streadway/amqp"
+	"sarasa/libs/errorHandling"
+	"sarasa/schemas"
+)
+
+const (
+	eventsSubscriberBuffer = 64
+	eventsHeartbeat        = 15 * time.Second
+)
+
+// liveEvent is what gets streamed to /events subscribers. Zone is empty for
+// run status events, which are only filtered by source.
+type liveEvent struct {
+	Name   string
+	Zone   string
+	Source string
+	Data   interface{}
+}
+
+// eventsHub fans core events out to every connected subscriber. Slow
+// subscribers drop events instead of blocking the others.
+type eventsHub struct {
+	mu          sync.Mutex
+	subscribers map[chan liveEvent]struct{}
+}
+
+var liveEvents = &eventsHub{subscribers: make(map[chan liveEvent]struct{})}
+
+func (hub *eventsHub) subscribe() chan liveEvent {
+	hub.mu.Lock()
+	defer hub.mu.Unlock()
+
+	ch := make(chan liveEvent, eventsSubscriberBuffer)
+	hub.subscribers[ch] = struct{}{}
+
+	return ch
+}
+
+func (hub *eventsHub) unsubscribe(ch chan liveEvent) {
+	hub.mu.Lock()
+	defer hub.mu.Unlock()
+
+	delete(hub.subscribers, ch)
+}
+
+func (hub *eventsHub) publish(event liveEvent) {
+	hub.mu.Lock()
+	defer hub.mu.Unlock()
+
+	for ch := range hub.subscribers {
+		select {
+		case ch <- event:
+		default:
+			log.Printf("Dropping %s event for a slow subscriber", event.Name)
+		}
+	}
+}
+
+// streamEvents serves GET /events as Server-Sent Events. Optional zone and
+// source query parameters restrict the stream to matching events.
+//
+// Event names: provider.created, provider.updated, provider.removed and
+// run.status.
+func streamEvents(c *gin.Context) {
+	zone := c.Query("zone")
+	source := c.Query("source")
+
+	events := liveEvents.subscribe()
+	defer liveEvents.unsubscribe(events)
+
+	heartbeat := time.NewTicker(eventsHeartbeat)
+	defer heartbeat.Stop()
+
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	c.Header("X-Accel-Buffering", "no")
+
+	c.Stream(func(w io.Writer) bool {
+		select {
+		case <-c.Request.Context().Done():
+			return false
+		case <-lifecycleManager.Context().Done():
+			return false
+		case <-heartbeat.C:
+			_, err := fmt.Fprint(w, ": heartbeat\n\n")
+
+			return err == nil
+		case event := <-events:
+			if source != "" && event.Source != source {
+				return true
+			}
+
+			if zone != "" && event.Zone != "" && event.Zone != zone {
+				return true
+			}
+
+			c.SSEvent(event.Name, event.Data)
+
+			return true
+		}
+	})
+}
+
+// consumeCoreEvents listens to core's exchanges to keep the response cache
+// fresh and to feed the live events stream.
+func consumeCoreEvents() error {
+	providersSaved, err := consumeExchange(schemas.ProvidersSavedExchange)
+	if err != nil {
+		return err
+	}
+
+	runStatus, err := consumeExchange(schemas.RunStatusExchange)
+	if err != nil {
+		return err
+	}
+
+	lifecycleManager.Go(func(ctx context.Context) {
+		for d := range providersSaved {
+			var event schemas.ProvidersSavedEvent
+			if err := json.Unmarshal(d.Body, &event); err != nil {
+				errorHandling.Report(errorHandling.NewInvalidInput(err), "Fail to unmarshal providers saved event")
+				continue
+			}
+
+			log.Printf("Providers saved for source %s, refreshing cache", event.Source)
+
+			errorHandling.Report(
+				responseCache.refresh(lifecycleManager.WorkContext()), "Could not refresh response cache")
+
+			for _, change := range event.Changes {
+				liveEvents.publish(liveEvent{
+					Name:   "provider." + change.Type,
+					Zone:   change.Provider.Place,
+					Source: change.Provider.Source,
+					Data:   change.Provider,
+				})
+			}
+		}
+	})
+
+	lifecycleManager.Go(func(ctx context.Context) {
+		for d := range runStatus {
+			var event schemas.RunStatusEvent
+			if err := json.Unmarshal(d.Body, &event); err != nil {
+				errorHandling.Report(errorHandling.NewInvalidInput(err), "Fail to unmarshal run status event")
+				continue
+			}
+
+			liveEvents.publish(liveEvent{Name: "run.status", Source: event.Source, Data: event})
+		}
+	})
+
+	return nil
+}
+
+// consumeExchange binds an exclusive, auto-deleted queue to a fanout exchange.
+// The consumer is tagged with the exchange name and cancelled on shutdown.
+func consumeExchange(exchange string) (<-chan amqp.Delivery, error) {
+	err := rabbitMQSingleton.Channel.ExchangeDeclare(
+		exchange, "fanout", true, false, false, false, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	queue, err := rabbitMQSingleton.Channel.QueueDeclare("", false, true, true, false, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	err = rabbitMQSingleton.Channel.QueueBind(queue.Name, "", exchange, false, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	deliveries, err := rabbitMQSingleton.Channel.Consume(queue.Name, exchange, true, true, false, false, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	lifecycleManager.AddStopper(exchange+" consumer", func(ctx context.Context) error {
+		return rabbitMQSingleton.Channel.Cancel(exchange, false)
+	})
+
+	return deliveries, nil
+}
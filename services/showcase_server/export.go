@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"sarasa/libs/postgres"
+	"sarasa/libs/providersExport"
+	"sarasa/schemas"
+)
+
+// exportProviders serves GET /export?format=csv|jsonl|xlsx. It accepts the
+// same filters as GET /providers and streams every matching provider.
+func exportProviders(c *gin.Context) {
+	format := c.DefaultQuery("format", providersExport.FormatCSV)
+
+	filter, err := providersFilterFromQuery(c)
+	if err != nil {
+		abortWithError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	filter.Limit = postgres.MaxProvidersLimit
+
+	pages := func(ctx context.Context, cursor string) (schemas.ProvidersPage, error) {
+		filter.Cursor = cursor
+
+		return postgresSingleton.ListProviders(ctx, filter)
+	}
+
+	// Validate format and filters before any byte is written, so errors can
+	// still be reported with a proper status.
+	if _, err := providersExport.NewWriter(format, discardWriter{}); err != nil {
+		abortWithError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	first, err := pages(c.Request.Context(), "")
+	if errors.Is(err, postgres.ErrInvalidFilter) {
+		abortWithError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	if err != nil {
+		log.Printf("Could not export providers - error: %s", err)
+		abortWithError(c, http.StatusInternalServerError, errors.New("could not export providers"))
+		return
+	}
+
+	c.Header("Content-Type", providersExport.ContentType(format))
+	c.Header("Content-Disposition", fmt.Sprintf(
+		`attachment; filename="providers-%s.%s"`, time.Now().UTC().Format("20060102T150405Z"), format))
+	c.Status(http.StatusOK)
+
+	err = providersExport.Export(c.Request.Context(), c.Writer, format,
+		func(ctx context.Context, cursor string) (schemas.ProvidersPage, error) {
+			if cursor == "" {
+				return first, nil
+			}
+
+			return pages(ctx, cursor)
+		})
+	if err != nil {
+		// Headers are gone already, all that's left is cutting the stream.
+		log.Printf("Export interrupted - error: %s", err)
+		c.Abort()
+	}
+}
+
+type discardWriter struct{}
+
+func (discardWriter) Write(p []byte) (int, error) {
+	return len(p), nil
+}
@@ -0,0 +1,232 @@
+package main
+
+import (
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"log"
+	"mime"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"sarasa/libs/postgres"
+)
+
+const feedEntriesLimit = 50
+
+type newProvidersFn func(ctx context.Context, name string, limit int) ([]postgres.NewProvider, error)
+
+// zoneFeed serves GET /feeds/zones/:feed, where feed is "<zone>.atom" or
+// "<zone>.rss".
+func zoneFeed(c *gin.Context) {
+	serveFeed(c, "zone", c.Param("feed"), postgresSingleton.GetNewProvidersByZone)
+}
+
+// sourceFeed serves GET /feeds/sources/*feed. Sources are URLs, so the
+// wildcard lets them through unescaped, e.g.
+// /feeds/sources/https://example.com/list.atom.
+func sourceFeed(c *gin.Context) {
+	serveFeed(c, "source", strings.TrimPrefix(c.Param("feed"), "/"), postgresSingleton.GetNewProvidersBySource)
+}
+
+func serveFeed(c *gin.Context, kind, feed string, newProviders newProvidersFn) {
+	format := path.Ext(feed)
+	name := strings.TrimSuffix(feed, format)
+
+	if name == "" || (format != ".atom" && format != ".rss") {
+		abortWithError(c, http.StatusNotFound, errors.New("feeds end in .atom or .rss"))
+		return
+	}
+
+	providers, err := newProviders(c.Request.Context(), name, feedEntriesLimit)
+	if err != nil {
+		log.Printf("Could not get new providers of %s %s - error: %s", kind, name, err)
+		abortWithError(c, http.StatusInternalServerError, errors.New("could not build feed"))
+		return
+	}
+
+	baseURL := requestBaseURL(c.Request)
+	title := fmt.Sprintf("New providers from %s %s", kind, name)
+
+	var document interface{}
+	var contentType string
+
+	if format == ".atom" {
+		document = atomFeedOf(baseURL, catalogRequestURI(c.Request.URL), title, providers)
+		contentType = "application/atom+xml; charset=utf-8"
+	} else {
+		document = rssFeedOf(baseURL, catalogRequestURI(c.Request.URL), title, providers)
+		contentType = "application/rss+xml; charset=utf-8"
+	}
+
+	body, err := xml.MarshalIndent(document, "", "  ")
+	if err != nil {
+		log.Printf("Could not marshal feed - error: %s", err)
+		abortWithError(c, http.StatusInternalServerError, errors.New("could not build feed"))
+		return
+	}
+
+	c.Data(http.StatusOK, contentType, append([]byte(xml.Header), body...))
+}
+
+type atomFeed struct {
+	XMLName xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	ID      string      `xml:"id"`
+	Title   string      `xml:"title"`
+	Updated string      `xml:"updated"`
+	Links   []atomLink  `xml:"link"`
+	Entries []atomEntry `xml:"entry"`
+}
+
+type atomEntry struct {
+	ID        string     `xml:"id"`
+	Title     string     `xml:"title"`
+	Published string     `xml:"published"`
+	Updated   string     `xml:"updated"`
+	Summary   string     `xml:"summary"`
+	Links     []atomLink `xml:"link"`
+}
+
+type atomLink struct {
+	Rel  string `xml:"rel,attr,omitempty"`
+	Href string `xml:"href,attr"`
+	Type string `xml:"type,attr,omitempty"`
+}
+
+func atomFeedOf(baseURL, requestURI, title string, providers []postgres.NewProvider) atomFeed {
+	feed := atomFeed{
+		ID:      baseURL + requestURI,
+		Title:   title,
+		Updated: time.Unix(0, 0).UTC().Format(time.RFC3339),
+		Links:   []atomLink{{Rel: "self", Href: baseURL + requestURI}},
+		Entries: make([]atomEntry, 0, len(providers)),
+	}
+
+	if len(providers) > 0 {
+		feed.Updated = providers[0].FirstSeenAt.UTC().Format(time.RFC3339)
+	}
+
+	for _, provider := range providers {
+		entry := atomEntry{
+			ID:        providerEntryID(provider),
+			Title:     provider.Name,
+			Published: provider.FirstSeenAt.UTC().Format(time.RFC3339),
+			Updated:   provider.UpdatedAt.UTC().Format(time.RFC3339),
+			Summary:   providerSummary(provider),
+			Links:     []atomLink{{Rel: "alternate", Href: providerURL(baseURL, provider)}},
+		}
+
+		if provider.FirstPic != "" {
+			entry.Links = append(entry.Links, atomLink{Rel: "enclosure", Href: provider.FirstPic, Type: picType(provider.FirstPic)})
+		}
+
+		feed.Entries = append(feed.Entries, entry)
+	}
+
+	return feed
+}
+
+type rssFeed struct {
+	XMLName xml.Name   `xml:"rss"`
+	Version string     `xml:"version,attr"`
+	Channel rssChannel `xml:"channel"`
+}
+
+type rssChannel struct {
+	Title       string    `xml:"title"`
+	Link        string    `xml:"link"`
+	Description string    `xml:"description"`
+	Items       []rssItem `xml:"item"`
+}
+
+type rssItem struct {
+	Title       string        `xml:"title"`
+	Link        string        `xml:"link"`
+	GUID        rssGUID       `xml:"guid"`
+	PubDate     string        `xml:"pubDate"`
+	Description string        `xml:"description"`
+	Enclosure   *rssEnclosure `xml:"enclosure"`
+}
+
+type rssGUID struct {
+	IsPermaLink bool   `xml:"isPermaLink,attr"`
+	Value       string `xml:",chardata"`
+}
+
+type rssEnclosure struct {
+	URL    string `xml:"url,attr"`
+	Length int    `xml:"length,attr"`
+	Type   string `xml:"type,attr"`
+}
+
+func rssFeedOf(baseURL, requestURI, title string, providers []postgres.NewProvider) rssFeed {
+	feed := rssFeed{
+		Version: "2.0",
+		Channel: rssChannel{
+			Title:       title,
+			Link:        baseURL + requestURI,
+			Description: title,
+			Items:       make([]rssItem, 0, len(providers)),
+		},
+	}
+
+	for _, provider := range providers {
+		item := rssItem{
+			Title:       provider.Name,
+			Link:        providerURL(baseURL, provider),
+			GUID:        rssGUID{Value: providerEntryID(provider)},
+			PubDate:     provider.FirstSeenAt.UTC().Format(time.RFC1123Z),
+			Description: providerSummary(provider),
+		}
+
+		if provider.FirstPic != "" {
+			item.Enclosure = &rssEnclosure{URL: provider.FirstPic, Type: picType(provider.FirstPic)}
+		}
+
+		feed.Channel.Items = append(feed.Channel.Items, item)
+	}
+
+	return feed
+}
+
+// providerEntryID stays the same across saves, unlike the provider id.
+func providerEntryID(provider postgres.NewProvider) string {
+	return fmt.Sprintf("urn:sarasa:provider:%d", provider.FirstSeenID)
+}
+
+func providerURL(baseURL string, provider postgres.NewProvider) string {
+	return fmt.Sprintf("%s/providers/%d", baseURL, provider.ID)
+}
+
+func providerSummary(provider postgres.NewProvider) string {
+	return fmt.Sprintf("%s - %s - %s", provider.Name, provider.Place, provider.Phone)
+}
+
+func picType(pic string) string {
+	u, err := url.Parse(pic)
+	if err == nil {
+		if t := mime.TypeByExtension(path.Ext(u.Path)); strings.HasPrefix(t, "image/") {
+			return t
+		}
+	}
+
+	return "image/jpeg"
+}
+
+func requestBaseURL(r *http.Request) string {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+
+	if forwarded := r.Header.Get("X-Forwarded-Proto"); forwarded != "" {
+		scheme = forwarded
+	}
+
+	return scheme + "://" + r.Host
+}
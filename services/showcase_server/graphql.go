@@ -0,0 +1,677 @@
+package main
+
+import (
+	"context"
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/graph-gophers/graphql-go"
+	"sarasa/libs/dataloader"
+	"sarasa/libs/graphqlLimits"
+	"sarasa/libs/postgres"
+	"sarasa/schemas"
+)
+
+// graphQLSDL is the schema served at /graphql. Its first defaults are
+// postgres.DefaultProvidersLimit and defaultRunsLimit.
+//
+//go:embed schema.graphql
+var graphQLSDL string
+
+var graphQLSchema *graphQLService
+
+// graphQLService runs the queries its checker lets through.
+type graphQLService struct {
+	schema  *graphql.Schema
+	checker *graphqlLimits.Checker
+}
+
+// graphQLLimits reject queries nesting or fanning out more than any catalog
+// client needs, before they reach the database.
+var graphQLLimits = graphqlLimits.Limits{MaxDepth: 8, MaxComplexity: 5000, DefaultListSize: 20}
+
+// graphQLLoadWait is how long loaders collect keys from sibling fields,
+// which resolve concurrently, before fetching them.
+const graphQLLoadWait = 2 * time.Millisecond
+
+func newGraphQLSchema() (*graphQLService, error) {
+	checker, err := graphqlLimits.NewChecker(graphQLSDL, graphQLLimits, map[string]graphqlLimits.ComplexityFn{
+		"Query.providers":         firstItems,
+		"Query.runs":              firstItems,
+		"Zone.providers":          firstItems,
+		"Source.providers":        firstItems,
+		"Source.runs":             firstItems,
+		"ProvidersPage.providers": pageItems,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// A page of providers resolves its fields concurrently, a loader only
+	// batches them all when they fit.
+	schema, err := graphql.ParseSchema(graphQLSDL, &queryResolver{},
+		graphql.UseStringDescriptions(),
+		graphql.MaxDepth(graphQLLimits.MaxDepth),
+		graphql.MaxParallelism(postgres.MaxProvidersLimit))
+	if err != nil {
+		return nil, err
+	}
+
+	return &graphQLService{schema: schema, checker: checker}, nil
+}
+
+// firstItems is the complexity of a field returning up to its first argument
+// items.
+func firstItems(args map[string]interface{}, childComplexity int) int {
+	first, _ := graphqlLimits.IntArg(args, "first")
+
+	return 1 + first*childComplexity
+}
+
+// pageItems is the complexity of the providers of a page, already multiplied
+// by the first argument of the field returning the page.
+func pageItems(_ map[string]interface{}, childComplexity int) int {
+	return 1 + childComplexity
+}
+
+// graphQLRequest is what resolvers need from the HTTP request. Loaders live
+// for a single request, so nothing is cached across requests.
+type graphQLRequest struct {
+	key postgres.APIKey
+
+	pics            *dataloader.Loader[int, []string]
+	zones           *dataloader.Loader[string, schemas.ZoneSummary]
+	sources         *dataloader.Loader[string, schemas.SourceSummary]
+	zoneProviders   *dataloader.Loader[pageKey, schemas.ProvidersPage]
+	sourceProviders *dataloader.Loader[pageKey, schemas.ProvidersPage]
+	sourceRuns      *dataloader.Loader[pageKey, []schemas.Run]
+	quarantined     *dataloader.Loader[int, []schemas.QuarantinedProvider]
+}
+
+// pageKey asks for the first limit items of the zone or source name.
+type pageKey struct {
+	name  string
+	limit int
+}
+
+type graphQLContextKey struct{}
+
+func newGraphQLRequest(ctx context.Context, key postgres.APIKey) *graphQLRequest {
+	return &graphQLRequest{
+		key:  key,
+		pics: dataloader.New(ctx, graphQLLoadWait, loadBatch("load provider pics", postgresSingleton.GetPicsByProviders)),
+		zones: dataloader.New(ctx, graphQLLoadWait, loadBatch("load zones", func(ctx context.Context, names []string) (map[string]schemas.ZoneSummary, error) {
+			zones, err := postgresSingleton.GetZonesSummary(ctx)
+
+			byName := make(map[string]schemas.ZoneSummary, len(zones))
+			for _, zone := range zones {
+				byName[zone.Name] = zone
+			}
+
+			return byName, err
+		})),
+		sources: dataloader.New(ctx, graphQLLoadWait, loadBatch("load sources", func(ctx context.Context, names []string) (map[string]schemas.SourceSummary, error) {
+			sources, err := postgresSingleton.GetSourcesSummary(ctx)
+
+			byName := make(map[string]schemas.SourceSummary, len(sources))
+			for _, source := range sources {
+				byName[source.Name] = source
+			}
+
+			return byName, err
+		})),
+		zoneProviders:   dataloader.New(ctx, graphQLLoadWait, loadBatch("list providers", byLimit(postgresSingleton.ListProvidersByZones))),
+		sourceProviders: dataloader.New(ctx, graphQLLoadWait, loadBatch("list providers", byLimit(postgresSingleton.ListProvidersBySources))),
+		sourceRuns:      dataloader.New(ctx, graphQLLoadWait, loadBatch("list runs", byLimit(postgresSingleton.ListRunsBySources))),
+		quarantined:     dataloader.New(ctx, graphQLLoadWait, loadBatch("load quarantined providers", postgresSingleton.GetQuarantinedByRuns)),
+	}
+}
+
+// loadBatch logs database failures once per batch and hides them from
+// clients, like the REST handlers do.
+func loadBatch[K comparable, V any](what string, batch dataloader.BatchFunc[K, V]) dataloader.BatchFunc[K, V] {
+	return func(ctx context.Context, keys []K) (map[K]V, error) {
+		values, err := batch(ctx, keys)
+		if err != nil {
+			return nil, graphQLError(what, err)
+		}
+
+		return values, nil
+	}
+}
+
+// byLimit batches pageKeys with one fetch per distinct limit.
+func byLimit[V any](fetch func(ctx context.Context, names []string, limit int) (map[string]V, error)) dataloader.BatchFunc[pageKey, V] {
+	return func(ctx context.Context, keys []pageKey) (map[pageKey]V, error) {
+		names := make(map[int][]string)
+		for _, key := range keys {
+			names[key.limit] = append(names[key.limit], key.name)
+		}
+
+		results := make(map[pageKey]V, len(keys))
+
+		for limit, group := range names {
+			values, err := fetch(ctx, group, limit)
+			if err != nil {
+				return nil, err
+			}
+
+			for _, name := range group {
+				results[pageKey{name: name, limit: limit}] = values[name]
+			}
+		}
+
+		return results, nil
+	}
+}
+
+func graphQLRequestFrom(ctx context.Context) *graphQLRequest {
+	return ctx.Value(graphQLContextKey{}).(*graphQLRequest)
+}
+
+// graphQLError passes filter errors through and logs anything else.
+func graphQLError(what string, err error) error {
+	if errors.Is(err, postgres.ErrInvalidFilter) {
+		return err
+	}
+
+	log.Printf("Could not %s - error: %s", what, err)
+
+	return errors.New("could not " + what)
+}
+
+func requireAdmin(ctx context.Context) error {
+	if !hasScope(graphQLRequestFrom(ctx).key, scopeAdmin) {
+		return errors.New("API key lacks the " + scopeAdmin + " scope")
+	}
+
+	return nil
+}
+
+type graphQLParams struct {
+	Query         string                 `json:"query"`
+	OperationName string                 `json:"operationName"`
+	Variables     map[string]interface{} `json:"variables"`
+}
+
+// serveGraphQL serves GET and POST /graphql. POST takes a JSON body with
+// query, operationName and variables; GET takes them as query parameters,
+// variables JSON encoded.
+func serveGraphQL(c *gin.Context) {
+	var params graphQLParams
+
+	if c.Request.Method == http.MethodGet {
+		params.Query = c.Query("query")
+		params.OperationName = c.Query("operationName")
+
+		if v := c.Query("variables"); v != "" {
+			if err := json.Unmarshal([]byte(v), &params.Variables); err != nil {
+				abortWithError(c, http.StatusBadRequest, errors.New("variables must be a JSON object"))
+				return
+			}
+		}
+	} else if err := json.NewDecoder(c.Request.Body).Decode(&params); err != nil {
+		abortWithError(c, http.StatusBadRequest, errors.New("body must be a JSON object with a query"))
+		return
+	}
+
+	if params.Query == "" {
+		abortWithError(c, http.StatusBadRequest, errors.New("query is required"))
+		return
+	}
+
+	if errs := graphQLSchema.checker.Check(params.Query, params.OperationName, params.Variables); len(errs) > 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"errors": errs})
+		return
+	}
+
+	key := c.MustGet(apiKeyContextKey).(postgres.APIKey)
+	ctx := context.WithValue(c.Request.Context(), graphQLContextKey{}, newGraphQLRequest(c.Request.Context(), key))
+
+	response := graphQLSchema.schema.Exec(ctx, params.Query, params.OperationName, params.Variables)
+	if response.Data == nil {
+		c.JSON(http.StatusBadRequest, response)
+		return
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+// getGraphQLSchema serves GET /graphql/schema.graphql.
+func getGraphQLSchema(c *gin.Context) {
+	c.Data(http.StatusOK, "text/plain; charset=utf-8", []byte(graphQLSDL))
+}
+
+// dateTime is the DateTime scalar.
+type dateTime struct {
+	time.Time
+}
+
+func (dateTime) ImplementsGraphQLType(name string) bool {
+	return name == "DateTime"
+}
+
+func (t *dateTime) UnmarshalGraphQL(input interface{}) error {
+	s, ok := input.(string)
+	if !ok {
+		return fmt.Errorf("DateTime cannot represent %v", input)
+	}
+
+	parsed, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return errors.New("DateTime must be RFC 3339")
+	}
+
+	t.Time = parsed
+
+	return nil
+}
+
+func (t dateTime) MarshalJSON() ([]byte, error) {
+	return json.Marshal(t.UTC().Format(time.RFC3339Nano))
+}
+
+func optionalDateTime(t *time.Time) *dateTime {
+	if t == nil {
+		return nil
+	}
+
+	return &dateTime{*t}
+}
+
+func graphQLID(id int) graphql.ID {
+	return graphql.ID(strconv.Itoa(id))
+}
+
+func parseID(id graphql.ID) (int, error) {
+	n, err := strconv.Atoi(string(id))
+	if err != nil || n <= 0 {
+		return 0, errors.New("id must be a positive integer")
+	}
+
+	return n, nil
+}
+
+// emptyAsNull resolves a string field that is empty when unknown.
+func emptyAsNull(s string) *string {
+	if s == "" {
+		return nil
+	}
+
+	return &s
+}
+
+func stringOrEmpty(s *string) string {
+	if s == nil {
+		return ""
+	}
+
+	return *s
+}
+
+type queryResolver struct{}
+
+type providersArgs struct {
+	Zone         *string
+	Source       *string
+	Q            *string
+	HasPics      *bool
+	UpdatedSince *dateTime
+	Sort         string
+	First        int32
+	After        *string
+}
+
+func (*queryResolver) Providers(ctx context.Context, args providersArgs) (*pageResolver, error) {
+	filter := postgres.ProvidersFilter{
+		Zone:     stringOrEmpty(args.Zone),
+		Source:   stringOrEmpty(args.Source),
+		Name:     stringOrEmpty(args.Q),
+		Sort:     args.Sort,
+		Cursor:   stringOrEmpty(args.After),
+		Limit:    int(args.First),
+		HasPics:  args.HasPics,
+		SkipPics: true,
+	}
+
+	if filter.Limit <= 0 {
+		return nil, fmt.Errorf("first must be between 1 and %d", postgres.MaxProvidersLimit)
+	}
+
+	if args.UpdatedSince != nil {
+		filter.UpdatedSince = args.UpdatedSince.Time
+	}
+
+	page, err := postgresSingleton.ListProviders(ctx, filter)
+	if err != nil {
+		return nil, graphQLError("list providers", err)
+	}
+
+	return &pageResolver{page}, nil
+}
+
+func (*queryResolver) Provider(ctx context.Context, args struct{ ID graphql.ID }) (*providerResolver, error) {
+	providerID, err := parseID(args.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	provider, err := postgresSingleton.GetProvider(ctx, providerID)
+	if errors.Is(err, postgres.ErrNotFound) {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, graphQLError("get provider", err)
+	}
+
+	return &providerResolver{provider}, nil
+}
+
+func (*queryResolver) Zones(ctx context.Context) ([]*zoneResolver, error) {
+	zones, err := postgresSingleton.GetZonesSummary(ctx)
+	if err != nil {
+		return nil, graphQLError("list zones", err)
+	}
+
+	resolvers := make([]*zoneResolver, len(zones))
+	for i, zone := range zones {
+		resolvers[i] = &zoneResolver{zone}
+	}
+
+	return resolvers, nil
+}
+
+func (*queryResolver) Zone(ctx context.Context, args struct{ Name string }) (*zoneResolver, error) {
+	return loadZone(ctx, args.Name)
+}
+
+func (*queryResolver) Sources(ctx context.Context) ([]*sourceResolver, error) {
+	sources, err := postgresSingleton.GetSourcesSummary(ctx)
+	if err != nil {
+		return nil, graphQLError("list sources", err)
+	}
+
+	resolvers := make([]*sourceResolver, len(sources))
+	for i, source := range sources {
+		resolvers[i] = &sourceResolver{source}
+	}
+
+	return resolvers, nil
+}
+
+func (*queryResolver) Source(ctx context.Context, args struct{ Name string }) (*sourceResolver, error) {
+	source, err := graphQLRequestFrom(ctx).sources.Load(args.Name)()
+	if err != nil || source.ID == 0 {
+		return nil, err
+	}
+
+	return &sourceResolver{source}, nil
+}
+
+func (*queryResolver) Runs(ctx context.Context, args struct {
+	Source *string
+	First  int32
+}) (*[]*runResolver, error) {
+	if err := requireAdmin(ctx); err != nil {
+		return nil, err
+	}
+
+	first, err := runsLimit(args.First)
+	if err != nil {
+		return nil, err
+	}
+
+	runs, err := postgresSingleton.ListRuns(ctx, stringOrEmpty(args.Source), first)
+	if err != nil {
+		return nil, graphQLError("list runs", err)
+	}
+
+	return runResolvers(runs), nil
+}
+
+func (*queryResolver) Run(ctx context.Context, args struct{ ID graphql.ID }) (*runResolver, error) {
+	if err := requireAdmin(ctx); err != nil {
+		return nil, err
+	}
+
+	runID, err := parseID(args.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	run, err := postgresSingleton.GetRun(ctx, runID)
+	if errors.Is(err, postgres.ErrNotFound) {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, graphQLError("get run", err)
+	}
+
+	return &runResolver{run}, nil
+}
+
+func loadZone(ctx context.Context, name string) (*zoneResolver, error) {
+	zone, err := graphQLRequestFrom(ctx).zones.Load(name)()
+	if err != nil || zone.ID == 0 {
+		return nil, err
+	}
+
+	return &zoneResolver{zone}, nil
+}
+
+func runsLimit(first int32) (int, error) {
+	limit := int(first)
+	if limit <= 0 || limit > maxRunsLimit {
+		return 0, fmt.Errorf("first must be between 1 and %d", maxRunsLimit)
+	}
+
+	return limit, nil
+}
+
+func runResolvers(runs []schemas.Run) *[]*runResolver {
+	resolvers := make([]*runResolver, len(runs))
+	for i, run := range runs {
+		resolvers[i] = &runResolver{run}
+	}
+
+	return &resolvers
+}
+
+// firstArgs are the arguments of fields listing their first items, their
+// first defaulting to a limit.
+type firstArgs struct {
+	First int32
+}
+
+type pageResolver struct {
+	page schemas.ProvidersPage
+}
+
+func (r *pageResolver) Providers() []*providerResolver {
+	resolvers := make([]*providerResolver, len(r.page.Providers))
+	for i, provider := range r.page.Providers {
+		resolvers[i] = &providerResolver{provider}
+	}
+
+	return resolvers
+}
+
+func (r *pageResolver) NextCursor() *string {
+	return emptyAsNull(r.page.NextCursor)
+}
+
+type providerResolver struct {
+	provider schemas.Provider
+}
+
+func (r *providerResolver) ID() graphql.ID      { return graphQLID(r.provider.ID) }
+func (r *providerResolver) Name() string        { return r.provider.Name }
+func (r *providerResolver) Phone() string       { return r.provider.Phone }
+func (r *providerResolver) Place() string       { return r.provider.Place }
+func (r *providerResolver) Source() string      { return r.provider.Source }
+func (r *providerResolver) Link() *string       { return emptyAsNull(r.provider.Link) }
+func (r *providerResolver) UpdatedAt() dateTime { return dateTime{r.provider.UpdatedAt} }
+
+func (r *providerResolver) Pics(ctx context.Context, args struct{ First *int32 }) ([]string, error) {
+	if args.First != nil && *args.First < 0 {
+		return nil, errors.New("first can't be negative")
+	}
+
+	pics := r.provider.Pics
+	if pics == nil {
+		var err error
+
+		pics, err = graphQLRequestFrom(ctx).pics.Load(r.provider.ID)()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if args.First != nil && len(pics) > int(*args.First) {
+		pics = pics[:*args.First]
+	}
+
+	if pics == nil {
+		pics = []string{}
+	}
+
+	return pics, nil
+}
+
+func (r *providerResolver) Zone(ctx context.Context) (*zoneResolver, error) {
+	return loadZone(ctx, r.provider.Place)
+}
+
+type zoneResolver struct {
+	zone schemas.ZoneSummary
+}
+
+func (r *zoneResolver) ID() graphql.ID        { return graphQLID(r.zone.ID) }
+func (r *zoneResolver) Name() string          { return r.zone.Name }
+func (r *zoneResolver) ProvidersCount() int32 { return int32(r.zone.ProvidersCount) }
+
+func (r *zoneResolver) Providers(ctx context.Context, args firstArgs) (*pageResolver, error) {
+	page, err := graphQLRequestFrom(ctx).zoneProviders.Load(pageKey{name: r.zone.Name, limit: int(args.First)})()
+	if err != nil {
+		return nil, err
+	}
+
+	return &pageResolver{page}, nil
+}
+
+type sourceResolver struct {
+	source schemas.SourceSummary
+}
+
+func (r *sourceResolver) ID() graphql.ID         { return graphQLID(r.source.ID) }
+func (r *sourceResolver) Name() string           { return r.source.Name }
+func (r *sourceResolver) ProvidersCount() int32  { return int32(r.source.ProvidersCount) }
+func (r *sourceResolver) LastSavedAt() *dateTime { return optionalDateTime(r.source.LastSavedAt) }
+
+func (r *sourceResolver) Providers(ctx context.Context, args firstArgs) (*pageResolver, error) {
+	page, err := graphQLRequestFrom(ctx).sourceProviders.Load(pageKey{name: r.source.Name, limit: int(args.First)})()
+	if err != nil {
+		return nil, err
+	}
+
+	return &pageResolver{page}, nil
+}
+
+func (r *sourceResolver) Runs(ctx context.Context, args firstArgs) (*[]*runResolver, error) {
+	if err := requireAdmin(ctx); err != nil {
+		return nil, err
+	}
+
+	first, err := runsLimit(args.First)
+	if err != nil {
+		return nil, err
+	}
+
+	runs, err := graphQLRequestFrom(ctx).sourceRuns.Load(pageKey{name: r.source.Name, limit: first})()
+	if err != nil {
+		return nil, err
+	}
+
+	return runResolvers(runs), nil
+}
+
+type runResolver struct {
+	run schemas.Run
+}
+
+func (r *runResolver) ID() graphql.ID                { return graphQLID(r.run.ID) }
+func (r *runResolver) UUID() string                  { return r.run.UUID }
+func (r *runResolver) Source() string                { return r.run.Source }
+func (r *runResolver) Status() string                { return r.run.Status }
+func (r *runResolver) ReceivedProvidersCount() int32 { return int32(r.run.ReceivedProvidersCount) }
+func (r *runResolver) InvalidProvidersCount() int32  { return int32(r.run.InvalidProvidersCount) }
+func (r *runResolver) SavedProvidersCount() int32    { return int32(r.run.SavedProvidersCount) }
+func (r *runResolver) Error() *string                { return emptyAsNull(r.run.Error) }
+func (r *runResolver) StartedAt() dateTime           { return dateTime{r.run.StartedAt} }
+func (r *runResolver) FinishedAt() dateTime          { return dateTime{r.run.FinishedAt} }
+func (r *runResolver) DurationMs() int32             { return int32(r.run.DurationMs) }
+
+func (r *runResolver) InvalidReasons() []*invalidReasonResolver {
+	reasons := make([]*invalidReasonResolver, 0, len(r.run.InvalidReasons))
+	for reason, count := range r.run.InvalidReasons {
+		reasons = append(reasons, &invalidReasonResolver{reason: reason, count: count})
+	}
+
+	sort.Slice(reasons, func(i, j int) bool {
+		return reasons[i].reason < reasons[j].reason
+	})
+
+	return reasons
+}
+
+func (r *runResolver) Quarantined(ctx context.Context) ([]*quarantinedResolver, error) {
+	quarantined := r.run.Quarantined
+	if quarantined == nil {
+		var err error
+
+		quarantined, err = graphQLRequestFrom(ctx).quarantined.Load(r.run.ID)()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	resolvers := make([]*quarantinedResolver, len(quarantined))
+	for i, q := range quarantined {
+		resolvers[i] = &quarantinedResolver{q}
+	}
+
+	return resolvers, nil
+}
+
+type invalidReasonResolver struct {
+	reason string
+	count  int
+}
+
+func (r *invalidReasonResolver) Reason() string { return r.reason }
+func (r *invalidReasonResolver) Count() int32   { return int32(r.count) }
+
+type quarantinedResolver struct {
+	quarantined schemas.QuarantinedProvider
+}
+
+func (r *quarantinedResolver) ID() graphql.ID       { return graphQLID(r.quarantined.ID) }
+func (r *quarantinedResolver) RunID() graphql.ID    { return graphQLID(r.quarantined.RunID) }
+func (r *quarantinedResolver) Reason() string       { return r.quarantined.Reason }
+func (r *quarantinedResolver) Status() string       { return r.quarantined.Status }
+func (r *quarantinedResolver) CreatedAt() dateTime  { return dateTime{r.quarantined.CreatedAt} }
+func (r *quarantinedResolver) DecidedAt() *dateTime { return optionalDateTime(r.quarantined.DecidedAt) }
+
+func (r *quarantinedResolver) Provider() *providerResolver {
+	return &providerResolver{r.quarantined.Provider}
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/require"
+)
+
+func postGraphQL(t *testing.T, r http.Handler, query string) *httptest.ResponseRecorder {
+	t.Helper()
+
+	body, err := json.Marshal(map[string]string{"query": query})
+	require.NoError(t, err)
+
+	request := httptest.NewRequest(http.MethodPost, "/graphql", strings.NewReader(string(body)))
+	request.Header.Set("Authorization", "Bearer "+testAdminKey)
+	request.Header.Set("Content-Type", "application/json")
+
+	recorder := httptest.NewRecorder()
+	r.ServeHTTP(recorder, request)
+
+	return recorder
+}
+
+func TestGraphQLSchemaBuilds(t *testing.T) {
+	var err error
+	graphQLSchema, err = newGraphQLSchema()
+	require.NoError(t, err)
+
+	r, _ := newTestRouter(t)
+
+	recorder := serve(r, http.MethodGet, "/graphql/schema.graphql", nil)
+
+	require.Equal(t, http.StatusOK, recorder.Code)
+	require.Equal(t, graphQLSDL, recorder.Body.String())
+}
+
+// The pics of a page of providers resolve concurrently and load in a single
+// query.
+func TestGraphQLBatchesPics(t *testing.T) {
+	var err error
+	graphQLSchema, err = newGraphQLSchema()
+	require.NoError(t, err)
+
+	r, mock := newTestRouter(t)
+
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	expectQuery(mock, providersQuery).WillReturnRows(sqlmock.NewRows(providerColumns).
+		AddRow(1, "Ana", "1111", "example.com", "Palermo", now).
+		AddRow(2, "Bea", "2222", "example.com", "Palermo", now))
+	expectQuery(mock, providerPicsQuery).WillReturnRows(sqlmock.NewRows([]string{"provider_id", "pic_url"}).
+		AddRow(1, "https://example.com/ana-1.jpg").
+		AddRow(1, "https://example.com/ana-2.jpg"))
+
+	recorder := postGraphQL(t, r, `{ providers(first: 2) { providers { id updatedAt pics(first: 1) } } }`)
+
+	require.Equal(t, http.StatusOK, recorder.Code, recorder.Body.String())
+	require.JSONEq(t, `{"data": {"providers": {"providers": [
+  {"id": "1", "updatedAt": "2024-05-01T12:00:00Z", "pics": ["https://example.com/ana-1.jpg"]},
+  {"id": "2", "updatedAt": "2024-05-01T12:00:00Z", "pics": []}
+]}}}`, recorder.Body.String())
+}
+
+// The largest page of providers with every field stays within the limits.
+func TestGraphQLLargestPageIsAllowed(t *testing.T) {
+	var err error
+	graphQLSchema, err = newGraphQLSchema()
+	require.NoError(t, err)
+
+	r, mock := newTestRouter(t)
+
+	expectQuery(mock, providersQuery).WillReturnRows(sqlmock.NewRows(providerColumns))
+
+	recorder := postGraphQL(t, r, `{
+  providers(first: 200) {
+    providers { id name phone place source link updatedAt pics }
+    nextCursor
+  }
+}`)
+
+	require.Equal(t, http.StatusOK, recorder.Code, recorder.Body.String())
+	requireDocumented(t, http.MethodPost, "/graphql", recorder)
+	require.JSONEq(t, `{"data": {"providers": {"providers": [], "nextCursor": null}}}`, recorder.Body.String())
+}
+
+// Pages of providers under every zone fan out past the complexity limit and
+// are rejected before querying the database.
+func TestGraphQLRejectsTooComplexQueries(t *testing.T) {
+	var err error
+	graphQLSchema, err = newGraphQLSchema()
+	require.NoError(t, err)
+
+	r, _ := newTestRouter(t)
+
+	recorder := postGraphQL(t, r, `{ zones { providers(first: 200) { providers { id } } } }`)
+
+	require.Equal(t, http.StatusBadRequest, recorder.Code)
+	requireDocumented(t, http.MethodPost, "/graphql", recorder)
+	require.Contains(t, recorder.Body.String(), "more complex than the maximum allowed")
+}
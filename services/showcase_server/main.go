@@ -1,9 +1,14 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+
 	"github.com/gin-gonic/gin"
+	"sarasa/libs/lifecycle"
 	"sarasa/libs/postgres"
-	"sarasa/libs/signals"
 
 	"sarasa/libs/configHandling"
 
@@ -18,37 +23,134 @@ var configuration schemas.Config
 var influxSingleton influxdb.Client
 var rabbitMQSingleton rabbitMQ.Client
 var postgresSingleton postgres.Client
+var healthSingleton lifecycle.Health
+var lifecycleManager = lifecycle.NewManager(lifecycle.DefaultDrainTimeout)
 
-func init() {
-	errorHandling.FailOnError(configHandling.LoadConfig(&configuration, "showcase_server"), "Failed to load configuration")
+func initialize() {
+	errorHandling.FailOnError(
+		configHandling.LoadConfig(&configuration, "showcase_server",
+			configHandling.Health, configHandling.Influx, configHandling.Postgres, configHandling.RabbitMQ, configHandling.Showcase),
+		"Failed to load configuration")
 
 	/**
 	 * InfluxDB Singleton
 	 */
 	errorHandling.LogOnError(
 		influxSingleton.Init(configuration.Influx), "Could not initialize InfluxSingleton")
+	lifecycleManager.AddService(&influxSingleton)
+
+	postgresSingleton.SetQueryObserver(influxSingleton.QueryObserver("showcase_server"))
+
+	influxSingleton.ObserveService("showcase_server")
 
 	/**
 	* Postgres Singleton
 	 */
 	errorHandling.FailOnError(
 		postgresSingleton.Init(configuration.Postgres), "Could not initialize PostgresSingleton")
+	lifecycleManager.AddService(&postgresSingleton)
+
+	errorHandling.LogOnError(
+		responseCache.refresh(context.Background()), "Could not load catalog version, responses won't be cached")
+
+	/**
+	 * RabbitMQ Singleton
+	 */
+	errorHandling.FailOnError(
+		rabbitMQSingleton.Init(configuration.RabbitMQ), "Could not initialize rabbitMQSingleton")
+	lifecycleManager.AddService(&rabbitMQSingleton)
+
+	errorHandling.FailOnError(
+		consumeCoreEvents(), "Failed to consume core events")
+
+	errorHandling.FailOnError(
+		rabbitMQSingleton.Channel.ExchangeDeclare(
+			"refresh", "fanout", true, false, false, false, nil),
+		"Failed to declare refresh exchange")
+
+	/**
+	 * GraphQL schema
+	 */
+	var err error
+	graphQLSchema, err = newGraphQLSchema()
+	errorHandling.FailOnError(err, "Failed to build GraphQL schema")
+
+	/**
+	 * Health checks
+	 */
+	healthSingleton.AddReadinessCheck("lifecycle", lifecycleManager.ReadyCheck)
+	healthSingleton.AddReadinessCheck("config", configHandling.LoadedCheck)
+	healthSingleton.AddReadinessCheck("postgres", lifecycle.ErrorCheck(postgresSingleton.Ping))
+	healthSingleton.AddReadinessCheck("rabbitMQ", lifecycle.ErrorCheck(rabbitMQSingleton.Ping))
+	healthSingleton.AddOptionalCheck("influxDB", lifecycle.ErrorCheck(influxSingleton.Ping))
+	healthSingleton.AddOptionalCheck("postgresBreaker", postgresSingleton.Breaker().HealthCheck)
+	healthSingleton.AddOptionalCheck("influxDBBreaker", influxSingleton.Breaker().HealthCheck)
+
+	go func() {
+		errorHandling.LogOnError(healthSingleton.Serve(configuration.Health), "Health server stopped")
+	}()
+	lifecycleManager.AddService(&healthSingleton)
 
 	/**
 	 * Signal handling
 	 */
-	signals.SignalHandler(rabbitMQSingleton, influxSingleton)
+	lifecycleManager.HandleSignals()
 }
 
 func main() {
+	initialize()
+
+	server := &http.Server{Addr: ":8080", Handler: newRouter()}
+
+	// Registered after the core events consumers, so those stop first and
+	// in-flight requests, including event streams, get the rest of the
+	// drain deadline.
+	lifecycleManager.AddStopper("HTTP server", server.Shutdown)
+
+	go func() {
+		err := server.ListenAndServe()
+		if !errors.Is(err, http.ErrServerClosed) {
+			errorHandling.FailOnError(err, "HTTP server stopped")
+		}
+	}()
+
+	log.Printf("Serving on %s", server.Addr)
+	lifecycleManager.Wait()
+}
+
+// newRouter routes every endpoint described in openapi.json.
+func newRouter() *gin.Engine {
+	r := gin.New()
+	r.Use(gin.LoggerWithFormatter(accessLogFormatter), gin.Recovery())
+
+	err := r.SetTrustedProxies(configuration.Showcase.TrustedProxies)
+	errorHandling.FailOnError(err, "Invalid trusted proxies")
+
+	r.GET("/openapi.json", getOpenAPI)
+	r.GET("/events", requireScope(scopeRead), streamEvents)
+	r.GET("/export", requireScope(scopeExport), exportProviders)
+	r.GET("/graphql/schema.graphql", getGraphQLSchema)
+	r.GET("/graphql", requireScope(scopeRead), serveGraphQL)
+	r.POST("/graphql", requireScope(scopeRead), serveGraphQL)
 
-	r := gin.Default()
-	r.GET("/providers", func(c *gin.Context) {
-		providers, err := postgresSingleton.GetProviders()
-		errorHandling.FailOnError(err, "Could not get providers")
+	catalog := r.Group("/", requireScope(scopeRead), cacheMiddleware)
+	catalog.GET("/providers", listProviders)
+	catalog.GET("/providers/:id", getProvider)
+	catalog.GET("/providers/:id/pics", getProviderPics)
+	catalog.GET("/zones", listZones)
+	catalog.GET("/sources", listSources)
+	catalog.GET("/feeds/zones/:feed", zoneFeed)
+	catalog.GET("/feeds/sources/*feed", sourceFeed)
 
-		c.JSON(200, providers)
-	})
+	admin := r.Group("/admin", requireScope(scopeAdmin))
+	admin.POST("/keys", createAPIKey)
+	admin.GET("/keys", listAPIKeys)
+	admin.DELETE("/keys/:id", revokeAPIKey)
+	admin.POST("/refresh", triggerRefresh)
+	admin.GET("/runs", listRuns)
+	admin.GET("/runs/:id", getRun)
+	admin.POST("/quarantine/:id/approve", approveQuarantined)
+	admin.POST("/quarantine/:id/reject", rejectQuarantined)
 
-	r.Run()
+	return r
 }
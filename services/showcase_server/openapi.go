@@ -0,0 +1,19 @@
+package main
+
+import (
+	_ "embed"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// openAPIDocument describes every route of this server. Keep it in sync when
+// adding or changing a handler.
+//
+//go:embed openapi.json
+var openAPIDocument []byte
+
+// getOpenAPI serves GET /openapi.json.
+func getOpenAPI(c *gin.Context) {
+	c.Data(http.StatusOK, "application/json; charset=utf-8", openAPIDocument)
+}
@@ -0,0 +1,462 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"mime"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/require"
+	"sarasa/libs/postgres"
+)
+
+const testAdminKey = "test-admin-key"
+
+// Queries are matched by a fragment only, the tests check the responses.
+const (
+	providersQuery      = "FROM providers\n    JOIN sources"
+	providerByIDQuery   = "WHERE providers.id = $1"
+	providerPicsQuery   = "FROM provider_pics WHERE provider_id = ANY($1)"
+	zonesSummaryQuery   = "FROM zones\n    LEFT JOIN providers"
+	sourcesSummaryQuery = "FROM sources\n    LEFT JOIN providers"
+)
+
+var providerColumns = []string{"id", "name", "phone", "source", "place", "updated_at"}
+
+// newTestRouter routes to handlers running on a sqlmock database, with
+// caching disabled until the test refreshes responseCache.
+func newTestRouter(t *testing.T) (*gin.Engine, sqlmock.Sqlmock) {
+	gin.SetMode(gin.TestMode)
+
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+
+	t.Cleanup(func() {
+		require.NoError(t, mock.ExpectationsWereMet())
+		db.Close()
+	})
+
+	postgresSingleton = postgres.Client{}
+	postgresSingleton.Use(db)
+
+	configuration.Showcase.AdminKey = testAdminKey
+	configuration.Showcase.FailedAuthPerMinute = 0
+	responseCache = newCatalogCache()
+	failedAuths = &failedAuthLimiter{buckets: make(map[string]*tokenBucket)}
+
+	return newRouter(), mock
+}
+
+func expectQuery(mock sqlmock.Sqlmock, fragment string) *sqlmock.ExpectedQuery {
+	fragment = regexp.QuoteMeta(fragment)
+
+	mock.ExpectPrepare(fragment)
+
+	return mock.ExpectQuery(fragment)
+}
+
+func serve(r http.Handler, method, target string, header http.Header) *httptest.ResponseRecorder {
+	request := httptest.NewRequest(method, target, nil)
+	for name, values := range header {
+		request.Header[name] = values
+	}
+
+	recorder := httptest.NewRecorder()
+	r.ServeHTTP(recorder, request)
+
+	return recorder
+}
+
+func authorized() http.Header {
+	return http.Header{"Authorization": {"Bearer " + testAdminKey}}
+}
+
+// openAPI decodes the embedded document.
+func openAPI(t *testing.T) map[string]interface{} {
+	var document map[string]interface{}
+	require.NoError(t, json.Unmarshal(openAPIDocument, &document))
+
+	return document
+}
+
+// resolve follows node's $ref, if any, within document.
+func resolve(document, node map[string]interface{}) map[string]interface{} {
+	for {
+		ref, ok := node["$ref"].(string)
+		if !ok {
+			return node
+		}
+
+		node = document
+		for _, name := range strings.Split(strings.TrimPrefix(ref, "#/"), "/") {
+			node, _ = node[name].(map[string]interface{})
+		}
+	}
+}
+
+// requireDocumented checks the recorded response is one documented for the
+// operation, and that its body matches the documented schema.
+func requireDocumented(t *testing.T, method, path string, recorder *httptest.ResponseRecorder) {
+	t.Helper()
+
+	document := openAPI(t)
+
+	operation, ok := document["paths"].(map[string]interface{})[path].(map[string]interface{})[strings.ToLower(method)].(map[string]interface{})
+	require.True(t, ok, "%s %s is not documented", method, path)
+
+	response, ok := operation["responses"].(map[string]interface{})[strconv.Itoa(recorder.Code)].(map[string]interface{})
+	require.True(t, ok, "%s %s answered an undocumented %d: %s", method, path, recorder.Code, recorder.Body)
+
+	response = resolve(document, response)
+
+	content, ok := response["content"].(map[string]interface{})
+	if !ok {
+		require.Empty(t, recorder.Body.String(), "%s %s %d documents no body", method, path, recorder.Code)
+		return
+	}
+
+	mediaType, _, err := mime.ParseMediaType(recorder.Header().Get("Content-Type"))
+	require.NoError(t, err)
+
+	media, ok := content[mediaType].(map[string]interface{})
+	require.True(t, ok, "%s %s %d answered undocumented %s", method, path, recorder.Code, mediaType)
+
+	if mediaType != "application/json" {
+		return
+	}
+
+	var body interface{}
+	require.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &body))
+
+	schema, _ := media["schema"].(map[string]interface{})
+	require.Empty(t, validateSchema(document, schema, body, "body"))
+}
+
+// validateSchema checks value against the subset of OpenAPI 3.0 schemas
+// openapi.json uses, returning every mismatch found.
+func validateSchema(document, schema map[string]interface{}, value interface{}, at string) []string {
+	schema = resolve(document, schema)
+
+	if value == nil {
+		if schema["nullable"] == true || schema["type"] == nil {
+			return nil
+		}
+
+		return []string{at + " is null"}
+	}
+
+	if oneOf, ok := schema["oneOf"].([]interface{}); ok {
+		matches := 0
+		for _, candidate := range oneOf {
+			if len(validateSchema(document, candidate.(map[string]interface{}), value, at)) == 0 {
+				matches++
+			}
+		}
+
+		if matches != 1 {
+			return []string{fmt.Sprintf("%s matches %d of oneOf", at, matches)}
+		}
+
+		return nil
+	}
+
+	if enum, ok := schema["enum"].([]interface{}); ok {
+		found := false
+		for _, allowed := range enum {
+			found = found || allowed == value
+		}
+
+		if !found {
+			return []string{fmt.Sprintf("%s is %v, not in %v", at, value, enum)}
+		}
+	}
+
+	var problems []string
+
+	switch schema["type"] {
+	case "object":
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return []string{at + " is not an object"}
+		}
+
+		required, _ := schema["required"].([]interface{})
+		for _, name := range required {
+			if _, ok := object[name.(string)]; !ok {
+				problems = append(problems, fmt.Sprintf("%s.%s is missing", at, name))
+			}
+		}
+
+		properties, _ := schema["properties"].(map[string]interface{})
+		for name, property := range object {
+			propertySchema, ok := properties[name].(map[string]interface{})
+			if !ok {
+				switch additional := schema["additionalProperties"].(type) {
+				case map[string]interface{}:
+					propertySchema = additional
+				case bool:
+					if !additional {
+						problems = append(problems, fmt.Sprintf("%s.%s is not documented", at, name))
+					}
+
+					continue
+				default:
+					continue
+				}
+			}
+
+			problems = append(problems, validateSchema(document, propertySchema, property, at+"."+name)...)
+		}
+	case "array":
+		array, ok := value.([]interface{})
+		if !ok {
+			return []string{at + " is not an array"}
+		}
+
+		items, _ := schema["items"].(map[string]interface{})
+		for i, item := range array {
+			problems = append(problems, validateSchema(document, items, item, fmt.Sprintf("%s[%d]", at, i))...)
+		}
+	case "string":
+		s, ok := value.(string)
+		if !ok {
+			return []string{at + " is not a string"}
+		}
+
+		if schema["format"] == "date-time" {
+			if _, err := time.Parse(time.RFC3339, s); err != nil {
+				problems = append(problems, at+" is not a date-time")
+			}
+		}
+	case "integer", "number":
+		n, ok := value.(float64)
+		if !ok || (schema["type"] == "integer" && n != math.Trunc(n)) {
+			return []string{fmt.Sprintf("%s is not an %s", at, schema["type"])}
+		}
+
+		if minimum, ok := schema["minimum"].(float64); ok && n < minimum {
+			problems = append(problems, fmt.Sprintf("%s is below %v", at, minimum))
+		}
+	case "boolean":
+		if _, ok := value.(bool); !ok {
+			return []string{at + " is not a boolean"}
+		}
+	}
+
+	return problems
+}
+
+// openAPIPath turns a gin route into its openapi.json path.
+func openAPIPath(route string) string {
+	segments := strings.Split(route, "/")
+	for i, segment := range segments {
+		if strings.HasPrefix(segment, ":") || strings.HasPrefix(segment, "*") {
+			segments[i] = "{" + segment[1:] + "}"
+		}
+	}
+
+	return strings.Join(segments, "/")
+}
+
+func TestEveryRouteIsDocumented(t *testing.T) {
+	r, _ := newTestRouter(t)
+
+	documented := make(map[string]bool)
+	for path, operations := range openAPI(t)["paths"].(map[string]interface{}) {
+		for method := range operations.(map[string]interface{}) {
+			documented[strings.ToUpper(method)+" "+path] = true
+		}
+	}
+
+	for _, route := range r.Routes() {
+		operation := route.Method + " " + openAPIPath(route.Path)
+
+		require.True(t, documented[operation], "%s is not documented", operation)
+		delete(documented, operation)
+	}
+
+	require.Empty(t, documented, "documented operations without a route")
+}
+
+func TestListProvidersMatchesOpenAPI(t *testing.T) {
+	r, mock := newTestRouter(t)
+
+	updatedAt := time.Date(2026, 10, 1, 12, 0, 0, 0, time.UTC)
+
+	expectQuery(mock, providersQuery).WithArgs(3).WillReturnRows(sqlmock.NewRows(providerColumns).
+		AddRow(1, "Ana", "1155550000", "s1", "Palermo", updatedAt).
+		AddRow(2, "Bea", "1155550001", "s1", "Belgrano", updatedAt).
+		AddRow(3, "Cleo", "1155550002", "s2", "Palermo", updatedAt))
+	expectQuery(mock, providerPicsQuery).WillReturnRows(sqlmock.NewRows([]string{"provider_id", "pic_url"}).
+		AddRow(1, "https://example.com/ana-1.jpg").
+		AddRow(1, "https://example.com/ana-2.jpg"))
+
+	recorder := serve(r, http.MethodGet, "/providers?limit=2", authorized())
+
+	require.Equal(t, http.StatusOK, recorder.Code, recorder.Body.String())
+	requireDocumented(t, http.MethodGet, "/providers", recorder)
+
+	cursor := recorder.Header().Get(nextCursorHeader)
+	require.NotEmpty(t, cursor)
+	require.Contains(t, recorder.Header().Get("Link"), "cursor="+cursor)
+
+	var providers []map[string]interface{}
+	require.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &providers))
+	require.Len(t, providers, 2)
+	require.Len(t, providers[0]["pics"], 2)
+	require.Empty(t, providers[1]["pics"])
+}
+
+func TestListProvidersRejectsInvalidFilters(t *testing.T) {
+	r, _ := newTestRouter(t)
+
+	for _, query := range []string{"limit=0", "hasPics=maybe", "updatedSince=yesterday", "sort=phone", "cursor=nope"} {
+		recorder := serve(r, http.MethodGet, "/providers?"+query, authorized())
+
+		require.Equal(t, http.StatusBadRequest, recorder.Code, query)
+		requireDocumented(t, http.MethodGet, "/providers", recorder)
+	}
+}
+
+func TestGetProviderMatchesOpenAPI(t *testing.T) {
+	t.Run("found", func(t *testing.T) {
+		r, mock := newTestRouter(t)
+
+		expectQuery(mock, providerByIDQuery).WithArgs(1).WillReturnRows(sqlmock.NewRows(providerColumns).
+			AddRow(1, "Ana", "1155550000", "s1", "Palermo", time.Now()))
+		expectQuery(mock, providerPicsQuery).WillReturnRows(sqlmock.NewRows([]string{"provider_id", "pic_url"}))
+
+		recorder := serve(r, http.MethodGet, "/providers/1", authorized())
+
+		require.Equal(t, http.StatusOK, recorder.Code, recorder.Body.String())
+		requireDocumented(t, http.MethodGet, "/providers/{id}", recorder)
+	})
+
+	t.Run("not found", func(t *testing.T) {
+		r, mock := newTestRouter(t)
+
+		expectQuery(mock, providerByIDQuery).WithArgs(2).WillReturnRows(sqlmock.NewRows(providerColumns))
+
+		recorder := serve(r, http.MethodGet, "/providers/2", authorized())
+
+		require.Equal(t, http.StatusNotFound, recorder.Code)
+		requireDocumented(t, http.MethodGet, "/providers/{id}", recorder)
+	})
+
+	t.Run("invalid id", func(t *testing.T) {
+		r, _ := newTestRouter(t)
+
+		recorder := serve(r, http.MethodGet, "/providers/abc", authorized())
+
+		require.Equal(t, http.StatusBadRequest, recorder.Code)
+		requireDocumented(t, http.MethodGet, "/providers/{id}", recorder)
+	})
+}
+
+func TestSummariesMatchOpenAPI(t *testing.T) {
+	r, mock := newTestRouter(t)
+
+	savedAt := time.Date(2026, 10, 1, 12, 0, 0, 0, time.UTC)
+
+	expectQuery(mock, zonesSummaryQuery).WillReturnRows(sqlmock.NewRows([]string{"id", "name", "count"}).
+		AddRow(1, "Belgrano", 0).
+		AddRow(2, "Palermo", 12))
+	expectQuery(mock, sourcesSummaryQuery).WillReturnRows(sqlmock.NewRows([]string{"id", "name", "count", "last_saved_at"}).
+		AddRow(1, "s1", 12, savedAt).
+		AddRow(2, "s2", 0, nil))
+
+	recorder := serve(r, http.MethodGet, "/zones", authorized())
+	require.Equal(t, http.StatusOK, recorder.Code, recorder.Body.String())
+	requireDocumented(t, http.MethodGet, "/zones", recorder)
+
+	recorder = serve(r, http.MethodGet, "/sources", authorized())
+	require.Equal(t, http.StatusOK, recorder.Code, recorder.Body.String())
+	requireDocumented(t, http.MethodGet, "/sources", recorder)
+}
+
+func TestErrorsMatchOpenAPI(t *testing.T) {
+	r, mock := newTestRouter(t)
+
+	recorder := serve(r, http.MethodGet, "/zones", nil)
+	require.Equal(t, http.StatusUnauthorized, recorder.Code)
+	requireDocumented(t, http.MethodGet, "/zones", recorder)
+
+	expectQuery(mock, zonesSummaryQuery).WillReturnError(fmt.Errorf("connection reset"))
+
+	recorder = serve(r, http.MethodGet, "/zones", authorized())
+	require.Equal(t, http.StatusInternalServerError, recorder.Code)
+	requireDocumented(t, http.MethodGet, "/zones", recorder)
+}
+
+// Every client shares the cached responses, whichever way it sends its key,
+// and the key never ends up in the cache.
+func TestCatalogResponsesAreCached(t *testing.T) {
+	r, mock := newTestRouter(t)
+
+	expectQuery(mock, sourcesSummaryQuery).WillReturnRows(sqlmock.NewRows([]string{"id", "name", "count", "last_saved_at"}).
+		AddRow(1, "s1", 1, time.Now()))
+	expectQuery(mock, zonesSummaryQuery).WillReturnRows(sqlmock.NewRows([]string{"id", "name", "count"}).
+		AddRow(1, "Palermo", 1))
+
+	require.NoError(t, responseCache.refresh(httptest.NewRequest(http.MethodGet, "/", nil).Context()))
+
+	first := serve(r, http.MethodGet, "/zones", authorized())
+	require.Equal(t, http.StatusOK, first.Code, first.Body.String())
+
+	etag := first.Header().Get("ETag")
+	require.NotEmpty(t, etag)
+
+	second := serve(r, http.MethodGet, "/zones?apiKey="+testAdminKey, nil)
+	require.Equal(t, http.StatusOK, second.Code, second.Body.String())
+	require.Equal(t, etag, second.Header().Get("ETag"))
+	require.Equal(t, first.Body.String(), second.Body.String())
+
+	notModified := serve(r, http.MethodGet, "/zones", http.Header{
+		"Authorization": {"Bearer " + testAdminKey},
+		"If-None-Match": {etag},
+	})
+	require.Equal(t, http.StatusNotModified, notModified.Code)
+	requireDocumented(t, http.MethodGet, "/zones", notModified)
+
+	for _, element := range responseCache.responses {
+		require.NotContains(t, element.Value.(cachedResponse).key, testAdminKey)
+	}
+}
+
+// Feeds link back to the server, so responses to http and https requests,
+// told apart through X-Forwarded-Proto behind a proxy, are cached apart.
+func TestCatalogCacheKeysIncludeTheScheme(t *testing.T) {
+	r, mock := newTestRouter(t)
+
+	expectQuery(mock, sourcesSummaryQuery).WillReturnRows(sqlmock.NewRows([]string{"id", "name", "count", "last_saved_at"}).
+		AddRow(1, "s1", 1, time.Now()))
+
+	require.NoError(t, responseCache.refresh(httptest.NewRequest(http.MethodGet, "/", nil).Context()))
+
+	expectQuery(mock, zonesSummaryQuery).WillReturnRows(sqlmock.NewRows([]string{"id", "name", "count"}).
+		AddRow(1, "Palermo", 1))
+	// The statement is prepared once, then reused.
+	mock.ExpectQuery(regexp.QuoteMeta(zonesSummaryQuery)).WillReturnRows(sqlmock.NewRows([]string{"id", "name", "count"}).
+		AddRow(1, "Palermo", 1))
+
+	plain := serve(r, http.MethodGet, "/zones", authorized())
+	require.Equal(t, http.StatusOK, plain.Code, plain.Body.String())
+
+	header := authorized()
+	header.Set("X-Forwarded-Proto", "https")
+
+	secure := serve(r, http.MethodGet, "/zones", header)
+	require.Equal(t, http.StatusOK, secure.Code, secure.Body.String())
+	require.NotEqual(t, plain.Header().Get("ETag"), secure.Header().Get("ETag"))
+
+	require.Equal(t, 2, responseCache.lru.Len())
+}
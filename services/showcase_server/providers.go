@@ -0,0 +1,103 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"sarasa/libs/postgres"
+)
+
+// listProviders serves GET /providers, a JSON array of providers like it
+// always did, one page at a time. The cursor of the next page is sent in the
+// X-Next-Cursor header and its URL in a Link rel="next" header.
+//
+// Query parameters: zone, source, q (name search), hasPics (true/false),
+// updatedSince (RFC 3339), sort (id, name or updated, "-" prefix for
+// descending), cursor and limit.
+func listProviders(c *gin.Context) {
+	filter, err := providersFilterFromQuery(c)
+	if err != nil {
+		abortWithError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	page, err := postgresSingleton.ListProviders(c.Request.Context(), filter)
+	if errors.Is(err, postgres.ErrInvalidFilter) {
+		abortWithError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	if err != nil {
+		log.Printf("Could not list providers - error: %s", err)
+		abortWithError(c, http.StatusInternalServerError, errors.New("could not list providers"))
+		return
+	}
+
+	if page.NextCursor != "" {
+		next := *c.Request.URL
+		query := next.Query()
+		query.Set("cursor", page.NextCursor)
+		next.RawQuery = query.Encode()
+
+		c.Header(nextCursorHeader, page.NextCursor)
+		c.Header("Link", "<"+catalogRequestURI(&next)+`>; rel="next"`)
+	}
+
+	c.JSON(http.StatusOK, page.Providers)
+}
+
+// nextCursorHeader holds the cursor of the next page of a listing, absent on
+// the last page.
+const nextCursorHeader = "X-Next-Cursor"
+
+func providersFilterFromQuery(c *gin.Context) (postgres.ProvidersFilter, error) {
+	filter := postgres.ProvidersFilter{
+		Zone:   c.Query("zone"),
+		Source: c.Query("source"),
+		Name:   c.Query("q"),
+		Sort:   c.Query("sort"),
+		Cursor: c.Query("cursor"),
+	}
+
+	if v, ok := c.GetQuery("hasPics"); ok {
+		hasPics, err := strconv.ParseBool(v)
+		if err != nil {
+			return filter, fmt.Errorf("hasPics must be true or false")
+		}
+
+		filter.HasPics = &hasPics
+	}
+
+	if v, ok := c.GetQuery("updatedSince"); ok {
+		updatedSince, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			return filter, fmt.Errorf("updatedSince must be an RFC 3339 timestamp")
+		}
+
+		filter.UpdatedSince = updatedSince
+	}
+
+	if v, ok := c.GetQuery("limit"); ok {
+		limit, err := strconv.Atoi(v)
+		if err != nil || limit <= 0 {
+			return filter, fmt.Errorf("limit must be a positive integer")
+		}
+
+		filter.Limit = limit
+	}
+
+	return filter, nil
+}
+
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+func abortWithError(c *gin.Context, status int, err error) {
+	c.AbortWithStatusJSON(status, errorResponse{Error: err.Error()})
+}
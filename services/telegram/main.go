@@ -1,15 +1,17 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"log"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
-	"sarasa/libs/retryHandling"
-
-	"sarasa/libs/signals"
+	"sarasa/libs/circuitBreaker"
+	"sarasa/libs/lifecycle"
 
 	"sarasa/libs/configHandling"
 
@@ -33,16 +35,43 @@ var configuration schemas.Config
 var postgresSingleton postgres.Client
 var rabbitMQSingleton rabbitMQ.Client
 var influxSingleton influxdb.Client
+var healthSingleton lifecycle.Health
+var lifecycleManager = lifecycle.NewManager(lifecycle.DefaultDrainTimeout)
+
+// lastUpdate beats every time a Telegram update is handled.
+var lastUpdate lifecycle.Heartbeat
 
 var availableZones map[string]int
 var availableProviders []schemas.Provider
+var availableProvidersMu sync.Mutex
+
+// telegramBreaker fails messages fast while the Telegram API is unreachable.
+var telegramBreaker = circuitBreaker.New("telegram", circuitBreaker.Settings{OpenTimeout: time.Minute})
 
 type botClient struct {
 	bot *tgbotapi.BotAPI
 }
 
 func init() {
-	err := configHandling.LoadConfig(&configuration, "telegram")
+	// The API answers rate limited calls with a retry after, every other
+	// error it describes is about the request itself.
+	errorHandling.RegisterClassifier(func(err error) (errorHandling.Category, bool) {
+		var apiErr tgbotapi.Error
+		if !errors.As(err, &apiErr) {
+			return errorHandling.Unknown, false
+		}
+
+		if apiErr.RetryAfter > 0 {
+			return errorHandling.Transient, true
+		}
+
+		return errorHandling.Permanent, true
+	})
+}
+
+func init() {
+	err := configHandling.LoadConfig(&configuration, "telegram",
+		configHandling.Health, configHandling.Influx, configHandling.Postgres, configHandling.RabbitMQ, configHandling.Telegram)
 	errorHandling.FailOnError(err, "Could not get configuration")
 
 	/**
@@ -50,14 +79,16 @@ func init() {
 	 */
 	err = rabbitMQSingleton.Init(configuration.RabbitMQ)
 	errorHandling.FailOnError(err, "Could not initialize rabbitMQSingleton")
+	lifecycleManager.AddService(&rabbitMQSingleton)
 
 	/**
 	 * Postgres Singleton
 	 */
 	errorHandling.FailOnError(
 		postgresSingleton.Init(configuration.Postgres), "Could not initialize PostgresSingleton")
+	lifecycleManager.AddService(&postgresSingleton)
 
-	availableZones, err = postgresSingleton.GetZones()
+	availableZones, err = postgresSingleton.GetZones(context.Background())
 	errorHandling.FailOnError(err, "Could not load zones")
 
 	/**
@@ -66,11 +97,34 @@ func init() {
 	if err := influxSingleton.Init(configuration.Influx); err != nil {
 		log.Printf("Warn - Could not initialize InfluxSingleton, error: %s", err)
 	}
+	lifecycleManager.AddService(&influxSingleton)
+
+	postgresSingleton.SetQueryObserver(influxSingleton.QueryObserver("telegram"))
+
+	influxSingleton.ObserveService("telegram")
+
+	/**
+	 * Health checks
+	 */
+	healthSingleton.AddReadinessCheck("lifecycle", lifecycleManager.ReadyCheck)
+	healthSingleton.AddReadinessCheck("config", configHandling.LoadedCheck)
+	healthSingleton.AddReadinessCheck("postgres", lifecycle.ErrorCheck(postgresSingleton.Ping))
+	healthSingleton.AddReadinessCheck("rabbitMQ", lifecycle.ErrorCheck(rabbitMQSingleton.Ping))
+	healthSingleton.AddOptionalCheck("influxDB", lifecycle.ErrorCheck(influxSingleton.Ping))
+	healthSingleton.AddOptionalCheck("lastUpdate", lastUpdate.Check(0))
+	healthSingleton.AddOptionalCheck("telegramBreaker", telegramBreaker.HealthCheck)
+	healthSingleton.AddOptionalCheck("postgresBreaker", postgresSingleton.Breaker().HealthCheck)
+	healthSingleton.AddOptionalCheck("influxDBBreaker", influxSingleton.Breaker().HealthCheck)
+
+	go func() {
+		errorHandling.LogOnError(healthSingleton.Serve(configuration.Health), "Health server stopped")
+	}()
+	lifecycleManager.AddService(&healthSingleton)
 
 	/**
 	 * Signal handling
 	 */
-	signals.SignalHandler(rabbitMQSingleton, postgresSingleton, influxSingleton)
+	lifecycleManager.HandleSignals()
 }
 
 func main() {
@@ -91,9 +145,31 @@ func main() {
 	updates, err := botClient.bot.GetUpdatesChan(u)
 	errorHandling.FailOnError(err, "Failed to create update channel")
 
-	for update := range updates {
-		go botClient.handleUpdate(update)
-	}
+	lifecycleManager.AddStopper("updates receiver", func(ctx context.Context) error {
+		botClient.bot.StopReceivingUpdates()
+		return nil
+	})
+
+	// The updates channel is never closed, so the loop stops on the root
+	// context. Updates being handled are tracked to let them finish.
+	lifecycleManager.Go(func(ctx context.Context) {
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case update := <-updates:
+				done := lifecycleManager.Track()
+
+				go func() {
+					defer done()
+
+					botClient.handleUpdate(update)
+				}()
+			}
+		}
+	})
+
+	lifecycleManager.Wait()
 }
 
 func (botClient botClient) handleUpdate(update tgbotapi.Update) {
@@ -129,7 +205,9 @@ func (botClient botClient) handleUpdate(update tgbotapi.Update) {
 			"success": true,
 		}
 
-		go errorHandling.LogOnError(
+		lastUpdate.Beat()
+
+		errorHandling.LogOnError(
 			influxSingleton.Send("telegram_process", influxTags, influxFields),
 			"Could not write to InfluxDB")
 	}
@@ -138,17 +216,23 @@ func (botClient botClient) handleUpdate(update tgbotapi.Update) {
 func (botClient botClient) handleCallbackQuery(callbackQuery *tgbotapi.CallbackQuery) {
 	var err error
 
-	if len(availableProviders) == 0 {
-		availableProviders, err = postgresSingleton.GetProviders()
-		errorHandling.FailOnError(err, "Could not load providers")
+	providers, err := getAvailableProviders(lifecycleManager.WorkContext())
+	if err != nil {
+		errorHandling.Report(err, "Could not load providers")
+		botClient.replyError(callbackQuery.Message.Chat.ID)
+
+		return
 	}
 
 	// @TODO re think this...
 	if strings.Contains(callbackQuery.Message.Text, "Providers from ") {
-		for _, provider := range availableProviders {
-			selectedProviderID, err := strconv.Atoi(callbackQuery.Data) // Data is the ProviderID
-			errorHandling.FailOnError(err, "Error converting selected provider ID to int")
+		selectedProviderID, err := strconv.Atoi(callbackQuery.Data) // Data is the ProviderID
+		if err != nil {
+			errorHandling.Report(errorHandling.NewInvalidInput(err), "Error converting selected provider ID to int")
+			return
+		}
 
+		for _, provider := range providers {
 			if provider.ID == selectedProviderID {
 				msg := tgbotapi.NewMessage(
 					callbackQuery.Message.Chat.ID,
@@ -158,7 +242,7 @@ func (botClient botClient) handleCallbackQuery(callbackQuery *tgbotapi.CallbackQ
 					),
 				)
 
-				_, err = botClient.bot.Send(msg)
+				_, err = botClient.send(msg)
 				errorHandling.LogOnError(err, "[handleCallbackQuery] Error sending message to Telegram")
 
 				mediaFiles := make([]interface{}, 0)
@@ -172,7 +256,7 @@ func (botClient botClient) handleCallbackQuery(callbackQuery *tgbotapi.CallbackQ
 
 				cfg := tgbotapi.NewMediaGroup(callbackQuery.Message.Chat.ID, mediaFiles)
 
-				_, err = botClient.bot.Send(cfg)
+				_, err = botClient.send(cfg)
 				errorHandling.LogOnError(err, "[handleCallbackQuery 1] Error sending message to Telegram")
 
 				break
@@ -183,7 +267,7 @@ func (botClient botClient) handleCallbackQuery(callbackQuery *tgbotapi.CallbackQ
 		replay := tgbotapi.NewInlineKeyboardMarkup()
 
 		maxButtons := 3 // @TODO Hardcoded logic
-		for _, provider := range availableProviders {
+		for _, provider := range providers {
 			if provider.Place != callbackQuery.Data {
 				continue
 			}
@@ -214,7 +298,7 @@ func (botClient botClient) handleCallbackQuery(callbackQuery *tgbotapi.CallbackQ
 			msg.Text = fmt.Sprintf("Providers from %s", callbackQuery.Data)
 		}
 
-		_, err = botClient.bot.Send(msg)
+		_, err = botClient.send(msg)
 		errorHandling.LogOnError(err, "[handleCallbackQuery 2] Error sending message to Telegram")
 	}
 }
@@ -229,7 +313,7 @@ func (botClient botClient) handleCommand(message *tgbotapi.Message) {
 		msg := tgbotapi.NewMessage(message.Chat.ID, "Invalid command")
 		msg.ReplyToMessageID = message.MessageID
 
-		_, err := botClient.bot.Send(msg)
+		_, err := botClient.send(msg)
 		errorHandling.LogOnError(err, "[handleCommand] Error sending message to Telegram")
 	}
 }
@@ -238,39 +322,42 @@ func (botClient botClient) handleMessage(message *tgbotapi.Message) {
 	msg := tgbotapi.NewMessage(message.Chat.ID, message.Text)
 	msg.ReplyToMessageID = message.MessageID
 
-	_, err := botClient.bot.Send(msg)
+	_, err := botClient.send(msg)
 	errorHandling.LogOnError(err, "[handleMessage] Error sending message to Telegram")
 }
 
 func (botClient botClient) commandRefresh(message *tgbotapi.Message) {
 	var err error
 
-	errorHandling.FailOnError(
-		retryHandling.Try(
-			func() error {
-				return rabbitMQSingleton.Channel.ExchangeDeclare(
-					"refresh", "fanout", true,
-					false, false, false, nil)
-			},
-			[]error{amqp.ErrClosed},
-			func() error { return rabbitMQSingleton.Restart() },
-			2, 5000*time.Millisecond,
-		),
-		"Failed to try ExchangeDeclare",
-	)
+	err = rabbitMQSingleton.Retry(lifecycleManager.WorkContext(), func(ctx context.Context) error {
+		return rabbitMQSingleton.Channel.ExchangeDeclare(
+			"refresh", "fanout", true,
+			false, false, false, nil)
+	})
+	if err != nil {
+		errorHandling.Report(err, "Failed to try ExchangeDeclare")
+		botClient.replyError(message.Chat.ID)
 
-	errorHandling.FailOnError(
-		rabbitMQSingleton.Channel.Publish(
-			"refresh", "", false, false, amqp.Publishing{Body: []byte{}}),
-		"Failed to publish refresh message",
-	)
+		return
+	}
+
+	err = rabbitMQSingleton.Retry(lifecycleManager.WorkContext(), func(ctx context.Context) error {
+		return rabbitMQSingleton.Channel.Publish(
+			"refresh", "", false, false, amqp.Publishing{Body: []byte{}})
+	})
+	if err != nil {
+		errorHandling.Report(err, "Failed to publish refresh message")
+		botClient.replyError(message.Chat.ID)
+
+		return
+	}
 
 	msg := tgbotapi.NewMessage(message.Chat.ID, "Processing refresh...")
 	msg.ReplyToMessageID = message.MessageID
 
-	availableProviders = make([]schemas.Provider, 0)
+	resetAvailableProviders()
 
-	_, err = botClient.bot.Send(msg)
+	_, err = botClient.send(msg)
 	errorHandling.LogOnError(err, "[commandRefresh] Error sending message to Telegram")
 }
 
@@ -300,6 +387,54 @@ func (botClient botClient) commandGetByZone(message *tgbotapi.Message) {
 
 	msg.ReplyMarkup = replay
 
-	_, err := botClient.bot.Send(msg)
+	_, err := botClient.send(msg)
 	errorHandling.LogOnError(err, "[commandGetByZone] Error sending message to Telegram")
 }
+
+// send sends c through telegramBreaker.
+func (botClient botClient) send(c tgbotapi.Chattable) (tgbotapi.Message, error) {
+	var message tgbotapi.Message
+
+	err := telegramBreaker.Do(func() error {
+		var err error
+		message, err = botClient.bot.Send(c)
+
+		return err
+	})
+
+	return message, err
+}
+
+// replyError tells the user their request failed, the details are only
+// logged.
+func (botClient botClient) replyError(chatID int64) {
+	msg := tgbotapi.NewMessage(chatID, "Something went wrong, please try again later")
+
+	_, err := botClient.send(msg)
+	errorHandling.LogOnError(err, "[replyError] Error sending message to Telegram")
+}
+
+// getAvailableProviders returns the cached providers list, loading it from
+// Postgres when empty. Updates are handled concurrently, so access is guarded.
+func getAvailableProviders(ctx context.Context) ([]schemas.Provider, error) {
+	availableProvidersMu.Lock()
+	defer availableProvidersMu.Unlock()
+
+	if len(availableProviders) == 0 {
+		providers, err := postgresSingleton.GetProviders(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		availableProviders = providers
+	}
+
+	return availableProviders, nil
+}
+
+func resetAvailableProviders() {
+	availableProvidersMu.Lock()
+	defer availableProvidersMu.Unlock()
+
+	availableProviders = nil
+}